@@ -0,0 +1,156 @@
+package main
+
+import (
+    "errors"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/golang-jwt/jwt/v5"
+    "gorm.io/gorm"
+)
+
+// NOTE: The prefix is how the protected group tell a personal access token from
+//       a jwt, a jwt always start with `eyJ`.
+const (
+    apiTokenPrefix     = "wrpl_"
+    apiTokenAllScopes  = "*"
+    apiTokenTouchEvery = 1 * time.Minute
+)
+
+var errApiTokenScope = errors.New("the token cant have a permission the user doesnt have")
+
+func newApiTokenSecret() (string, error) {
+    secret, err := randomHex(24)
+    if err != nil {
+        return "", err
+    }
+    return apiTokenPrefix + secret, nil
+}
+
+// NOTE: Every scope has to be a permission the user has now, losing the role
+//       later also take it away from the token (see requestHasPermission).
+func validateApiTokenScopes(db *gorm.DB, userID int, scopes []string) error {
+    if slices.Contains(scopes, apiTokenAllScopes) {
+        if len(scopes) != 1 {
+            return errors.New("`*` cant be mixed with another scope")
+        }
+        return nil
+    }
+    perms, err := userPermissions(db, userID)
+    if err != nil {
+        return err
+    }
+    for _, scope := range scopes {
+        if !slices.Contains(perms, scope) {
+            return errApiTokenScope
+        }
+    }
+    return nil
+}
+
+func apiTokenScopes(token *table.ApiToken) []string {
+    if token.TokenScopes == "" {
+        return []string{}
+    }
+    return strings.Split(token.TokenScopes, ",")
+}
+
+func bearerToken(c *fiber.Ctx) string {
+    auth := c.Get(fiber.HeaderAuthorization)
+    if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+        return strings.TrimSpace(auth[7:])
+    }
+    return ""
+}
+
+func isApiTokenRequest(c *fiber.Ctx) bool {
+    _, ok := c.Locals("api_token").(*table.ApiToken)
+    return ok
+}
+
+// NOTE: Run before the jwt middleware on the protected group. A request with a
+//       personal access token get the same `user` and `current_user` local as
+//       a jwt so every handler work the same, the jwt and session middleware
+//       is skipped for it.
+func apiTokenMiddleware(backend *Backend) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        raw := bearerToken(c)
+        if !strings.HasPrefix(raw, apiTokenPrefix) {
+            return c.Next()
+        }
+
+        var token table.ApiToken
+        res := backend.db.InnerJoins("User").
+            Where("token_hash = ? AND token_revoked IS NULL", hashRefreshSecret(raw)).
+            First(&token)
+        if res.Error != nil || (token.TokenExpires != nil && token.TokenExpires.Before(time.Now())) {
+            return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                "success":    false,
+                "message":    "Invalid, expired or revoked access token.",
+                "error_code": -1,
+                "data":       nil,
+            })
+        }
+
+        now := time.Now()
+        if token.TokenLastUsed == nil || now.Sub(*token.TokenLastUsed) > apiTokenTouchEvery || token.TokenLastIP != c.IP() {
+            backend.db.Model(&table.ApiToken{}).Where("id = ?", token.ID).Updates(map[string]any{
+                "token_last_used": now,
+                "token_last_ip":   c.IP(),
+            })
+        }
+
+        admin := 0
+        if requestScopeAllows(apiTokenScopes(&token), permAdminPanel) {
+            panel, err := userHasPermission(backend.db, token.UserId, permAdminPanel)
+            if err != nil {
+                return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                    "success":    false,
+                    "message":    "Failed to check the access token.",
+                    "error_code": -1,
+                    "data":       nil,
+                })
+            }
+            if panel {
+                admin = 1
+            }
+        }
+
+        c.Locals("user", &jwt.Token{
+            Valid: true,
+            Claims: jwt.MapClaims{
+                "sub":   strconv.Itoa(token.UserId),
+                "email": token.User.UserEmail,
+                "admin": admin,
+                "pat":   token.ID,
+            },
+        })
+        c.Locals("user_id", token.UserId)
+        c.Locals("current_user", &token.User)
+        c.Locals("api_token", &token)
+        return c.Next()
+    }
+}
+
+// NOTE: A session request is not limited, a token request only get the
+//       permission on its scope.
+func requestScopeAllows(scopes []string, perm string) bool {
+    return slices.Contains(scopes, apiTokenAllScopes) || slices.Contains(scopes, perm)
+}
+
+// NOTE: Managing the token itself need a real login, a leaked token cant make more.
+func requireSession(c *fiber.Ctx) error {
+    if isApiTokenRequest(c) {
+        return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+            "success":    false,
+            "message":    "This function cant be used with an access token.",
+            "error_code": -1,
+            "data":       nil,
+        })
+    }
+    return c.Next()
+}
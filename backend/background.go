@@ -0,0 +1,91 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "time"
+    "webrpl/table"
+
+    "gorm.io/gorm"
+)
+
+// NOTE: Everything that need to run without a request, started once from main.
+//       The outbox has its own loop because a new email wake it up right away,
+//       the rest is on the scheduler.
+func startBackgroundTasks(backend *Backend) {
+    go func() {
+        ticker := time.NewTicker(outboxInterval)
+        defer ticker.Stop()
+        for {
+            // A full batch mean there is probably more waiting.
+            if processOutbox(backend) == outboxBatchSize {
+                continue
+            }
+            select {
+            case <-ticker.C:
+            case <-backend.outboxWake:
+            }
+        }
+    }()
+
+    backend.scheduler = newScheduler(backend)
+    registerJobs(backend.scheduler)
+    backend.scheduler.start()
+}
+
+// NOTE: The schedule is a cron, see parseCron. A new job only need a line here.
+func registerJobs(s *scheduler) {
+    s.register("cert-mail", "* * * * *", "Mail the certificate link of the ended event.", sendCertificateMails)
+    s.register("account-delete", "0 * * * *", "Anonymize the account that passed the deletion grace period.", processAccountDeletions)
+    s.register("otp-cleanup", "*/5 * * * *", "Remove the expired and used OTP.", CleanupOTPTable)
+    s.register("otp-request-cleanup", "15 * * * *", "Forget the OTP request older than a day.", cleanupOTPRequests)
+    s.register("security-log-cleanup", "30 3 * * *", "Remove the security log older than a year.", cleanupSecurityLog)
+    s.register("orphan-files", "0 4 * * *", "Remove the uploaded file that nothing point to anymore.", collectOrphanFiles)
+    s.register("cert-zip-cleanup", "*/10 * * * *", "Remove the certificate zip older than an hour.", cleanupCertBulkJobs)
+    s.register("job-history-cleanup", "45 3 * * *", "Remove the job run older than 30 days.", cleanupJobHistory)
+}
+
+// NOTE: Mail the certificate link to every attended participant of an ended event
+//       that already have a template. The sent flag is set together with the
+//       outbox entry so a restart didnt queue it twice.
+func sendCertificateMails(backend *Backend) error {
+    var participants []table.EventParticipant
+    res := backend.db.Preload("User").Preload("Event").
+        Where("eventp_come = ? AND eventp_wait = ? AND eventp_cert_sent = ?", true, false, false).
+        Where("event_id IN (?)", backend.db.Model(&table.Event{}).Select("id").Where("event_dend < ?", time.Now())).
+        Where("event_id IN (?)", backend.db.Model(&table.CertTemplate{}).Select("event_id")).
+        Order("id ASC").
+        Find(&participants)
+    if res.Error != nil {
+        return fmt.Errorf("failed to fetch the certificate to be mailed, %w", res.Error)
+    }
+
+    var errs []error
+
+    for _, evPart := range participants {
+        link := fmt.Sprintf("%s/api/certificate/%s", backend.publicURL, evPart.EventPCode)
+        mail, err := buildEmail(backend, evPart.User.UserEmail,
+            fmt.Sprintf("Your certificate for %s", evPart.Event.EventName),
+            "certificate", map[string]any{
+                "Name":  evPart.User.UserFullName,
+                "Event": evPart.Event.EventName,
+                "Link":  link,
+            },
+            fmt.Sprintf("Hi %s,\n\nThank you for attending \"%s\". Your certificate is available at :\n%s\n\nThe PDF version is at :\n%s.pdf\n",
+                evPart.User.UserFullName, evPart.Event.EventName, link, link))
+        if err != nil {
+            return fmt.Errorf("failed to build the certificate email, %w", err)
+        }
+
+        err = backend.db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Model(&evPart).Update("eventp_cert_sent", true).Error; err != nil {
+                return err
+            }
+            return queueEmail(backend, tx, mail)
+        })
+        if err != nil {
+            errs = append(errs, fmt.Errorf("participant %d, %w", evPart.ID, err))
+        }
+    }
+    return errors.Join(errs...)
+}
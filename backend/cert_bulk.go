@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const certBulkJobTTL = 1 * time.Hour
+
+type certBulkStatus string
+
+const (
+	certBulkRunning certBulkStatus = "running"
+	certBulkDone    certBulkStatus = "done"
+	certBulkFailed  certBulkStatus = "failed"
+)
+
+// NOTE: One zip generation of every certificate of an event, the zip is
+//       written to a temp file so big event didnt need to stay in memory.
+type certBulkJob struct {
+	ID        string
+	EventID   int
+	Format    string
+	Owner     int
+	CreatedAt time.Time
+
+	total  int64
+	done   int64
+	failed int64
+
+	mutex    sync.Mutex
+	status   certBulkStatus
+	err      string
+	zipPath  string
+	finished time.Time
+}
+
+type certBulkJobs struct {
+	mutex sync.Mutex
+	jobs  map[string]*certBulkJob
+}
+
+func newCertBulkJobs() *certBulkJobs {
+	return &certBulkJobs{
+		jobs: make(map[string]*certBulkJob),
+	}
+}
+
+func (job *certBulkJob) expired() bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return job.status != certBulkRunning && time.Since(job.finished) > certBulkJobTTL
+}
+
+// NOTE: An expired job is gone right away even before the cleanup remove it.
+func (j *certBulkJobs) get(id string) *certBulkJob {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	job := j.jobs[id]
+	if job == nil || job.expired() {
+		return nil
+	}
+	return job
+}
+
+func (j *certBulkJobs) add(job *certBulkJob) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.jobs[job.ID] = job
+}
+
+// NOTE: Drop the finished job older than certBulkJobTTL and its zip.
+func (j *certBulkJobs) expire() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	removed := 0
+	for id, old := range j.jobs {
+		if !old.expired() {
+			continue
+		}
+		old.mutex.Lock()
+		zipPath := old.zipPath
+		old.mutex.Unlock()
+		if zipPath != "" {
+			if err := os.Remove(zipPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove the certificate zip %s: %v", zipPath, err)
+			}
+		}
+		delete(j.jobs, id)
+		removed++
+	}
+	return removed
+}
+
+func cleanupCertBulkJobs(backend *Backend) error {
+	if n := backend.certJobs.expire(); n > 0 {
+		log.Printf("Removed %d expired certificate zip", n)
+	}
+	return nil
+}
+
+func (job *certBulkJob) Progress() map[string]any {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return map[string]any{
+		"id":       job.ID,
+		"event_id": job.EventID,
+		"format":   job.Format,
+		"status":   job.status,
+		"total":    atomic.LoadInt64(&job.total),
+		"done":     atomic.LoadInt64(&job.done),
+		"failed":   atomic.LoadInt64(&job.failed),
+		"error":    job.err,
+	}
+}
+
+func (job *certBulkJob) finish(status certBulkStatus, err error) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.status = status
+	job.finished = time.Now()
+	if err != nil {
+		job.err = err.Error()
+	}
+}
+
+var certBulkUnsafeName = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func certBulkFileName(evPart *table.EventParticipant, format string) string {
+	name := strings.Trim(certBulkUnsafeName.ReplaceAllString(evPart.User.UserFullName, "_"), "_")
+	if name == "" {
+		name = "participant"
+	}
+	return fmt.Sprintf("%s-%d.%s", name, evPart.ID, format)
+}
+
+type certBulkResult struct {
+	name string
+	data []byte
+}
+
+// NOTE: Render every attended participant on a worker pool, the zip writer
+//       is not safe to share so only this goroutine write into it.
+func certBulkRun(backend *Backend, job *certBulkJob, event *table.Event, cerTemp *table.CertTemplate) {
+	zipFile, err := os.CreateTemp("", fmt.Sprintf("cert-event-%d-*.zip", event.ID))
+	if err != nil {
+		job.finish(certBulkFailed, err)
+		return
+	}
+	defer zipFile.Close()
+
+	job.mutex.Lock()
+	job.zipPath = zipFile.Name()
+	job.mutex.Unlock()
+
+	var participants []table.EventParticipant
+	res := backend.db.Preload("User").Where("event_id = ? AND eventp_come = ?", event.ID, true).Order("id ASC").Find(&participants)
+	if res.Error != nil {
+		job.finish(certBulkFailed, res.Error)
+		return
+	}
+	atomic.StoreInt64(&job.total, int64(len(participants)))
+
+	work := make(chan *table.EventParticipant)
+	results := make(chan certBulkResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for evPart := range work {
+				evPart.Event = *event
+
+				var buf bytes.Buffer
+				var err error
+				if job.Format == "pdf" {
+					err = certWritePDF(backend, &buf, evPart.EventPCode, evPart, cerTemp)
+				} else {
+					err = backend.engine.Render(&buf, certTemplateName(cerTemp), certTemplateData(evPart.EventPCode, evPart))
+				}
+				if err != nil {
+					log.Printf("Failed to render certificate of participant %d: %v", evPart.ID, err)
+					atomic.AddInt64(&job.failed, 1)
+					continue
+				}
+				results <- certBulkResult{name: certBulkFileName(evPart, job.Format), data: buf.Bytes()}
+			}
+		}()
+	}
+
+	go func() {
+		for i := range participants {
+			work <- &participants[i]
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	archive := zip.NewWriter(zipFile)
+	var writeErr error
+	for result := range results {
+		if writeErr != nil {
+			continue
+		}
+		w, err := archive.Create(result.name)
+		if err == nil {
+			_, err = w.Write(result.data)
+		}
+		if err != nil {
+			writeErr = err
+			continue
+		}
+		atomic.AddInt64(&job.done, 1)
+	}
+	if writeErr == nil {
+		writeErr = archive.Close()
+	}
+	if writeErr != nil {
+		job.finish(certBulkFailed, writeErr)
+		return
+	}
+
+	job.finish(certBulkDone, nil)
+}
+
+// NOTE: Same check as the certificate room but done once for the whole event.
+func certBulkPrepare(db *gorm.DB, eventID int) (*table.Event, *table.CertTemplate, *certLookupError) {
+	var event table.Event
+	res := db.Where("id = ?", eventID).First(&event)
+	if res.Error != nil {
+		return nil, nil, &certLookupError{fiber.StatusBadRequest, 6, "The specified event ID didnt exist."}
+	}
+
+	if event.EventDEnd.After(time.Now()) {
+		return nil, nil, &certLookupError{fiber.StatusBadRequest, 7, "The event is not done yet."}
+	}
+
+	var cerTemp table.CertTemplate
+	res = db.Where("event_id = ?", eventID).First(&cerTemp)
+	if res.Error != nil {
+		return nil, nil, &certLookupError{fiber.StatusInternalServerError, 8, fmt.Sprintf("Failed to fetch certificate template from the db, %v", res.Error)}
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("./static/%s", cerTemp.CertTemplate)); os.IsNotExist(err) {
+		return nil, nil, &certLookupError{fiber.StatusBadRequest, 9, "The Certificate template file didnt exist."}
+	}
+
+	return &event, &cerTemp, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertBulkJobsExpire(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "old.zip")
+	if err := os.WriteFile(zipPath, []byte("zip"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := newCertBulkJobs()
+	jobs.add(&certBulkJob{ID: "old", status: certBulkDone, zipPath: zipPath, finished: time.Now().Add(-2 * certBulkJobTTL)})
+	jobs.add(&certBulkJob{ID: "new", status: certBulkDone, finished: time.Now()})
+	jobs.add(&certBulkJob{ID: "running", status: certBulkRunning})
+
+	if jobs.get("old") != nil {
+		t.Fatal("an expired job can still be looked up")
+	}
+	if n := jobs.expire(); n != 1 {
+		t.Fatalf("expire removed %d job, want 1", n)
+	}
+	if _, err := os.Stat(zipPath); !os.IsNotExist(err) {
+		t.Fatalf("the zip of the expired job is still there: %v", err)
+	}
+	if jobs.get("new") == nil || jobs.get("running") == nil {
+		t.Fatal("a job that is not expired was removed")
+	}
+}
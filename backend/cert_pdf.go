@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/webp"
+	"golang.org/x/net/html"
+)
+
+// NOTE: The editor canvas is 800x600 px, the pdf use 1pt for every 1px so
+//       the absolute position from the index.html can be used as is.
+const (
+	certCanvasWidth   = 800.0
+	certCanvasHeight  = 600.0
+	certObjectPadding = 10.0
+)
+
+// NOTE: One `.template-object` div that the editor write into the index.html.
+type certObject struct {
+	Left      float64
+	Top       float64
+	Width     float64
+	Height    float64
+	FontSize  float64
+	Color     [3]int
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Text      string
+}
+
+var certRGBRegex = regexp.MustCompile(`rgba?\(\s*(\d+)\s*,\s*(\d+)\s*,\s*(\d+)`)
+
+func certParsePx(value string) float64 {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "px"))
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+func certParseColor(value string) [3]int {
+	value = strings.TrimSpace(value)
+	if m := certRGBRegex.FindStringSubmatch(value); m != nil {
+		r, _ := strconv.Atoi(m[1])
+		g, _ := strconv.Atoi(m[2])
+		b, _ := strconv.Atoi(m[3])
+		return [3]int{r, g, b}
+	}
+	if strings.HasPrefix(value, "#") {
+		hex := value[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if n, err := strconv.ParseUint(hex, 16, 32); err == nil && len(hex) == 6 {
+			return [3]int{int(n >> 16 & 0xff), int(n >> 8 & 0xff), int(n & 0xff)}
+		}
+	}
+	return [3]int{0, 0, 0}
+}
+
+func certParseStyle(style string) map[string]string {
+	result := make(map[string]string)
+	for _, decl := range strings.Split(style, ";") {
+		key, value, found := strings.Cut(decl, ":")
+		if !found {
+			continue
+		}
+		result[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return result
+}
+
+func certNodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// NOTE: Take the already rendered template (so the {{ }} is already filled) and
+//       collect every positioned text object on the canvas.
+func certParseObjects(r io.Reader) ([]certObject, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []certObject
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "div" {
+			var class, style string
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "class":
+					class = attr.Val
+				case "style":
+					style = attr.Val
+				}
+			}
+			if strings.Contains(" "+class+" ", " template-object ") {
+				css := certParseStyle(style)
+				fontSize := certParsePx(css["font-size"])
+				if fontSize <= 0 {
+					fontSize = 16
+				}
+				weight := css["font-weight"]
+				bold := weight == "bold" || weight == "bolder"
+				if w, err := strconv.Atoi(weight); err == nil && w >= 600 {
+					bold = true
+				}
+				objects = append(objects, certObject{
+					Left:      certParsePx(css["left"]),
+					Top:       certParsePx(css["top"]),
+					Width:     certParsePx(css["width"]),
+					Height:    certParsePx(css["height"]),
+					FontSize:  fontSize,
+					Color:     certParseColor(css["color"]),
+					Bold:      bold,
+					Italic:    css["font-style"] == "italic",
+					Underline: strings.Contains(css["text-decoration"], "underline"),
+					Text:      certNodeText(n),
+				})
+				return
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+
+	return objects, nil
+}
+
+// NOTE: fpdf only know png, jpg and gif. The editor accept webp too but still
+//       save it as bg.png so convert it to a real png first.
+func certLoadBackground(path string) ([]byte, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return data, "PNG", nil
+	case "image/jpeg":
+		return data, "JPG", nil
+	case "image/gif":
+		return data, "GIF", nil
+	case "image/webp":
+		img, err := webp.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "PNG", nil
+	}
+	return nil, "", fmt.Errorf("unsupported background image type for %s", path)
+}
+
+// NOTE: fpdf swap the width and height of the size for "L", the size is
+//       already landscape so it is given as "P" to keep it as it is.
+func certNewPDF() *fpdf.Fpdf {
+	pdf := fpdf.NewCustom(&fpdf.InitType{
+		OrientationStr: "P",
+		UnitStr:        "pt",
+		Size:           fpdf.SizeType{Wd: certCanvasWidth, Ht: certCanvasHeight},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+	return pdf
+}
+
+// NOTE: bgPath can be empty or missing, the page is just left white.
+func certRenderPDF(out io.Writer, objects []certObject, bgPath string) error {
+	pdf := certNewPDF()
+
+	if bgPath != "" {
+		if _, err := os.Stat(bgPath); err == nil {
+			data, imgType, err := certLoadBackground(bgPath)
+			if err != nil {
+				return err
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+
+			// Same as `background-size: cover; background-position: center;`
+			scale := certCanvasWidth / float64(cfg.Width)
+			if s := certCanvasHeight / float64(cfg.Height); s > scale {
+				scale = s
+			}
+			w := float64(cfg.Width) * scale
+			h := float64(cfg.Height) * scale
+
+			opt := fpdf.ImageOptions{ImageType: imgType}
+			pdf.RegisterImageOptionsReader("bg", opt, bytes.NewReader(data))
+			pdf.ClipRect(0, 0, certCanvasWidth, certCanvasHeight, false)
+			pdf.ImageOptions("bg", (certCanvasWidth-w)/2, (certCanvasHeight-h)/2, w, h, false, opt, 0, "")
+			pdf.ClipEnd()
+		}
+	}
+
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	for _, obj := range objects {
+		style := ""
+		if obj.Bold {
+			style += "B"
+		}
+		if obj.Italic {
+			style += "I"
+		}
+		if obj.Underline {
+			style += "U"
+		}
+		pdf.SetFont("Helvetica", style, obj.FontSize)
+		pdf.SetTextColor(obj.Color[0], obj.Color[1], obj.Color[2])
+
+		// The object got `overflow: hidden` and `box-sizing: border-box` with 10px padding.
+		padding := certObjectPadding
+		if obj.Width <= 2*padding || obj.Height <= 2*padding {
+			padding = 0
+		}
+		innerWidth := obj.Width - 2*padding
+		text := tr(obj.Text)
+		if pdf.GetStringWidth(text) > innerWidth {
+			// Same as `text-overflow: ellipsis`
+			for len(text) > 0 && pdf.GetStringWidth(text+"...") > innerWidth {
+				text = text[:len(text)-1]
+			}
+			text += "..."
+		}
+		pdf.ClipRect(obj.Left, obj.Top, obj.Width, obj.Height, false)
+		pdf.SetXY(obj.Left+padding, obj.Top+padding)
+		pdf.CellFormat(innerWidth, obj.Height-2*padding, text, "", 0, "CM", false, 0, "")
+		pdf.ClipEnd()
+	}
+
+	return pdf.Output(out)
+}
@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestCertPDFIsLandscape(t *testing.T) {
+	pdf := certNewPDF()
+	w, h := pdf.GetPageSize()
+	if w != certCanvasWidth || h != certCanvasHeight {
+		t.Fatalf("page is %vx%v, want %vx%v", w, h, certCanvasWidth, certCanvasHeight)
+	}
+	if w <= h {
+		t.Fatalf("page is %vx%v, want the width bigger than the height", w, h)
+	}
+}
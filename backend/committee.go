@@ -0,0 +1,65 @@
+package main
+
+import (
+    "errors"
+    "webrpl/table"
+
+    "gorm.io/gorm"
+)
+
+type committeePerm string
+
+const (
+    committeeCheckIn      committeePerm = "checkin"
+    committeeEditEvent    committeePerm = "edit_event"
+    committeeCert         committeePerm = "cert"
+    committeeParticipants committeePerm = "participants"
+    committeeLead         committeePerm = "lead"
+)
+
+// NOTE: The admin check is still on the caller, this only look at the event participant.
+func committeeHas(evPart *table.EventParticipant, perm committeePerm) bool {
+    if evPart.EventPRole != table.CommitteeU {
+        return false
+    }
+    if evPart.EventPLead {
+        return true
+    }
+
+    switch perm {
+    case committeeCheckIn:
+        return evPart.EventPPermCheckIn
+    case committeeEditEvent:
+        return evPart.EventPPermEditEvent
+    case committeeCert:
+        return evPart.EventPPermCert
+    case committeeParticipants:
+        return evPart.EventPPermParticipants
+    }
+    return false
+}
+
+func eventCommitteeCan(backend *Backend, userID int, eventID int, perm committeePerm) (bool, error) {
+    var evPart table.EventParticipant
+    res := backend.db.Where("user_id = ? AND event_id = ?", userID, eventID).First(&evPart)
+    if res.Error != nil {
+        if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+            return false, nil
+        }
+        return false, res.Error
+    }
+
+    return committeeHas(&evPart, perm), nil
+}
+
+// NOTE: A new committee start with no flag at all, joining as committee
+//       give nothing until the lead or admin grant it with
+//       event-participate-committee-perm. Changing the role reset the flags.
+func setCommitteeRole(evPart *table.EventParticipant, role table.UserEventRoleEnum) {
+    evPart.EventPRole = role
+    evPart.EventPLead = false
+    evPart.EventPPermCheckIn = false
+    evPart.EventPPermEditEvent = false
+    evPart.EventPPermCert = false
+    evPart.EventPPermParticipants = false
+}
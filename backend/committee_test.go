@@ -0,0 +1,29 @@
+package main
+
+import (
+    "testing"
+    "webrpl/table"
+)
+
+func TestNewCommitteeHasNoPermission(t *testing.T) {
+    var evPart table.EventParticipant
+    setCommitteeRole(&evPart, table.CommitteeU)
+
+    for _, perm := range []committeePerm{committeeCheckIn, committeeEditEvent, committeeCert, committeeParticipants, committeeLead} {
+        if committeeHas(&evPart, perm) {
+            t.Fatalf("a new committee already has %s", perm)
+        }
+    }
+
+    evPart.EventPPermCheckIn = true
+    if !committeeHas(&evPart, committeeCheckIn) || committeeHas(&evPart, committeeCert) {
+        t.Fatal("a granted flag should only give that permission")
+    }
+
+    evPart.EventPLead = true
+    setCommitteeRole(&evPart, table.NormalU)
+    setCommitteeRole(&evPart, table.CommitteeU)
+    if evPart.EventPLead || evPart.EventPPermCheckIn {
+        t.Fatal("changing the role should reset the flags")
+    }
+}
@@ -2,6 +2,7 @@ package main
 
 import (
     "log"
+    "time"
     "gorm.io/driver/sqlite"
     "gorm.io/gorm"
     "webrpl/table"
@@ -31,11 +32,55 @@ func migrate_db(db *gorm.DB) error {
         log.Fatal("failed to migrate database:", err)
         return err
     }
+    err = db.AutoMigrate(&table.OTPRequest{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    // NOTE: Before the waitlist exist every event got hardcoded event_max of 1,
+    //       so reset it to 0 (no limit) the first time the column is added.
+    resetEventMax := db.Migrator().HasTable(&table.EventParticipant{}) &&
+        !db.Migrator().HasColumn(&table.EventParticipant{}, "eventp_wait")
+    // NOTE: Dont mail the certificate of every old event the first time the
+    //       sent flag is added, count them as already sent.
+    markCertSent := db.Migrator().HasTable(&table.EventParticipant{}) &&
+        !db.Migrator().HasColumn(&table.EventParticipant{}, "eventp_cert_sent")
+    // NOTE: Committee from before the permission flag could do everything, keep it that way.
+    grantCommittee := db.Migrator().HasTable(&table.EventParticipant{}) &&
+        !db.Migrator().HasColumn(&table.EventParticipant{}, "eventp_perm_checkin")
     err = db.AutoMigrate(&table.EventParticipant{})
     if err != nil {
         log.Fatal("failed to migrate database:", err)
         return err
     }
+    if grantCommittee {
+        err = db.Model(&table.EventParticipant{}).Where("eventp_role = ?", table.CommitteeU).Updates(map[string]any{
+            "eventp_perm_checkin":      true,
+            "eventp_perm_edit_event":   true,
+            "eventp_perm_cert":         true,
+            "eventp_perm_participants": true,
+        }).Error
+        if err != nil {
+            log.Fatal("failed to migrate database:", err)
+            return err
+        }
+    }
+    if markCertSent {
+        err = db.Model(&table.EventParticipant{}).
+            Where("event_id IN (?)", db.Model(&table.Event{}).Select("id").Where("event_dend < ?", time.Now())).
+            Update("eventp_cert_sent", true).Error
+        if err != nil {
+            log.Fatal("failed to migrate database:", err)
+            return err
+        }
+    }
+    if resetEventMax {
+        err = db.Model(&table.Event{}).Where("event_max = ?", 1).Update("event_max", 0).Error
+        if err != nil {
+            log.Fatal("failed to migrate database:", err)
+            return err
+        }
+    }
     err = db.AutoMigrate(&table.EventMaterial{})
     if err != nil {
         log.Fatal("failed to migrate database:", err)
@@ -46,5 +91,67 @@ func migrate_db(db *gorm.DB) error {
         log.Fatal("failed to migrate database:", err)
         return err
     }
+    err = db.AutoMigrate(&table.EmailOutbox{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    // NOTE: The mail sent before the body was cleared on send can still hold
+    //       an otp code, clear them too.
+    err = db.Model(&table.EmailOutbox{}).
+        Where("status = ? AND (mail_text <> '' OR mail_html <> '')", table.OutboxSent).
+        Updates(map[string]any{"mail_text": "", "mail_html": ""}).Error
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    err = db.AutoMigrate(&table.Session{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    err = db.AutoMigrate(&table.ApiToken{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    err = db.AutoMigrate(&table.LoginThrottle{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    err = db.AutoMigrate(&table.SecurityEvent{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    err = db.AutoMigrate(&table.ScheduledJob{}, &table.JobRun{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    err = db.AutoMigrate(&table.UserTOTP{}, &table.TOTPRecoveryCode{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    // NOTE: The user from before the role only have the old UserRole int, they
+    //       get a role from it once when the table is made.
+    legacyRoles := !db.Migrator().HasTable(&table.RoleAssignment{})
+    err = db.AutoMigrate(&table.Permission{}, &table.Role{}, &table.RoleAssignment{})
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
+    err = seedRBAC(db, legacyRoles)
+    if err != nil {
+        log.Fatal("failed to seed the role:", err)
+        return err
+    }
+    err = cascadeDeletedEvents(db)
+    if err != nil {
+        log.Fatal("failed to migrate database:", err)
+        return err
+    }
     return nil
 }
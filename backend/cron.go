@@ -0,0 +1,138 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// NOTE: The usual 5 field cron, `minute hour day-of-month month day-of-week`.
+//       A field is `*`, a number, a range `a-b`, a step `*/n` or `a-b/n` and a
+//       list of those with `,`. Sunday is 0 (7 work too). Like cron, when both
+//       the day of month and the day of week is set the day match either.
+type cronSchedule struct {
+    minute uint64
+    hour   uint64
+    dom    uint64
+    month  uint64
+    dow    uint64
+    anyDom bool
+    anyDow bool
+}
+
+var cronAliases = map[string]string{
+    "@hourly":  "0 * * * *",
+    "@daily":   "0 0 * * *",
+    "@weekly":  "0 0 * * 0",
+    "@monthly": "0 0 1 * *",
+}
+
+func parseCronField(field string, min int, max int) (uint64, error) {
+    var set uint64
+    for _, part := range strings.Split(field, ",") {
+        step := 1
+        if i := strings.Index(part, "/"); i >= 0 {
+            n, err := strconv.Atoi(part[i+1:])
+            if err != nil || n <= 0 {
+                return 0, fmt.Errorf("invalid step in %q", part)
+            }
+            step = n
+            part = part[:i]
+        }
+
+        lo, hi := min, max
+        if part != "*" {
+            bounds := strings.SplitN(part, "-", 2)
+            n, err := strconv.Atoi(bounds[0])
+            if err != nil {
+                return 0, fmt.Errorf("invalid value %q", part)
+            }
+            lo, hi = n, n
+            if len(bounds) == 2 {
+                if hi, err = strconv.Atoi(bounds[1]); err != nil {
+                    return 0, fmt.Errorf("invalid range %q", part)
+                }
+            } else if step > 1 {
+                hi = max
+            }
+        }
+        if lo < min || hi > max || lo > hi {
+            return 0, fmt.Errorf("%q is out of %d-%d", part, min, max)
+        }
+        for v := lo; v <= hi; v += step {
+            set |= 1 << uint(v)
+        }
+    }
+    return set, nil
+}
+
+func parseCron(spec string) (*cronSchedule, error) {
+    if alias, ok := cronAliases[spec]; ok {
+        spec = alias
+    }
+    fields := strings.Fields(spec)
+    if len(fields) != 5 {
+        return nil, fmt.Errorf("invalid cron %q, need 5 field", spec)
+    }
+
+    var s cronSchedule
+    var err error
+    if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+        return nil, err
+    }
+    if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+        return nil, err
+    }
+    if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+        return nil, err
+    }
+    if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+        return nil, err
+    }
+    if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+        return nil, err
+    }
+    if s.dow&(1<<7) != 0 {
+        s.dow |= 1
+    }
+    s.anyDom = fields[2] == "*"
+    s.anyDow = fields[4] == "*"
+    return &s, nil
+}
+
+func (s *cronSchedule) dayMatch(t time.Time) bool {
+    dom := s.dom&(1<<uint(t.Day())) != 0
+    dow := s.dow&(1<<uint(t.Weekday())) != 0
+    if s.anyDom || s.anyDow {
+        return dom && dow
+    }
+    return dom || dow
+}
+
+// NOTE: The first minute after t that match, zero time when nothing match in
+//       the next 5 year (eg. 30 of february).
+func (s *cronSchedule) next(t time.Time) time.Time {
+    t = t.Truncate(time.Minute).Add(time.Minute)
+    limit := t.AddDate(5, 0, 0)
+    for t.Before(limit) {
+        if s.month&(1<<uint(t.Month())) == 0 {
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+            continue
+        }
+        if !s.dayMatch(t) {
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+            continue
+        }
+        if s.hour&(1<<uint(t.Hour())) == 0 {
+            t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+            continue
+        }
+        if s.minute&(1<<uint(t.Minute())) == 0 {
+            t = t.Add(time.Minute)
+            continue
+        }
+        return t
+    }
+    return time.Time{}
+}
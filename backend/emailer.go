@@ -5,25 +5,129 @@ package main
 //   https://www.mailjerry.com/create-gmail-app-password
 
 import (
+    "bytes"
     "log"
-    gomail "gopkg.in/mail.v2"
+    "math"
+    "time"
+    "webrpl/table"
+
+    "gorm.io/gorm"
+)
+
+// NOTE: After this much failed attempt the mail is marked dead and only an
+//       admin resend will try it again.
+const (
+    outboxMaxAttempts = 8
+    outboxBaseDelay   = 30 * time.Second
+    outboxMaxDelay    = 6 * time.Hour
+    outboxInterval    = 15 * time.Second
+    outboxBatchSize   = 50
 )
 
-func sendEmailTo(backend *Backend, to string, subject string, body string) bool {
-    message := gomail.NewMessage()
+// NOTE: The html part come from static-hidden/email/<name>.html rendered with data,
+//       the text part is for the client that didnt show html.
+func buildEmail(backend *Backend, to string, subject string, name string, data map[string]any, text string) (Mail, error) {
+    var buf bytes.Buffer
+    err := backend.engine.Render(&buf, "email/"+name, data)
+    if err != nil {
+        return Mail{}, err
+    }
+    return Mail{
+        To:      to,
+        Subject: subject,
+        Text:    text,
+        HTML:    buf.String(),
+    }, nil
+}
 
-    message.SetHeader("From", backend.email)
-    message.SetHeader("To", to)
-    message.SetHeader("Subject", subject)
+func sendEmailTo(backend *Backend, mail Mail) error {
+    if err := backend.mailer.Send(mail); err != nil {
+        log.Printf("Failed to send email to %s: %v", mail.To, err)
+        return err
+    }
 
-    message.SetBody("text/plain", body)
-    dialer := gomail.NewDialer("smtp.gmail.com", 587, backend.email, backend.emailpass)
+    log.Printf("Email sent successfully to %s!", mail.To)
+    return nil
+}
 
-    if err := dialer.DialAndSend(message); err != nil {
-        log.Println("Error:", err)
-        return false
+// NOTE: Put the mail on the outbox, the background worker is the one that send it.
+//       Pass a transaction as db to only queue it if the rest is commited.
+func queueEmail(backend *Backend, db *gorm.DB, mail Mail) error {
+    res := db.Create(&table.EmailOutbox{
+        MailTo:      mail.To,
+        MailSubject: mail.Subject,
+        MailText:    mail.Text,
+        MailHTML:    mail.HTML,
+        Status:      table.OutboxPending,
+        NextAttempt: time.Now(),
+    })
+    if res.Error != nil {
+        return res.Error
     }
+    wakeOutbox(backend)
+    return nil
+}
 
-    log.Println("Email sent successfully!")
-    return true
+func wakeOutbox(backend *Backend) {
+    select {
+    case backend.outboxWake <- struct{}{}:
+    default:
+    }
+}
+
+// NOTE: 30s, 1m, 2m, 4m ... capped at outboxMaxDelay.
+func outboxBackoff(attempts int) time.Duration {
+    delay := time.Duration(float64(outboxBaseDelay) * math.Pow(2, float64(attempts-1)))
+    if delay <= 0 || delay > outboxMaxDelay {
+        return outboxMaxDelay
+    }
+    return delay
+}
+
+// NOTE: Send every pending mail that is due, return how many is processed so
+//       the worker know to go again right away if the batch is full. The body of
+//       a sent mail is cleared, it can hold an otp code and is never needed again.
+func processOutbox(backend *Backend) int {
+    var pending []table.EmailOutbox
+    res := backend.db.Where("status = ? AND next_attempt <= ?", table.OutboxPending, time.Now()).
+        Order("next_attempt ASC").Order("id ASC").
+        Limit(outboxBatchSize).
+        Find(&pending)
+    if res.Error != nil {
+        log.Printf("Failed to fetch the email outbox: %v", res.Error)
+        return 0
+    }
+
+    for _, entry := range pending {
+        err := sendEmailTo(backend, Mail{
+            To:      entry.MailTo,
+            Subject: entry.MailSubject,
+            Text:    entry.MailText,
+            HTML:    entry.MailHTML,
+        })
+
+        entry.Attempts++
+        if err == nil {
+            now := time.Now()
+            entry.Status = table.OutboxSent
+            entry.SentAt = &now
+            entry.LastError = ""
+            entry.MailText = ""
+            entry.MailHTML = ""
+        } else {
+            entry.LastError = err.Error()
+            if entry.Attempts >= outboxMaxAttempts {
+                entry.Status = table.OutboxDead
+                log.Printf("Email %d to %s is dead after %d attempts.", entry.ID, entry.MailTo, entry.Attempts)
+            } else {
+                entry.NextAttempt = time.Now().Add(outboxBackoff(entry.Attempts))
+            }
+        }
+
+        res := backend.db.Model(&entry).Select("status", "attempts", "next_attempt", "last_error", "sent_at", "mail_text", "mail_html").Updates(&entry)
+        if res.Error != nil {
+            log.Printf("Failed to update email outbox %d: %v", entry.ID, res.Error)
+        }
+    }
+    return len(pending)
 }
@@ -1,16 +1,14 @@
 package main
 
 import (
-    "crypto/rand"
     "encoding/base64"
     "errors"
     "fmt"
-    "math/big"
     "net/mail"
     "os"
-    "time"
+    "strconv"
+    "strings"
     "webrpl/table"
-    "log"
 
     "github.com/gofiber/fiber/v2"
     "github.com/golang-jwt/jwt/v5"
@@ -39,7 +37,7 @@ func checkOrMakeAdmin(backend *Backend, secret string) bool {
                 return false
             }
         }
-        return true
+        return assignRole(backend.db, user.ID, roleSuperAdmin) == nil
     }
 
     if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
@@ -61,6 +59,9 @@ func checkOrMakeAdmin(backend *Backend, secret string) bool {
     if err := backend.db.Create(&user).Error; err != nil {
         return false
     }
+    if err := assignRole(backend.db, user.ID, roleSuperAdmin); err != nil {
+        return false
+    }
 
     return true
 }
@@ -69,13 +70,55 @@ func getCredentialFromEnv() SecretHolder {
     password := os.Getenv("WRPL_SECRET")
     email := os.Getenv("WRPL_EMAIL")
     emailAppPass := os.Getenv("WRPL_EMAPPPASS")
+    smtpHost := os.Getenv("WRPL_SMTP_HOST")
+    smtpPort, err := strconv.Atoi(os.Getenv("WRPL_SMTP_PORT"))
+    smtpTLS := os.Getenv("WRPL_SMTP_TLS")
+    mailer := os.Getenv("WRPL_MAILER")
+    mailerFile := os.Getenv("WRPL_MAILER_FILE")
+    publicURL := os.Getenv("WRPL_PUBLIC_URL")
+    oidcRedirectURL := os.Getenv("WRPL_OIDC_REDIRECT_URL")
     if password == "" {
         password = "secret"
     }
+    if smtpHost == "" {
+        smtpHost = "smtp.gmail.com"
+    }
+    if err != nil || smtpPort <= 0 {
+        smtpPort = 587
+    }
+    if mailerFile == "" {
+        mailerFile = "./mail.log"
+    }
+    if publicURL == "" {
+        publicURL = "http://localhost:3000"
+    }
+    publicURL = strings.TrimRight(publicURL, "/")
+    if oidcRedirectURL == "" {
+        oidcRedirectURL = publicURL + "/oidc/callback"
+    }
+    // NOTE: The frontend call the api from the server, it put the real client
+    //       ip on X-Forwarded-For. Only believe it from these address.
+    var trustedProxies []string
+    for _, proxy := range strings.Split(os.Getenv("WRPL_TRUSTED_PROXIES"), ",") {
+        if proxy = strings.TrimSpace(proxy); proxy != "" {
+            trustedProxies = append(trustedProxies, proxy)
+        }
+    }
     sec := SecretHolder{
         Password: password,
         Email: email,
         EmailAppPassword: emailAppPass,
+        SmtpHost: smtpHost,
+        SmtpPort: smtpPort,
+        SmtpTLS: smtpTLS,
+        Mailer: mailer,
+        MailerFile: mailerFile,
+        PublicURL: publicURL,
+        OidcIssuer: os.Getenv("WRPL_OIDC_ISSUER"),
+        OidcClientID: os.Getenv("WRPL_OIDC_CLIENT_ID"),
+        OidcClientSecret: os.Getenv("WRPL_OIDC_CLIENT_SECRET"),
+        OidcRedirectURL: oidcRedirectURL,
+        TrustedProxies: trustedProxies,
     }
     return sec
 }
@@ -100,78 +143,33 @@ func RandStringBytes(backend *Backend, value string) string {
     return str
 }
 
-func GetJWT(c *fiber.Ctx) (jwt.MapClaims, error) {
-    user := c.Locals("user").(*jwt.Token)
-    if user == nil {
+// NOTE: The raw claims of the jwt, only the session part need it (eg. the
+//       `sid`). Use GetJWT to know who is calling.
+func jwtClaims(c *fiber.Ctx) (jwt.MapClaims, error) {
+    user, ok := c.Locals("user").(*jwt.Token)
+    if !ok || user == nil {
         return nil, errors.New("JWT token not valid")
     }
     if !user.Valid {
         return nil, errors.New("JWT token expired")
     }
-    claims := user.Claims.(jwt.MapClaims)
+    claims, ok := user.Claims.(jwt.MapClaims)
+    if !ok {
+        return nil, errors.New("JWT token not valid")
+    }
     return claims, nil
 }
 
-const otpExpiryDuration = 5 * time.Minute
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
-func createOTPCode(backend *Backend, n int, userEmail string) (*table.OTP, error) {
-    if n <= 0 {
-        return nil, errors.New("invalid OTP len requested.")
-    }
-
-    b := make([]byte, n)
-    for i := range b {
-        num, err := rand.Int(rand.Reader, big.NewInt(int64(len(letterBytes))))
-        if err != nil {
-            return nil, err
-        }
-        b[i] = letterBytes[num.Int64()]
-    }
-    result := string(b)
-
-    var existingOTP table.OTP
-    res := backend.db.Where("user_email = ?", userEmail).First(&existingOTP)
-
-    if res.Error != nil {
-        if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
-            return nil, res.Error
-        }
-
-        newOTP := table.OTP{
-            UserEmail:   userEmail,
-            OtpCode:     result,
-            TimeCreated: time.Now(),
-            Used:        false,
-        }
-        if err := backend.db.Create(&newOTP).Error; err != nil {
-            return nil, errors.New("failed to create new OTP")
-        }
-        return &newOTP, nil
-    }
-
-    if time.Since(existingOTP.TimeCreated) < otpExpiryDuration {
-        return &existingOTP, nil
+// NOTE: The user of the request. The token is checked and the user is loaded
+//       once by sessionMiddleware (or the access token middleware), so every
+//       protected handler get it here without a query.
+func GetJWT(c *fiber.Ctx) (*table.User, error) {
+    if _, err := jwtClaims(c); err != nil {
+        return nil, err
     }
-
-    existingOTP.OtpCode = result
-    existingOTP.TimeCreated = time.Now()
-
-    if err := backend.db.Save(&existingOTP).Error; err != nil {
-        return nil, errors.New("failed to update existing OTP")
-    }
-    return &existingOTP, nil
-}
-
-func IsOTPExpired(otp *table.OTP) bool {
-	return time.Since(otp.TimeCreated) > otpExpiryDuration
-}
-
-func CleanupOTPTable(backend *Backend) {
-    expiryCutoff := time.Now().Add(-otpExpiryDuration)
-    res := backend.db.Where("created_at < ?", expiryCutoff).Delete(&table.OTP{})
-    if res.Error != nil {
-        log.Printf("Failed to cleanup OTPs: %v", res.Error)
-    } else {
-        log.Printf("Cleaned up %d expired OTP entries", res.RowsAffected)
+    user, ok := c.Locals("current_user").(*table.User)
+    if !ok || user == nil {
+        return nil, errors.New("no user on the request")
     }
+    return user, nil
 }
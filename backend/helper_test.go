@@ -0,0 +1,25 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+
+    "gorm.io/driver/sqlite"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+)
+
+// NOTE: A fresh sqlite file per test, the busy timeout let the parallel test
+//       wait for the write lock instead of failing.
+func newTestDB(t *testing.T, models ...any) *gorm.DB {
+    t.Helper()
+    dbFile := filepath.Join(t.TempDir(), "test.db") + "?_busy_timeout=5000"
+    db, err := gorm.Open(sqlite.Open(dbFile), &gorm.Config{Logger: logger.Discard})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := db.AutoMigrate(models...); err != nil {
+        t.Fatal(err)
+    }
+    return db
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    gomail "gopkg.in/mail.v2"
+)
+
+// NOTE: One email, HTML can be empty and then only the text part is sent.
+type Mail struct {
+    To      string
+    Subject string
+    Text    string
+    HTML    string
+}
+
+type Mailer interface {
+    Send(mail Mail) error
+}
+
+// NOTE: WRPL_MAILER pick the implementation :
+//         - `smtp`   (default) send it for real with WRPL_SMTP_*
+//         - `file`   append it to WRPL_MAILER_FILE (default ./mail.log)
+//         - `log`    just print it to the log
+//         - `memory` keep it in memory, for test
+func newMailer(sec SecretHolder) Mailer {
+    switch sec.Mailer {
+    case "file":
+        return &fileMailer{path: sec.MailerFile}
+    case "log":
+        return &fileMailer{}
+    case "memory":
+        return &memoryMailer{}
+    case "", "smtp":
+        return newSMTPMailer(sec)
+    }
+    log.Printf("WARN: Unknown WRPL_MAILER %q, using smtp.", sec.Mailer)
+    return newSMTPMailer(sec)
+}
+
+type smtpMailer struct {
+    from   string
+    dialer *gomail.Dialer
+}
+
+// NOTE: WRPL_SMTP_TLS is one of :
+//         - `opportunistic` (default) use STARTTLS only if the server have it
+//         - `starttls` the server must have STARTTLS
+//         - `ssl` implicit TLS (usually port 465)
+//         - `none` plain text, for local smtp only
+func newSMTPMailer(sec SecretHolder) *smtpMailer {
+    dialer := gomail.NewDialer(sec.SmtpHost, sec.SmtpPort, sec.Email, sec.EmailAppPassword)
+    dialer.Timeout = 30 * time.Second
+    switch sec.SmtpTLS {
+    case "starttls":
+        dialer.StartTLSPolicy = gomail.MandatoryStartTLS
+    case "ssl":
+        dialer.SSL = true
+    case "none":
+        dialer.StartTLSPolicy = gomail.NoStartTLS
+    default:
+        dialer.StartTLSPolicy = gomail.OpportunisticStartTLS
+    }
+    return &smtpMailer{from: sec.Email, dialer: dialer}
+}
+
+func (m *smtpMailer) Send(mail Mail) error {
+    message := gomail.NewMessage()
+
+    message.SetHeader("From", m.from)
+    message.SetHeader("To", mail.To)
+    message.SetHeader("Subject", mail.Subject)
+
+    message.SetBody("text/plain", mail.Text)
+    if mail.HTML != "" {
+        message.AddAlternative("text/html", mail.HTML)
+    }
+
+    return m.dialer.DialAndSend(message)
+}
+
+// NOTE: Empty path mean write to the log instead.
+type fileMailer struct {
+    mutex sync.Mutex
+    path  string
+}
+
+func (m *fileMailer) Send(mail Mail) error {
+    entry := fmt.Sprintf("To: %s\nSubject: %s\nDate: %s\n\n%s\n",
+        mail.To, mail.Subject, time.Now().Format(time.RFC1123Z), mail.Text)
+
+    if m.path == "" {
+        log.Printf("MAIL:\n%s", entry)
+        return nil
+    }
+
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+
+    file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    _, err = file.WriteString(entry + strings.Repeat("-", 72) + "\n")
+    return err
+}
+
+type memoryMailer struct {
+    mutex sync.Mutex
+    sent  []Mail
+}
+
+func (m *memoryMailer) Send(mail Mail) error {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    m.sent = append(m.sent, mail)
+    return nil
+}
+
+func (m *memoryMailer) Sent() []Mail {
+    m.mutex.Lock()
+    defer m.mutex.Unlock()
+    return append([]Mail(nil), m.sent...)
+}
@@ -53,7 +53,11 @@ func main() {
     if !checkOrMakeAdmin(app, password) {
         l.Panic("ERR: There is a problem when making user 0 (SUPER ADMIN)")
     }
+    if err := migrateLegacyOTP(app); err != nil {
+        l.Fatal("ERR: Failed to migrate the old OTP: ", err)
+    }
     appMakeRouteHandler(app)
+    startBackgroundTasks(app)
     const hardcodeAddress = "0.0.0.0:3000"
     if err := app.app.Listen(hardcodeAddress); err != nil {
         l.Fatal("ERR: Server failed to start: ", err)
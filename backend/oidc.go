@@ -0,0 +1,270 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+    "webrpl/table"
+
+    "github.com/coreos/go-oidc/v3/oidc"
+    "golang.org/x/oauth2"
+    "gorm.io/gorm"
+)
+
+// NOTE: The login have to come back before this or the state is forgotten.
+const oidcStateTTL = 10 * time.Minute
+
+// NOTE: Anyone can start a login, so the state kept in memory is capped.
+const oidcPendingMax = 1000
+
+// NOTE: The browser that started the login get this cookie, the callback only
+//       accept the state together with it.
+const oidcBindingCookie = "wrpl_oidc"
+
+var (
+    errOidcDisabled    = errors.New("single sign-on is not configured")
+    errOidcState       = errors.New("invalid or expired login state")
+    errOidcBusy        = errors.New("too many login in progress, try again later")
+    errOidcEmail       = errors.New("the identity provider didnt give a verified email")
+    errOidcLinkedOther = errors.New("this account is already linked to another identity")
+)
+
+type oidcPending struct {
+    verifier string
+    nonce    string
+    binding  [32]byte
+    created  time.Time
+}
+
+type oidcIdentity struct {
+    Subject       string
+    Email         string
+    EmailVerified bool
+    Name          string
+}
+
+// NOTE: The provider is discovered on the first login and not on start, so the
+//       backend still start when the identity provider is down. The key set keep
+//       the context of the discovery so it use context.Background().
+type oidcClient struct {
+    mutex    sync.Mutex
+    sec      SecretHolder
+    provider *oidc.Provider
+    verifier *oidc.IDTokenVerifier
+    config   oauth2.Config
+    pending  map[string]oidcPending
+}
+
+func newOidcClient(sec SecretHolder) *oidcClient {
+    if sec.OidcIssuer == "" {
+        return nil
+    }
+    return &oidcClient{
+        sec:     sec,
+        pending: make(map[string]oidcPending),
+    }
+}
+
+func (o *oidcClient) setup() error {
+    o.mutex.Lock()
+    defer o.mutex.Unlock()
+    if o.provider != nil {
+        return nil
+    }
+
+    provider, err := oidc.NewProvider(context.Background(), o.sec.OidcIssuer)
+    if err != nil {
+        return fmt.Errorf("failed to discover the identity provider, %w", err)
+    }
+    o.provider = provider
+    o.verifier = provider.Verifier(&oidc.Config{ClientID: o.sec.OidcClientID})
+    o.config = oauth2.Config{
+        ClientID:     o.sec.OidcClientID,
+        ClientSecret: o.sec.OidcClientSecret,
+        RedirectURL:  o.sec.OidcRedirectURL,
+        Endpoint:     provider.Endpoint(),
+        Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+    }
+    return nil
+}
+
+// NOTE: Return the url of the identity provider login page and the binding for
+//       the browser cookie, the state, nonce and the PKCE verifier is kept here
+//       until the callback. Only the hash of the binding is kept.
+func (o *oidcClient) begin() (string, string, error) {
+    if o == nil {
+        return "", "", errOidcDisabled
+    }
+    if err := o.setup(); err != nil {
+        return "", "", err
+    }
+
+    state, err := randomHex(16)
+    if err != nil {
+        return "", "", err
+    }
+    nonce, err := randomHex(16)
+    if err != nil {
+        return "", "", err
+    }
+    binding, err := randomHex(32)
+    if err != nil {
+        return "", "", err
+    }
+    verifier := oauth2.GenerateVerifier()
+
+    o.mutex.Lock()
+    for key, p := range o.pending {
+        if time.Since(p.created) > oidcStateTTL {
+            delete(o.pending, key)
+        }
+    }
+    if len(o.pending) >= oidcPendingMax {
+        o.mutex.Unlock()
+        return "", "", errOidcBusy
+    }
+    o.pending[state] = oidcPending{
+        verifier: verifier,
+        nonce:    nonce,
+        binding:  sha256.Sum256([]byte(binding)),
+        created:  time.Now(),
+    }
+    o.mutex.Unlock()
+
+    return o.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), binding, nil
+}
+
+// NOTE: The state can only be used once, even when the exchange fail. A wrong
+//       binding didnt use it up, or anyone that saw the state could cancel the login.
+func (o *oidcClient) finish(ctx context.Context, state string, binding string, code string) (*oidcIdentity, error) {
+    if o == nil {
+        return nil, errOidcDisabled
+    }
+    if err := o.setup(); err != nil {
+        return nil, err
+    }
+
+    sum := sha256.Sum256([]byte(binding))
+    o.mutex.Lock()
+    pending, ok := o.pending[state]
+    if ok && subtle.ConstantTimeCompare(pending.binding[:], sum[:]) == 1 {
+        delete(o.pending, state)
+    } else {
+        ok = false
+    }
+    o.mutex.Unlock()
+    if !ok || time.Since(pending.created) > oidcStateTTL {
+        return nil, errOidcState
+    }
+
+    token, err := o.config.Exchange(ctx, code, oauth2.VerifierOption(pending.verifier))
+    if err != nil {
+        return nil, fmt.Errorf("failed to exchange the code, %w", err)
+    }
+    rawIDToken, ok := token.Extra("id_token").(string)
+    if !ok {
+        return nil, errors.New("the identity provider didnt return an id_token")
+    }
+    idToken, err := o.verifier.Verify(ctx, rawIDToken)
+    if err != nil {
+        return nil, fmt.Errorf("failed to verify the id_token, %w", err)
+    }
+    if idToken.Nonce != pending.nonce {
+        return nil, errors.New("the id_token nonce doesnt match")
+    }
+
+    var claims struct {
+        Email         string `json:"email"`
+        EmailVerified *bool  `json:"email_verified"`
+        Name          string `json:"name"`
+    }
+    if err := idToken.Claims(&claims); err != nil {
+        return nil, fmt.Errorf("failed to read the id_token claims, %w", err)
+    }
+    if claims.Email == "" || !isEmailValid(claims.Email) {
+        return nil, errOidcEmail
+    }
+
+    // NOTE: Some provider didnt send `email_verified` at all, that is treated as
+    //       not verified so it can only login to the account already linked.
+    return &oidcIdentity{
+        Subject:       idToken.Subject,
+        Email:         strings.ToLower(claims.Email),
+        EmailVerified: claims.EmailVerified != nil && *claims.EmailVerified,
+        Name:          claims.Name,
+    }, nil
+}
+
+// NOTE: The user already linked to the subject win, then the user with the same
+//       email is linked on the first login, a new one is made as a normal
+//       participant when there is none. Both of the last need a verified email
+//       or anyone could take an account by setting its email at their provider.
+func oidcFindOrCreateUser(backend *Backend, identity *oidcIdentity) (*table.User, error) {
+    var user table.User
+    res := backend.db.Where("user_oidc_subject = ?", identity.Subject).First(&user)
+    if res.Error == nil {
+        return &user, nil
+    }
+    if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+        return nil, res.Error
+    }
+    if !identity.EmailVerified {
+        return nil, errOidcEmail
+    }
+
+    res = backend.db.Where("LOWER(user_email) = ?", identity.Email).First(&user)
+    if res.Error == nil {
+        if user.UserOidcSubject != "" && user.UserOidcSubject != identity.Subject {
+            return nil, errOidcLinkedOther
+        }
+        if user.UserOidcSubject == "" {
+            user.UserOidcSubject = identity.Subject
+            if err := backend.db.Save(&user).Error; err != nil {
+                return nil, err
+            }
+        }
+        return &user, nil
+    }
+    if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+        return nil, res.Error
+    }
+
+    // NOTE: The password is random so only the single sign-on (or a reset) can login.
+    randomPass, err := randomHex(32)
+    if err != nil {
+        return nil, err
+    }
+    hashed, err := HashPassword(randomPass)
+    if err != nil {
+        return nil, err
+    }
+
+    name := identity.Name
+    if name == "" {
+        name = strings.SplitN(identity.Email, "@", 2)[0]
+    }
+
+    user = table.User{
+        UserFullName:    name,
+        UserEmail:       identity.Email,
+        UserPassword:    hashed,
+        UserRole:        0,
+        UserCreatedAt:   time.Now(),
+        UserOidcSubject: identity.Subject,
+    }
+    err = backend.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&user).Error; err != nil {
+            return err
+        }
+        return assignRole(tx, user.ID, roleParticipant)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &user, nil
+}
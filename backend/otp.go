@@ -0,0 +1,199 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "log"
+    "math/big"
+    "strings"
+    "time"
+    "webrpl/table"
+
+    "gorm.io/gorm"
+)
+
+// NOTE: After otpMaxAttempts wrong guess the code is burned, the user need a
+//       new one. Guessing a 4 char code is 1 in 14 million per try.
+const (
+    otpExpiryDuration = 5 * time.Minute
+    otpMaxAttempts    = 5
+)
+
+const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+
+var (
+    errOTPInvalid = errors.New("invalid otp")
+    errOTPExpired = errors.New("otp expired")
+    errOTPTooMany = errors.New("too many wrong otp")
+)
+
+func randomOTPCode(n int) (string, error) {
+    if n <= 0 {
+        return "", errors.New("invalid OTP len requested.")
+    }
+
+    b := make([]byte, n)
+    for i := range b {
+        num, err := rand.Int(rand.Reader, big.NewInt(int64(len(letterBytes))))
+        if err != nil {
+            return "", err
+        }
+        b[i] = letterBytes[num.Int64()]
+    }
+    return string(b), nil
+}
+
+func otpEmailKey(email string) string {
+    return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NOTE: The purpose and the email is part of the hash so a leaked row didnt
+//       help with any other code.
+func hashOTP(backend *Backend, purpose table.OTPPurpose, email string, code string) string {
+    mac := hmac.New(sha256.New, []byte(backend.pass+":otp"))
+    mac.Write([]byte(string(purpose) + "\x00" + otpEmailKey(email) + "\x00" + code))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NOTE: Replace every other code of the email for that purpose, only the newest
+//       work. ttl of 0 mean the default expiry. Return the plain code for the email.
+func createOTP(backend *Backend, db *gorm.DB, purpose table.OTPPurpose, email string, n int, ttl time.Duration) (string, error) {
+    code, err := randomOTPCode(n)
+    if err != nil {
+        return "", err
+    }
+
+    otp := table.OTP{
+        UserEmail:   otpEmailKey(email),
+        OtpPurpose:  purpose,
+        OtpCode:     hashOTP(backend, purpose, email, code),
+        TimeCreated: time.Now(),
+    }
+    if ttl > 0 {
+        expires := time.Now().Add(ttl)
+        otp.OtpExpiresAt = &expires
+    }
+
+    err = db.Transaction(func(tx *gorm.DB) error {
+        err := tx.Unscoped().Where("user_email = ? AND otp_purpose = ?", otp.UserEmail, purpose).Delete(&table.OTP{}).Error
+        if err != nil {
+            return err
+        }
+        return tx.Create(&otp).Error
+    })
+    if err != nil {
+        return "", err
+    }
+    return code, nil
+}
+
+func IsOTPExpired(otp *table.OTP) bool {
+    if otp.OtpExpiresAt != nil {
+        return time.Now().After(*otp.OtpExpiresAt)
+    }
+    return time.Since(otp.TimeCreated) > otpExpiryDuration
+}
+
+// NOTE: Check and use the code in one go. The attempt is counted before the
+//       code is compared and only while it is under the limit, so parallel
+//       wrong guess cant get more than otpMaxAttempts try. The used flag is
+//       only set when it was still unused, so two request with the same code
+//       cant both pass.
+func consumeOTP(backend *Backend, purpose table.OTPPurpose, email string, code string) error {
+    var otp table.OTP
+    res := backend.db.Where("user_email = ? AND otp_purpose = ? AND used = ?", otpEmailKey(email), purpose, false).
+        Order("id DESC").
+        First(&otp)
+    if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+        return errOTPInvalid
+    }
+    if res.Error != nil {
+        return res.Error
+    }
+
+    if IsOTPExpired(&otp) {
+        return errOTPExpired
+    }
+
+    res = backend.db.Model(&table.OTP{}).
+        Where("id = ? AND used = ? AND otp_attempts < ?", otp.ID, false, otpMaxAttempts).
+        Update("otp_attempts", gorm.Expr("otp_attempts + 1"))
+    if res.Error != nil {
+        return res.Error
+    }
+    // Out of try, or a parallel request just used it.
+    if res.RowsAffected != 1 {
+        return errOTPTooMany
+    }
+
+    expected := hashOTP(backend, purpose, email, code)
+    if !hmac.Equal([]byte(expected), []byte(otp.OtpCode)) {
+        // The last allowed try burn the code so the cleanup remove it.
+        res = backend.db.Model(&table.OTP{}).
+            Where("id = ? AND used = ? AND otp_attempts >= ?", otp.ID, false, otpMaxAttempts).
+            Update("used", true)
+        if res.Error != nil {
+            return res.Error
+        }
+        if res.RowsAffected > 0 {
+            return errOTPTooMany
+        }
+        return errOTPInvalid
+    }
+
+    res = backend.db.Model(&table.OTP{}).Where("id = ? AND used = ?", otp.ID, false).Update("used", true)
+    if res.Error != nil {
+        return res.Error
+    }
+    if res.RowsAffected != 1 {
+        return errOTPInvalid
+    }
+    return nil
+}
+
+// NOTE: The code from before the purpose existed was stored as it is. The long
+//       lived one is the set password code of the import, keep it as a reset
+//       code. The rest only live for 5 minutes anyway.
+func migrateLegacyOTP(backend *Backend) error {
+    var legacy []table.OTP
+    res := backend.db.Where("otp_purpose IS NULL OR otp_purpose = ''").Find(&legacy)
+    if res.Error != nil {
+        return res.Error
+    }
+    for _, otp := range legacy {
+        if otp.OtpExpiresAt == nil || otp.Used {
+            if err := backend.db.Unscoped().Delete(&otp).Error; err != nil {
+                return err
+            }
+            continue
+        }
+        err := backend.db.Model(&otp).Updates(map[string]any{
+            "user_email":  otpEmailKey(otp.UserEmail),
+            "otp_purpose": table.OTPReset,
+            "otp_code":    hashOTP(backend, table.OTPReset, otp.UserEmail, otp.OtpCode),
+        }).Error
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// NOTE: The expiry is from time_created (see IsOTPExpired), a used code is
+//       never checked again so it can go right away.
+func CleanupOTPTable(backend *Backend) error {
+    expiryCutoff := time.Now().Add(-otpExpiryDuration)
+    res := backend.db.Unscoped().
+        Where("used = ? OR (otp_expires_at IS NULL AND time_created < ?) OR otp_expires_at < ?", true, expiryCutoff, time.Now()).
+        Delete(&table.OTP{})
+    if res.Error != nil {
+        return res.Error
+    }
+    if res.RowsAffected > 0 {
+        log.Printf("Cleaned up %d expired OTP entries", res.RowsAffected)
+    }
+    return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "math/bits"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+)
+
+// NOTE: api/gen-otp-for-register is public and send an email, so every call need
+//       a challenge from api/otp-challenge solved by the browser and is limited
+//       per email and per ip. The ip limit is higher because a lot of student can
+//       be behind the same campus ip. 18 bit is around a second in a browser.
+const (
+    otpPowDifficulty = 18
+    otpChallengeTTL  = 5 * time.Minute
+    otpEmailCooldown = 60 * time.Second
+    otpIPCooldown    = 5 * time.Second
+    otpEmailDailyCap = 5
+    otpIPDailyCap    = 100
+    otpRequestWindow = 24 * time.Hour
+)
+
+type otpReject string
+
+const (
+    otpRejectInput         otpReject = "invalid_input"
+    otpRejectChallenge     otpReject = "challenge"
+    otpRejectEmailCooldown otpReject = "email_cooldown"
+    otpRejectIPCooldown    otpReject = "ip_cooldown"
+    otpRejectEmailDaily    otpReject = "email_daily_cap"
+    otpRejectIPDaily       otpReject = "ip_daily_cap"
+)
+
+var errOTPChallenge = errors.New("invalid challenge")
+
+func signOTPChallenge(backend *Backend, payload string) string {
+    mac := hmac.New(sha256.New, []byte(backend.pass+":otp-challenge"))
+    mac.Write([]byte(payload))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NOTE: `id.expiry.difficulty.signature`, nothing is saved until it is used.
+func newOTPChallenge(backend *Backend) (string, time.Time, error) {
+    id, err := randomHex(16)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    expires := time.Now().Add(otpChallengeTTL)
+    payload := fmt.Sprintf("%s.%d.%d", id, expires.Unix(), otpPowDifficulty)
+    return payload + "." + signOTPChallenge(backend, payload), expires, nil
+}
+
+func leadingZeroBits(sum []byte) int {
+    n := 0
+    for _, b := range sum {
+        if b != 0 {
+            return n + bits.LeadingZeros8(b)
+        }
+        n += 8
+    }
+    return n
+}
+
+// NOTE: The nonce is good when sha256(challenge + ":" + nonce) start with
+//       difficulty zero bit. Only the signature, expiry and work is checked
+//       here, the single use is checked when the request is saved.
+func verifyOTPChallenge(backend *Backend, challenge string, nonce string) error {
+    parts := strings.Split(challenge, ".")
+    if len(parts) != 4 || nonce == "" || len(nonce) > 64 {
+        return errOTPChallenge
+    }
+    payload := strings.Join(parts[:3], ".")
+    if !hmac.Equal([]byte(signOTPChallenge(backend, payload)), []byte(parts[3])) {
+        return errOTPChallenge
+    }
+    expires, err := strconv.ParseInt(parts[1], 10, 64)
+    if err != nil || time.Now().Unix() > expires {
+        return errOTPChallenge
+    }
+    difficulty, err := strconv.Atoi(parts[2])
+    if err != nil {
+        return errOTPChallenge
+    }
+    sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+    if leadingZeroBits(sum[:]) < difficulty {
+        return errOTPChallenge
+    }
+    return nil
+}
+
+// NOTE: The wait is until the newest request leave the cooldown, or until the
+//       oldest one leave the window when the cap is full.
+func otpRequestLimit(tx *gorm.DB, column string, key string, cooldown time.Duration, daily int) (time.Duration, bool, error) {
+    var requests []table.OTPRequest
+    now := time.Now()
+    res := tx.Where(column+" = ? AND created_at > ?", key, now.Add(-otpRequestWindow)).
+        Order("created_at ASC").
+        Find(&requests)
+    if res.Error != nil {
+        return 0, false, res.Error
+    }
+    if len(requests) == 0 {
+        return 0, false, nil
+    }
+
+    if len(requests) >= daily {
+        return requests[0].CreatedAt.Add(otpRequestWindow).Sub(now), true, nil
+    }
+    last := requests[len(requests)-1].CreatedAt
+    if wait := last.Add(cooldown).Sub(now); wait > 0 {
+        return wait, false, nil
+    }
+    return 0, false, nil
+}
+
+// NOTE: Save the request when no limit is hit. Return the reason and how long
+//       to wait otherwise, the reason is empty when the request can go on.
+func otpRequestAllow(backend *Backend, email string, ip string, challenge string) (otpReject, time.Duration, error) {
+    var reason otpReject
+    var wait time.Duration
+    err := backend.db.Transaction(func(tx *gorm.DB) error {
+        var used int64
+        if err := tx.Unscoped().Model(&table.OTPRequest{}).Where("req_challenge = ?", challenge).Count(&used).Error; err != nil {
+            return err
+        }
+        if used > 0 {
+            reason = otpRejectChallenge
+            return nil
+        }
+
+        limits := []struct {
+            column   string
+            key      string
+            cooldown time.Duration
+            daily    int
+            onWait   otpReject
+            onCap    otpReject
+        }{
+            {"req_email", otpEmailKey(email), otpEmailCooldown, otpEmailDailyCap, otpRejectEmailCooldown, otpRejectEmailDaily},
+            {"req_ip", ip, otpIPCooldown, otpIPDailyCap, otpRejectIPCooldown, otpRejectIPDaily},
+        }
+        for _, limit := range limits {
+            // No trusted proxy, every request has the frontend ip (see throttleClientIP).
+            if limit.key == "" {
+                continue
+            }
+            w, capped, err := otpRequestLimit(tx, limit.column, limit.key, limit.cooldown, limit.daily)
+            if err != nil {
+                return err
+            }
+            if w > 0 {
+                reason, wait = limit.onWait, w
+                if capped {
+                    reason = limit.onCap
+                }
+                return nil
+            }
+        }
+
+        return tx.Create(&table.OTPRequest{
+            ReqEmail:     otpEmailKey(email),
+            ReqIP:        ip,
+            ReqChallenge: challenge,
+        }).Error
+    })
+    return reason, wait, err
+}
+
+func cleanupOTPRequests(backend *Backend) error {
+    return backend.db.Unscoped().Where("created_at < ?", time.Now().Add(-otpRequestWindow)).Delete(&table.OTPRequest{}).Error
+}
+
+// NOTE: Only kept in memory, it start from zero on every restart.
+type otpStats struct {
+    mutex    sync.Mutex
+    since    time.Time
+    sent     int64
+    notSent  int64
+    rejected map[otpReject]int64
+}
+
+func newOTPStats() *otpStats {
+    return &otpStats{
+        since:    time.Now(),
+        rejected: make(map[otpReject]int64),
+    }
+}
+
+func (s *otpStats) reject(reason otpReject) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.rejected[reason]++
+}
+
+// NOTE: notSent is the request that got the same answer but the email cant use
+//       the purpose (already registered / not registered).
+func (s *otpStats) accept(sent bool) {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if sent {
+        s.sent++
+    } else {
+        s.notSent++
+    }
+}
+
+func (s *otpStats) snapshot() fiber.Map {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    var total int64
+    rejected := fiber.Map{}
+    for _, reason := range []otpReject{otpRejectInput, otpRejectChallenge, otpRejectEmailCooldown, otpRejectIPCooldown, otpRejectEmailDaily, otpRejectIPDaily} {
+        rejected[string(reason)] = s.rejected[reason]
+        total += s.rejected[reason]
+    }
+    return fiber.Map{
+        "since":          s.since,
+        "sent":           s.sent,
+        "not_sent":       s.notSent,
+        "rejected":       rejected,
+        "rejected_total": total,
+    }
+}
+
+func otpLimited(c *fiber.Ctx, reason otpReject, wait time.Duration, errCode int) error {
+    seconds := int(wait.Round(time.Second).Seconds())
+    if seconds < 1 {
+        seconds = 1
+    }
+    message := fmt.Sprintf("Please wait %d second before asking another OTP.", seconds)
+    if reason == otpRejectEmailDaily || reason == otpRejectIPDaily {
+        message = fmt.Sprintf("Too many OTP asked today, try again in %d second.", seconds)
+    }
+    c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+    return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+        "success":    false,
+        "message":    message,
+        "error_code": errCode,
+        "data": fiber.Map{
+            "reason":      reason,
+            "retry_after": seconds,
+        },
+    })
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+    "errors"
+    "sync"
+    "testing"
+    "webrpl/table"
+)
+
+func TestConsumeOTPParallelWrongGuess(t *testing.T) {
+    db := newTestDB(t, &table.OTP{})
+    backend := &Backend{db: db, pass: "secret"}
+
+    code, err := createOTP(backend, db, table.OTPReset, "a@example.com", 6, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var wg sync.WaitGroup
+    for range 4 * otpMaxAttempts {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            err := consumeOTP(backend, table.OTPReset, "a@example.com", "wrong!")
+            if err == nil || !(errors.Is(err, errOTPInvalid) || errors.Is(err, errOTPTooMany)) {
+                t.Errorf("a wrong guess returned %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    var otp table.OTP
+    if err := db.First(&otp).Error; err != nil {
+        t.Fatal(err)
+    }
+    if otp.OtpAttempts > otpMaxAttempts {
+        t.Fatalf("counted %d attempt, the max is %d", otp.OtpAttempts, otpMaxAttempts)
+    }
+    if !otp.Used {
+        t.Fatal("the code is not burned after the last try")
+    }
+    if err := consumeOTP(backend, table.OTPReset, "a@example.com", code); err == nil {
+        t.Fatal("the right code still work after the limit")
+    }
+}
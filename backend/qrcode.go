@@ -0,0 +1,39 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+
+    qrcode "github.com/skip2/go-qrcode"
+)
+
+const qrCodeSize = 256
+
+func qrCodePNG(content string) ([]byte, error) {
+    return qrcode.Encode(content, qrcode.Medium, qrCodeSize)
+}
+
+// NOTE: go-qrcode only know how to make png so the svg is drawn from the bitmap,
+//       one rect for every dark module.
+func qrCodeSVG(content string) ([]byte, error) {
+    q, err := qrcode.New(content, qrcode.Medium)
+    if err != nil {
+        return nil, err
+    }
+    bitmap := q.Bitmap()
+    n := len(bitmap)
+
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`, n, n, qrCodeSize, qrCodeSize)
+    fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#ffffff"/>`, n, n)
+    buf.WriteString(`<path fill="#000000" d="`)
+    for y, row := range bitmap {
+        for x, dark := range row {
+            if dark {
+                fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+            }
+        }
+    }
+    buf.WriteString(`"/></svg>`)
+    return buf.Bytes(), nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "slices"
+    "strings"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+)
+
+const (
+    permAdminPanel        = "admin:panel"
+    permUserRead          = "user:read"
+    permUserCreate        = "user:create"
+    permUserEdit          = "user:edit"
+    permUserDelete        = "user:delete"
+    permSessionManage     = "session:manage"
+    permEventCreate       = "event:create"
+    permEventEdit         = "event:edit"
+    permEventDelete       = "event:delete"
+    permParticipantRead   = "participant:read"
+    permParticipantManage = "participant:manage"
+    permCertManage        = "cert:manage"
+    permOTPManage         = "otp:manage"
+    permEmailRead         = "email:read"
+    permEmailManage       = "email:manage"
+    permRoleManage        = "role:manage"
+    permJobManage         = "job:manage"
+)
+
+var rbacPermissions = []table.Permission{
+    {PermName: permAdminPanel, PermDesc: "Can open the admin panel."},
+    {PermName: permUserRead, PermDesc: "Can see and search every user."},
+    {PermName: permUserCreate, PermDesc: "Can create user from the admin panel."},
+    {PermName: permUserEdit, PermDesc: "Can edit any user."},
+    {PermName: permUserDelete, PermDesc: "Can delete any user."},
+    {PermName: permSessionManage, PermDesc: "Can see and revoke the session of any user."},
+    {PermName: permEventCreate, PermDesc: "Can create event."},
+    {PermName: permEventEdit, PermDesc: "Can edit any event and the material."},
+    {PermName: permEventDelete, PermDesc: "Can delete any event."},
+    {PermName: permParticipantRead, PermDesc: "Can see and export the participant of any event."},
+    {PermName: permParticipantManage, PermDesc: "Can add, edit, remove and check in the participant of any event."},
+    {PermName: permCertManage, PermDesc: "Can manage the certificate template of any event."},
+    {PermName: permOTPManage, PermDesc: "Can cleanup the OTP table and see the OTP metrics."},
+    {PermName: permEmailRead, PermDesc: "Can see the email outbox."},
+    {PermName: permEmailManage, PermDesc: "Can resend email from the outbox."},
+    {PermName: permRoleManage, PermDesc: "Can manage role and assign it to user."},
+    {PermName: permJobManage, PermDesc: "Can see the background job and run it by hand."},
+}
+
+const (
+    roleSuperAdmin   = "super-admin"
+    roleEventManager = "event-manager"
+    roleAuditor      = "auditor"
+    roleParticipant  = "participant"
+)
+
+// NOTE: nil permission mean every permission.
+var rbacBuiltinRoles = []struct {
+    name  string
+    desc  string
+    perms []string
+}{
+    {roleSuperAdmin, "Can do everything.", nil},
+    {roleEventManager, "Run the event, the participant and the certificate.", []string{
+        permAdminPanel, permUserRead, permEventCreate, permEventEdit, permEventDelete,
+        permParticipantRead, permParticipantManage, permCertManage,
+    }},
+    {roleAuditor, "Read only access to the user, participant and email.", []string{
+        permAdminPanel, permUserRead, permParticipantRead, permEmailRead,
+    }},
+    {roleParticipant, "Normal user, join event and get the certificate.", []string{}},
+}
+
+// NOTE: Run on every start so new permission is added to the db and the builtin
+//       role always have what the code expect. `legacyUsers` is only true the
+//       first time, after that a user without role stay without role.
+func seedRBAC(db *gorm.DB, legacyUsers bool) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        var allPerms []table.Permission
+        for _, perm := range rbacPermissions {
+            var existing table.Permission
+            res := tx.Where(table.Permission{PermName: perm.PermName}).
+                Assign(table.Permission{PermDesc: perm.PermDesc}).
+                FirstOrCreate(&existing)
+            if res.Error != nil {
+                return res.Error
+            }
+            allPerms = append(allPerms, existing)
+        }
+
+        for _, builtin := range rbacBuiltinRoles {
+            var role table.Role
+            res := tx.Where(table.Role{RoleName: builtin.name}).
+                Assign(table.Role{RoleDesc: builtin.desc, RoleBuiltin: true}).
+                FirstOrCreate(&role)
+            if res.Error != nil {
+                return res.Error
+            }
+
+            perms := allPerms
+            if builtin.perms != nil {
+                perms = []table.Permission{}
+                for _, perm := range allPerms {
+                    for _, name := range builtin.perms {
+                        if perm.PermName == name {
+                            perms = append(perms, perm)
+                        }
+                    }
+                }
+            }
+            if err := tx.Model(&role).Association("Permissions").Replace(perms); err != nil {
+                return err
+            }
+        }
+
+        if !legacyUsers {
+            return nil
+        }
+
+        // Every user from before this get a role from the old UserRole int.
+        assigned := tx.Model(&table.RoleAssignment{}).Select("user_id")
+        var users []table.User
+        res := tx.Where("id NOT IN (?)", assigned).Find(&users)
+        if res.Error != nil {
+            return res.Error
+        }
+        for _, user := range users {
+            name := roleParticipant
+            if user.UserRole == 1 {
+                name = roleSuperAdmin
+            }
+            if err := assignRole(tx, user.ID, name); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// NOTE: The old UserRole int is still read in a few place (and in the jwt
+//       `admin` claim), keep it the same as having the super-admin role.
+func syncLegacyRole(db *gorm.DB, userID int, roleName string, super bool) error {
+    if roleName != roleSuperAdmin {
+        return nil
+    }
+    legacy := 0
+    if super {
+        legacy = 1
+    }
+    return db.Model(&table.User{}).Where("id = ?", userID).Update("user_role", legacy).Error
+}
+
+func assignRole(db *gorm.DB, userID int, roleName string) error {
+    var role table.Role
+    res := db.Where("role_name = ?", roleName).First(&role)
+    if res.Error != nil {
+        return fmt.Errorf("role %s: %w", roleName, res.Error)
+    }
+
+    err := db.Where(table.RoleAssignment{UserId: userID, RoleId: role.ID}).
+        FirstOrCreate(&table.RoleAssignment{}).Error
+    if err != nil {
+        return err
+    }
+    return syncLegacyRole(db, userID, roleName, true)
+}
+
+// NOTE: Hard delete, the (user, role) pair is unique.
+func unassignRole(db *gorm.DB, userID int, roleName string) (int64, error) {
+    var role table.Role
+    res := db.Where("role_name = ?", roleName).First(&role)
+    if res.Error != nil {
+        return 0, fmt.Errorf("role %s: %w", roleName, res.Error)
+    }
+
+    res = db.Unscoped().Where("user_id = ? AND role_id = ?", userID, role.ID).Delete(&table.RoleAssignment{})
+    if res.Error != nil {
+        return 0, res.Error
+    }
+    return res.RowsAffected, syncLegacyRole(db, userID, roleName, false)
+}
+
+func userHasRole(db *gorm.DB, userID int, roleName string) (bool, error) {
+    var count int64
+    res := db.Table("role_assignments").
+        Joins("JOIN roles ON roles.id = role_assignments.role_id AND roles.deleted_at IS NULL").
+        Where("role_assignments.user_id = ? AND role_assignments.deleted_at IS NULL AND roles.role_name = ?", userID, roleName).
+        Count(&count)
+    if res.Error != nil {
+        return false, res.Error
+    }
+    return count > 0, nil
+}
+
+func userHasPermission(db *gorm.DB, userID int, perm string) (bool, error) {
+    var count int64
+    res := db.Table("role_assignments").
+        Joins("JOIN role_permissions ON role_permissions.role_id = role_assignments.role_id").
+        Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+        Joins("JOIN roles ON roles.id = role_assignments.role_id AND roles.deleted_at IS NULL").
+        Where("role_assignments.user_id = ? AND role_assignments.deleted_at IS NULL AND permissions.perm_name = ?", userID, perm).
+        Count(&count)
+    if res.Error != nil {
+        return false, res.Error
+    }
+    return count > 0, nil
+}
+
+func userPermissions(db *gorm.DB, userID int) ([]string, error) {
+    var perms []string
+    res := db.Table("role_assignments").
+        Distinct("permissions.perm_name").
+        Joins("JOIN role_permissions ON role_permissions.role_id = role_assignments.role_id").
+        Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+        Joins("JOIN roles ON roles.id = role_assignments.role_id AND roles.deleted_at IS NULL").
+        Where("role_assignments.user_id = ? AND role_assignments.deleted_at IS NULL", userID).
+        Order("permissions.perm_name ASC").
+        Pluck("permissions.perm_name", &perms)
+    return perms, res.Error
+}
+
+func rolePermissionNames(db *gorm.DB, roleName string) ([]string, error) {
+    var role table.Role
+    res := db.Preload("Permissions").Where("role_name = ?", roleName).First(&role)
+    if res.Error != nil {
+        return nil, fmt.Errorf("role %s: %w", roleName, res.Error)
+    }
+
+    perms := make([]string, 0, len(role.Permissions))
+    for _, perm := range role.Permissions {
+        perms = append(perms, perm.PermName)
+    }
+    return perms, nil
+}
+
+// NOTE: Every permission in `want` that is not in `have`.
+func missingPermissions(have []string, want []string) []string {
+    missing := []string{}
+    for _, perm := range want {
+        if !slices.Contains(have, perm) {
+            missing = append(missing, perm)
+        }
+    }
+    return missing
+}
+
+// NOTE: The user id is put there by the session middleware.
+func currentUserID(c *fiber.Ctx) int {
+    id, _ := c.Locals("user_id").(int)
+    return id
+}
+
+// NOTE: The user permission, limited to the scope when the request use a
+//       personal access token.
+func requestHasPermission(backend *Backend, c *fiber.Ctx, perm string) (bool, error) {
+    if token, ok := c.Locals("api_token").(*table.ApiToken); ok && !requestScopeAllows(apiTokenScopes(token), perm) {
+        return false, nil
+    }
+    return userHasPermission(backend.db, currentUserID(c), perm)
+}
+
+// NOTE: Same as userPermissions for the current request, limited to the scope
+//       when it use a personal access token.
+func requestPermissions(backend *Backend, c *fiber.Ctx) ([]string, error) {
+    perms, err := userPermissions(backend.db, currentUserID(c))
+    if err != nil {
+        return nil, err
+    }
+
+    token, ok := c.Locals("api_token").(*table.ApiToken)
+    if !ok {
+        return perms, nil
+    }
+    scopes := apiTokenScopes(token)
+    allowed := []string{}
+    for _, perm := range perms {
+        if requestScopeAllows(scopes, perm) {
+            allowed = append(allowed, perm)
+        }
+    }
+    return allowed, nil
+}
+
+// NOTE: Giving any role other than participant need role:manage, and no one
+//       can give a permission they dont have themself (eg. user:create cant
+//       make a super-admin).
+func checkRoleGrant(db *gorm.DB, callerPerms []string, roleName string) error {
+    perms, err := rolePermissionNames(db, roleName)
+    if err != nil {
+        return err
+    }
+    if roleName != roleParticipant && !slices.Contains(callerPerms, permRoleManage) {
+        return fmt.Errorf("need the %s permission to give the %s role", permRoleManage, roleName)
+    }
+    if err := checkPermissionGrant(callerPerms, perms); err != nil {
+        return fmt.Errorf("cant give the %s role, %w", roleName, err)
+    }
+    return nil
+}
+
+// NOTE: The same rule for making or editing a role, else role:manage could
+//       make a role with everything and give it to themself.
+func checkPermissionGrant(callerPerms []string, perms []string) error {
+    if missing := missingPermissions(callerPerms, perms); len(missing) > 0 {
+        return fmt.Errorf("missing %s", strings.Join(missing, ", "))
+    }
+    return nil
+}
+
+// NOTE: An account can only be changed by someone that already has every
+//       permission of it, so user:edit cant reset the password of an admin.
+//       A super-admin can only be changed by another super-admin.
+func checkUserManage(db *gorm.DB, callerID int, callerPerms []string, targetID int) error {
+    targetSuper, err := userHasRole(db, targetID, roleSuperAdmin)
+    if err != nil {
+        return err
+    }
+    if targetSuper {
+        callerSuper, err := userHasRole(db, callerID, roleSuperAdmin)
+        if err != nil {
+            return err
+        }
+        if !callerSuper {
+            return fmt.Errorf("only a super-admin can change a super-admin")
+        }
+    }
+
+    perms, err := userPermissions(db, targetID)
+    if err != nil {
+        return err
+    }
+    if missing := missingPermissions(callerPerms, perms); len(missing) > 0 {
+        return fmt.Errorf("the user has permission you dont have: %s", strings.Join(missing, ", "))
+    }
+    return nil
+}
+
+// NOTE: For the handler that allow more than one way in (eg. admin or the
+//       committee of that event). A db error is treated as no.
+func userCan(backend *Backend, c *fiber.Ctx, perm string) bool {
+    ok, err := requestHasPermission(backend, c, perm)
+    if err != nil {
+        log.Printf("Failed to check permission %s: %v", perm, err)
+        return false
+    }
+    return ok
+}
+
+// NOTE: Put it before the handler on the route that only need one permission.
+//       eg. route.Post("event-del", requirePermission(backend, permEventDelete), func ...)
+func requirePermission(backend *Backend, perm string) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        ok, err := requestHasPermission(backend, c, perm)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success":    false,
+                "message":    fmt.Sprintf("Failed to check the permission, %v", err),
+                "error_code": -2,
+                "data":       nil,
+            })
+        }
+        if !ok {
+            return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+                "success":    false,
+                "message":    fmt.Sprintf("Missing the %s permission for this function.", perm),
+                "error_code": -2,
+                "data":       nil,
+            })
+        }
+        return c.Next()
+    }
+}
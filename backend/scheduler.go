@@ -0,0 +1,257 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+    "webrpl/table"
+
+    "gorm.io/gorm"
+)
+
+// NOTE: A run hold the lock of its job for at most jobLockTimeout, after that it
+//       is counted as dead and the job can run again.
+const (
+    jobLockTimeout = 30 * time.Minute
+    jobHistoryKeep = 30 * 24 * time.Hour
+)
+
+var (
+    errJobUnknown = errors.New("unknown job")
+    errJobLocked  = errors.New("job is already running")
+)
+
+type schedulerJob struct {
+    name     string
+    desc     string
+    spec     string
+    schedule *cronSchedule
+    run      func(backend *Backend) error
+}
+
+// NOTE: The scheduled run is one after another on one goroutine so the jobs
+//       didnt fight for the sqlite lock, only the manual run is on its own.
+type scheduler struct {
+    backend *Backend
+    owner   string
+    jobs    []*schedulerJob
+    mutex   sync.Mutex
+    next    map[string]time.Time
+}
+
+func newScheduler(backend *Backend) *scheduler {
+    owner, err := randomHex(8)
+    if err != nil {
+        owner = fmt.Sprintf("%d", time.Now().UnixNano())
+    }
+    return &scheduler{
+        backend: backend,
+        owner:   owner,
+        next:    make(map[string]time.Time),
+    }
+}
+
+// NOTE: The schedule is in the code, a bad one is a bug so it panic right away.
+func (s *scheduler) register(name string, spec string, desc string, run func(backend *Backend) error) {
+    schedule, err := parseCron(spec)
+    if err != nil {
+        log.Panicf("ERR: Invalid schedule for job %s: %v", name, err)
+    }
+    s.jobs = append(s.jobs, &schedulerJob{
+        name:     name,
+        desc:     desc,
+        spec:     spec,
+        schedule: schedule,
+        run:      run,
+    })
+}
+
+func (s *scheduler) find(name string) *schedulerJob {
+    for _, job := range s.jobs {
+        if job.name == name {
+            return job
+        }
+    }
+    return nil
+}
+
+func (s *scheduler) nextRun(name string) time.Time {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    return s.next[name]
+}
+
+// NOTE: Make the row of every job. A run that was still going when the server
+//       stopped is marked failed once its lock is gone.
+func (s *scheduler) prepare() error {
+    now := time.Now()
+    for _, job := range s.jobs {
+        var row table.ScheduledJob
+        res := s.backend.db.Where(table.ScheduledJob{JobName: job.name}).FirstOrCreate(&row)
+        if res.Error != nil {
+            return res.Error
+        }
+
+        if row.JobLockedUntil == nil || row.JobLockedUntil.Before(now) {
+            res = s.backend.db.Model(&table.JobRun{}).
+                Where("job_name = ? AND job_status = ?", job.name, table.JobRunning).
+                Updates(map[string]any{
+                    "job_status":   table.JobFailed,
+                    "job_error":    "interrupted, the server stopped during the run",
+                    "job_finished": now,
+                })
+            if res.Error != nil {
+                return res.Error
+            }
+        }
+
+        next := job.schedule.next(now)
+        s.mutex.Lock()
+        s.next[job.name] = next
+        s.mutex.Unlock()
+        if err := s.backend.db.Model(&row).Update("job_next_run", next).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *scheduler) start() {
+    if err := s.prepare(); err != nil {
+        log.Printf("Failed to prepare the scheduled job: %v", err)
+    }
+    go func() {
+        for {
+            // Wake up at the start of every minute.
+            now := time.Now()
+            time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+            s.tick(time.Now())
+        }
+    }()
+}
+
+// NOTE: A job that is due more than once while the others run is only run once.
+func (s *scheduler) tick(now time.Time) {
+    for _, job := range s.jobs {
+        s.mutex.Lock()
+        next := s.next[job.name]
+        due := !next.IsZero() && !next.After(now)
+        if due {
+            s.next[job.name] = job.schedule.next(now)
+        }
+        s.mutex.Unlock()
+        if !due {
+            continue
+        }
+
+        runID, err := s.begin(job, table.JobTriggerSchedule)
+        if errors.Is(err, errJobLocked) {
+            continue
+        }
+        if err != nil {
+            log.Printf("Failed to start the job %s: %v", job.name, err)
+            continue
+        }
+        s.finish(job, runID)
+    }
+}
+
+// NOTE: Take the lock and write the run, errJobLocked when another run of the
+//       job (from any server on the same db) is going.
+func (s *scheduler) begin(job *schedulerJob, trigger table.JobTrigger) (int, error) {
+    now := time.Now()
+    var runID int
+    err := s.backend.db.Transaction(func(tx *gorm.DB) error {
+        res := tx.Model(&table.ScheduledJob{}).
+            Where("job_name = ? AND (job_locked_until IS NULL OR job_locked_until < ?)", job.name, now).
+            Updates(map[string]any{
+                "job_locked_by":    s.owner,
+                "job_locked_until": now.Add(jobLockTimeout),
+                "job_last_run":     now,
+                "job_last_status":  table.JobRunning,
+                "job_next_run":     s.nextRun(job.name),
+            })
+        if res.Error != nil {
+            return res.Error
+        }
+        if res.RowsAffected != 1 {
+            return errJobLocked
+        }
+
+        run := table.JobRun{
+            JobName:    job.name,
+            JobTrigger: trigger,
+            JobStatus:  table.JobRunning,
+            JobStarted: now,
+        }
+        if err := tx.Create(&run).Error; err != nil {
+            return err
+        }
+        runID = run.ID
+        return nil
+    })
+    return runID, err
+}
+
+func (s *scheduler) execute(job *schedulerJob) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("panic: %v", r)
+        }
+    }()
+    return job.run(s.backend)
+}
+
+// NOTE: Run the job and save how it went, then free the lock.
+func (s *scheduler) finish(job *schedulerJob, runID int) {
+    err := s.execute(job)
+
+    status, message := table.JobSuccess, ""
+    if err != nil {
+        status, message = table.JobFailed, err.Error()
+        log.Printf("Job %s failed: %v", job.name, err)
+    }
+
+    now := time.Now()
+    res := s.backend.db.Model(&table.JobRun{}).Where("id = ?", runID).Updates(map[string]any{
+        "job_status":   status,
+        "job_error":    message,
+        "job_finished": now,
+    })
+    if res.Error != nil {
+        log.Printf("Failed to save the run of job %s: %v", job.name, res.Error)
+    }
+    res = s.backend.db.Model(&table.ScheduledJob{}).
+        Where("job_name = ? AND job_locked_by = ?", job.name, s.owner).
+        Updates(map[string]any{
+            "job_locked_by":    "",
+            "job_locked_until": nil,
+            "job_last_status":  status,
+            "job_last_error":   message,
+        })
+    if res.Error != nil {
+        log.Printf("Failed to unlock the job %s: %v", job.name, res.Error)
+    }
+}
+
+// NOTE: Start the job now on its own goroutine, the answer didnt wait for it.
+func (s *scheduler) trigger(name string) (int, error) {
+    job := s.find(name)
+    if job == nil {
+        return 0, errJobUnknown
+    }
+    runID, err := s.begin(job, table.JobTriggerManual)
+    if err != nil {
+        return 0, err
+    }
+    go s.finish(job, runID)
+    return runID, nil
+}
+
+func cleanupJobHistory(backend *Backend) error {
+    return backend.db.Unscoped().
+        Where("job_started < ? AND job_status <> ?", time.Now().Add(-jobHistoryKeep), table.JobRunning).
+        Delete(&table.JobRun{}).Error
+}
@@ -4,4 +4,15 @@ type SecretHolder struct {
     Password string
     Email string
     EmailAppPassword string
+    SmtpHost string
+    SmtpPort int
+    SmtpTLS string
+    Mailer string
+    MailerFile string
+    PublicURL string
+    OidcIssuer string
+    OidcClientID string
+    OidcClientSecret string
+    OidcRedirectURL string
+    TrustedProxies []string
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "strings"
+    "time"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+)
+
+// NOTE: Older entry is removed by the security-log-cleanup job.
+const securityLogRetention = 365 * 24 * time.Hour
+
+// NOTE: Never fail the request because of the log, only write it to the server log.
+//       user can be nil when the email didnt match any account.
+func recordSecurityEvent(backend *Backend, c *fiber.Ctx, user *table.User, email string, kind table.SecurityEventKind, detail string) {
+    event := table.SecurityEvent{
+        SecKind:   kind,
+        SecEmail:  email,
+        SecIP:     c.IP(),
+        SecAgent:  c.Get(fiber.HeaderUserAgent),
+        SecDetail: detail,
+    }
+    if user != nil {
+        event.UserId = user.ID
+        event.SecEmail = user.UserEmail
+    }
+
+    // A device is the user agent, a login from one that never logged in
+    // before is new. The very first login of the account is not.
+    if kind == table.SecLoginSuccess && user != nil {
+        var known, seen int64
+        err := backend.db.Model(&table.SecurityEvent{}).
+            Where("user_id = ? AND sec_kind = ?", user.ID, table.SecLoginSuccess).
+            Count(&known).Error
+        if err == nil && known > 0 {
+            err = backend.db.Model(&table.SecurityEvent{}).
+                Where("user_id = ? AND sec_kind = ? AND sec_agent = ?", user.ID, table.SecLoginSuccess, event.SecAgent).
+                Count(&seen).Error
+        }
+        if err != nil {
+            log.Printf("Failed to check the device of user %d: %v", user.ID, err)
+        }
+        event.SecNewDevice = err == nil && known > 0 && seen == 0
+    }
+
+    if err := backend.db.Create(&event).Error; err != nil {
+        log.Printf("Failed to record the security event %s of %s: %v", kind, event.SecEmail, err)
+        return
+    }
+
+    if event.SecNewDevice {
+        queueNewDeviceMail(backend, user, &event)
+    }
+}
+
+func queueNewDeviceMail(backend *Backend, user *table.User, event *table.SecurityEvent) {
+    agent := event.SecAgent
+    if agent == "" {
+        agent = "Unknown"
+    }
+    when := event.CreatedAt.Format("2006-01-02 15:04 MST")
+    mail, err := buildEmail(backend, user.UserEmail, "New login to your webrpl account", "new-login", map[string]any{
+        "Name":  user.UserFullName,
+        "Time":  when,
+        "IP":    event.SecIP,
+        "Agent": agent,
+    }, fmt.Sprintf("Hi %s,\n\nYour account was just used to login from a new device.\n\nTime : %s\nIP : %s\nDevice : %s\n\nIf this was not you, change your password right away.\n",
+        user.UserFullName, when, event.SecIP, agent))
+    if err == nil {
+        err = queueEmail(backend, backend.db, mail)
+    }
+    if err != nil {
+        log.Printf("Failed to queue the new login email: %v", err)
+    }
+}
+
+// NOTE: Every way to login end on one of these path, the detail say which one.
+func loginMethod(c *fiber.Ctx) string {
+    path := c.Path()
+    switch {
+    case strings.HasPrefix(path, "/api/oidc/"):
+        return "single sign-on"
+    case strings.HasPrefix(path, "/api/login-2fa"):
+        return "two factor"
+    default:
+        return "password"
+    }
+}
+
+func securityEventJSON(event *table.SecurityEvent) fiber.Map {
+    return fiber.Map{
+        "id":         event.ID,
+        "user_id":    event.UserId,
+        "kind":       event.SecKind,
+        "email":      event.SecEmail,
+        "ip":         event.SecIP,
+        "agent":      event.SecAgent,
+        "detail":     event.SecDetail,
+        "new_device": event.SecNewDevice,
+        "created_at": event.CreatedAt,
+    }
+}
+
+func cleanupSecurityLog(backend *Backend) error {
+    return backend.db.Unscoped().Where("created_at < ?", time.Now().Add(-securityLogRetention)).Delete(&table.SecurityEvent{}).Error
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"math/rand"
 	"time"
 
@@ -10,15 +11,20 @@ import (
 )
 
 type Backend struct {
-	app       *fiber.App
-	db        *gorm.DB
-	pass      string
-	rand      *rand.Rand
-	engine    *DynamicEngine
-	address   string
-	email     string
-	mode      string
-	emailpass string
+	app        *fiber.App
+	db         *gorm.DB
+	pass       string
+	rand       *rand.Rand
+	engine     *DynamicEngine
+	address    string
+	mode       string
+	mailer     Mailer
+	outboxWake chan struct{}
+	publicURL  string
+	certJobs   *certBulkJobs
+	oidc       *oidcClient
+	otpStats   *otpStats
+	scheduler  *scheduler
 }
 
 func appCreateNewServer(db *gorm.DB, sec SecretHolder, address string) *Backend {
@@ -28,21 +34,34 @@ func appCreateNewServer(db *gorm.DB, sec SecretHolder, address string) *Backend
 		"./static-hidden/",
 		"./static/",
 	}, ".html")
-	app := fiber.New(fiber.Config{
+	config := fiber.Config{
 		AppName: "Webinar-RPL Backend",
 		Views:   engine,
-	})
+	}
+	if len(sec.TrustedProxies) > 0 {
+		config.ProxyHeader = fiber.HeaderXForwardedFor
+		config.EnableTrustedProxyCheck = true
+		config.EnableIPValidation = true
+		config.TrustedProxies = sec.TrustedProxies
+	} else {
+		log.Println("WARNING: WRPL_TRUSTED_PROXIES is not set, the failed login limit per ip is off.")
+	}
+	app := fiber.New(config)
 
 	return &Backend{
-		app:       app,
-		db:        db,
-		pass:      secret,
-		rand:      rand_t,
-		engine:    engine,
-		address:   address,
-		mode:      "http",
-		email:     sec.Email,
-		emailpass: sec.EmailAppPassword,
+		app:        app,
+		db:         db,
+		pass:       secret,
+		rand:       rand_t,
+		engine:     engine,
+		address:    address,
+		mode:       "http",
+		mailer:     newMailer(sec),
+		outboxWake: make(chan struct{}, 1),
+		publicURL:  sec.PublicURL,
+		certJobs:   newCertBulkJobs(),
+		oidc:       newOidcClient(sec),
+		otpStats:   newOTPStats(),
 	}
 }
 
@@ -50,9 +69,12 @@ func appMakeRouteHandler(backend *Backend) {
 	app := backend.app
 	api := app.Group("/api")
 
-	protected := api.Group("/protected", jwtware.New(jwtware.Config{
+	// NOTE: A personal access token is checked first, the jwt and session
+	//       middleware skip the request that already has one.
+	protected := api.Group("/protected", apiTokenMiddleware(backend), jwtware.New(jwtware.Config{
 		SigningKey: jwtware.SigningKey{Key: []byte(backend.pass)},
-	}))
+		Filter:     isApiTokenRequest,
+	}), sessionMiddleware(backend))
 
 	// cookieJWT := api.Group("/c", jwtware.New(jwtware.Config{
 	// 	SigningKey:  jwtware.SigningKey{Key: []byte(backend.pass)},
@@ -77,10 +99,46 @@ func appMakeRouteHandler(backend *Backend) {
 	appHandleUserUploadImage(backend, protected)
 	appHandleUserCount(backend, protected)
 	appHandleRegisterAdmin(backend, protected)
+	appHandleUserImport(backend, protected)
 	appHandleUserSearch(backend, protected)
+	appHandleUserDataExport(backend, protected)
+	appHandleUserDeleteRequest(backend, protected)
+	appHandleUserDeleteCancel(backend, protected)
+	appHandleUserEmailChangeRequest(backend, protected)
+	appHandleUserEmailChangeConfirm(backend, protected)
 	appHandleUserLogOut(backend, protected)
+	appHandleRefresh(backend, api)
+	appHandleOidcLogin(backend, api)
+	appHandleOidcCallback(backend, api)
+	appHandleLogin2FA(backend, api)
+	appHandleLogin2FAEnroll(backend, api)
+	appHandleTOTPStatus(backend, protected)
+	appHandleTOTPEnroll(backend, protected)
+	appHandleTOTPConfirm(backend, protected)
+	appHandleTOTPDisable(backend, protected)
+	appHandleTOTPRecoveryNew(backend, protected)
+	appHandleTOTPReset(backend, protected)
+	appHandleTOTPRequire(backend, protected)
+	appHandleLoginLockList(backend, protected)
+	appHandleLoginUnlock(backend, protected)
+	appHandleTokenNew(backend, protected)
+	appHandleTokenList(backend, protected)
+	appHandleTokenRevoke(backend, protected)
+	appHandleLogOutAll(backend, protected)
+	appHandleSessionList(backend, protected)
+	appHandleSessionRevoke(backend, protected)
+	appHandleSecurityLog(backend, protected)
+	appHandleSecurityLogOf(backend, protected)
 	// appHandleUserLogOut(backend, cookieJWT)
 
+	// ROLE STUFF
+	appHandleRoleList(backend, protected)
+	appHandleRoleOf(backend, protected)
+	appHandleRoleNew(backend, protected)
+	appHandleRoleEdit(backend, protected)
+	appHandleRoleDel(backend, protected)
+	appHandleRoleAssign(backend, protected)
+
 	// EVENT STUFF
 	appHandleEventInfoAll(backend, protected)
 	appHandleEventInfoOf(backend, protected)
@@ -98,7 +156,10 @@ func appMakeRouteHandler(backend *Backend) {
 	appHandleMaterialEdit(backend, protected)
 
 	// CERTIFICATE TEMPLATE STUFF
+	appHandleCertificateRoomPDF(backend, api)
 	appHandleCertificateRoom(backend, api)
+	appHandleCertificateVerify(backend, api)
+	appHandleCertificateVerifySignature(backend, api)
 	appHandleCertTempNew(backend, protected)
 	appHandleCertTempInfoOf(backend, protected)
 	appHandleCertDel(backend, protected)
@@ -106,6 +167,9 @@ func appMakeRouteHandler(backend *Backend) {
 	appHandleCertUploadTemplate(backend, protected)
 
 	appHandleCertNewDumb(backend, protected)
+	appHandleCertBulkZip(backend, protected)
+	appHandleCertBulkZipStatus(backend, protected)
+	appHandleCertBulkZipDownload(backend, protected)
 
 	// appHandleCertEditor(backend, cookieJWT)
 	// appHandleCertEditorUploadImage(backend, cookieJWT)
@@ -121,6 +185,7 @@ func appMakeRouteHandler(backend *Backend) {
 	appHandleEventParticipateEdit(backend, protected)
 	appHandleEventParticipateDel(backend, protected)
 	appHandleEventParticipateOfEvent(backend, protected)
+	appHandleEventParticipateExport(backend, protected)
 	appHandleEventParticipateCommitteeOfEvent(backend, protected)
 	appHandleEventParticipateOfUser(backend, protected)
 	appHandleEventParticipateOfUserWithSearch(backend, protected)
@@ -128,10 +193,29 @@ func appMakeRouteHandler(backend *Backend) {
 	appHandleEventParticipateOfEventCount(backend, protected)
 	appHandleEventParticipateAbsenceBulk(backend, protected)
 	appHandleEventParticipateAbsenceItself(backend, protected)
+	appHandleEventParticipateQR(backend, protected)
+	appHandleEventParticipateCheckIn(backend, protected)
+	appHandleEventParticipateCommitteePerm(backend, protected)
 
 	// OTP STUFF
+	appHandleOTPChallenge(backend, api)
 	appHandleGenOTP(backend, api)
 	appHandleCleanupOTP(backend, protected)
+	appHandleOTPMetrics(backend, protected)
+
+	// EMAIL STUFF
+	appHandleEmailOutbox(backend, protected)
+	appHandleEmailOutboxResend(backend, protected)
+
+	// JOB STUFF
+	appHandleJobList(backend, protected)
+	appHandleJobRun(backend, protected)
+	appHandleJobHistory(backend, protected)
+
+	// TRASH STUFF
+	appHandleTrashList(backend, protected)
+	appHandleTrashRestore(backend, protected)
+	appHandleTrashPurge(backend, protected)
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("Server is running.")
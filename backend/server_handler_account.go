@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// NOTE: Longer than the register OTP, the new inbox can be slower to check.
+const emailChangeOTPTTL = 15 * time.Minute
+
+var errEmailTaken = errors.New("the email is already used by another account")
+
+// NOTE: Zip of user.json, participations.json (with the certificate link),
+//       roles.json, sessions.json, tokens.json, emails.json, security.json
+//       and files/ with the profile picture.
+// GET : api/protected/user-data-export
+func appHandleUserDataExport(backend *Backend, route fiber.Router) {
+	route.Get("user-data-export", requireSession, func(c *fiber.Ctx) error {
+		var user table.User
+		res := backend.db.First(&user, currentUserID(c))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var buf bytes.Buffer
+		if err := writeUserExport(backend, &user, &buf); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to make the export, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		c.Set(fiber.HeaderContentType, "application/zip")
+		c.Attachment(fmt.Sprintf("webrpl-data-%d.zip", user.ID))
+		return c.Status(fiber.StatusOK).Send(buf.Bytes())
+	})
+}
+
+// NOTE: confirm must be the email of the account. The account is anonymized
+//       after the grace period, until then api/protected/user-delete-cancel undo it.
+// POST : api/protected/user-delete-request
+func appHandleUserDeleteRequest(backend *Backend, route fiber.Router) {
+	route.Post("user-delete-request", requireSession, func(c *fiber.Ctx) error {
+		var body struct {
+			Confirm string `json:"confirm"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var user table.User
+		res := backend.db.First(&user, currentUserID(c))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		if !strings.EqualFold(strings.TrimSpace(body.Confirm), user.UserEmail) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Type the email of the account to confirm.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		superAdmin, err := userHasRole(backend.db, user.ID, roleSuperAdmin)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the role, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+		if superAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    "The super admin account cant be deleted.",
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		if user.UserDeleteAt != nil {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"success":    false,
+				"message":    "The deletion is already requested.",
+				"error_code": 5,
+				"data":       fiber.Map{"delete_at": user.UserDeleteAt},
+			})
+		}
+
+		deleteAt := time.Now().Add(accountDeleteGrace)
+		res = backend.db.Model(&user).Update("user_delete_at", deleteAt)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to save the deletion request, %v", res.Error),
+				"error_code": 6,
+				"data":       nil,
+			})
+		}
+
+		date := deleteAt.Format("2006-01-02 15:04")
+		mail, err := buildEmail(backend, user.UserEmail, "Your webrpl account will be deleted", "account-delete", map[string]any{
+			"Name": user.UserFullName,
+			"Date": date,
+		}, fmt.Sprintf("Hi %s,\n\nWe got a request to delete your account. It will be deleted on %s, your attendance history is kept without your name or email.\n\nChanged your mind? Login before that date and cancel it from your profile. If this was not you, cancel it and change your password right away.\n", user.UserFullName, date))
+		if err == nil {
+			err = queueEmail(backend, backend.db, mail)
+		}
+		if err != nil {
+			log.Printf("Failed to queue the account deletion email: %v", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "The account will be deleted after the grace period.",
+			"error_code": 0,
+			"data":       fiber.Map{"delete_at": deleteAt},
+		})
+	})
+}
+
+// POST : api/protected/user-delete-cancel
+func appHandleUserDeleteCancel(backend *Backend, route fiber.Router) {
+	route.Post("user-delete-cancel", requireSession, func(c *fiber.Ctx) error {
+		res := backend.db.Model(&table.User{}).
+			Where("id = ? AND user_delete_at IS NOT NULL", currentUserID(c)).
+			Update("user_delete_at", nil)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to cancel the deletion, %v", res.Error),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+		if res.RowsAffected <= 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    "There is no deletion request to cancel.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "The deletion is canceled.",
+			"error_code": 0,
+			"data":       nil,
+		})
+	})
+}
+
+// NOTE: Send an OTP to the new email, the change only happen on
+//       api/protected/user-email-change-confirm. `pass` is the current password.
+//       The OTP share the cooldown and daily cap of api/gen-otp-for-register.
+// POST : api/protected/user-email-change-request
+func appHandleUserEmailChangeRequest(backend *Backend, route fiber.Router) {
+	route.Post("user-email-change-request", requireSession, func(c *fiber.Ctx) error {
+		var body struct {
+			Email    string `json:"email"`
+			Password string `json:"pass"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.Email == "" || body.Password == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the email and pass.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		newEmail := strings.ToLower(strings.TrimSpace(body.Email))
+		if !isEmailValid(newEmail) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid email format.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		var user table.User
+		res := backend.db.First(&user, currentUserID(c))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		wait, err := loginThrottleAttempt(backend.db, user.UserEmail, throttleClientIP(c))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the failed login, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+		if wait > 0 {
+			return loginThrottled(c, wait, 9)
+		}
+		if !CheckPassword(user.UserPassword, body.Password) {
+			recordSecurityEvent(backend, c, &user, user.UserEmail, table.SecEmailChange, "failed, wrong password")
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Wrong password.",
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+		if err := loginThrottleUndo(backend.db, user.UserEmail, throttleClientIP(c)); err != nil {
+			log.Printf("Failed to give back the password attempt: %v", err)
+		}
+
+		if strings.EqualFold(newEmail, user.UserEmail) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "That is already the email of the account.",
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		var taken int64
+		res = backend.db.Model(&table.User{}).Where("LOWER(user_email) = ?", newEmail).Count(&taken)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the email, %v", res.Error),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+		if taken > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"success":    false,
+				"message":    "The email is already used by another account.",
+				"error_code": 6,
+				"data":       nil,
+			})
+		}
+
+		requestID, err := randomHex(16)
+		if err == nil {
+			var reason otpReject
+			reason, wait, err = otpRequestAllow(backend, newEmail, throttleClientIP(c), "email-change:"+requestID)
+			if err == nil && reason != "" {
+				return otpLimited(c, reason, wait, 7)
+			}
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the OTP limit, %v", err),
+				"error_code": 8,
+				"data":       nil,
+			})
+		}
+
+		code, err := createOTP(backend, backend.db, table.OTPEmailChange, newEmail, 6, emailChangeOTPTTL)
+		if err == nil {
+			err = backend.db.Model(&user).Update("user_pending_email", newEmail).Error
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to save the email change, %v", err),
+				"error_code": 8,
+				"data":       nil,
+			})
+		}
+
+		minutes := int(emailChangeOTPTTL.Minutes())
+		mail, err := buildEmail(backend, newEmail, "Confirm your new webrpl email", "otp", map[string]any{
+			"Code":    code,
+			"Minutes": minutes,
+		}, fmt.Sprintf("Your OTP code are : %s\n(Working for %d mins)", code, minutes))
+		if err == nil {
+			err = queueEmail(backend, backend.db, mail)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to queue the email, %v", err),
+				"error_code": 8,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "The OTP is sent to the new email.",
+			"error_code": 0,
+			"data":       fiber.Map{"pending_email": newEmail},
+		})
+	})
+}
+
+// NOTE: Every session is revoked after the change, the jwt still carry the old
+//       email so the user has to login again. The personal access token is kept,
+//       it is tied to the user and not the email. The old email get a notice.
+// POST : api/protected/user-email-change-confirm
+func appHandleUserEmailChangeConfirm(backend *Backend, route fiber.Router) {
+	route.Post("user-email-change-confirm", requireSession, func(c *fiber.Ctx) error {
+		var body struct {
+			OtpCode string `json:"otp_code"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.OtpCode == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the otp_code.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var user table.User
+		res := backend.db.First(&user, currentUserID(c))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+		if user.UserPendingEmail == "" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    "There is no email change to confirm.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		err = consumeOTP(backend, table.OTPEmailChange, user.UserPendingEmail, body.OtpCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, errOTPInvalid):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Wrong OTP.",
+					"error_code": 4,
+					"data":       nil,
+				})
+			case errors.Is(err, errOTPExpired):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "The OTP is expired. Please request the change again.",
+					"error_code": 5,
+					"data":       nil,
+				})
+			case errors.Is(err, errOTPTooMany):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Too many wrong OTP, the code is no longer valid. Please request the change again.",
+					"error_code": 6,
+					"data":       nil,
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the OTP, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		oldEmail, newEmail := user.UserEmail, user.UserPendingEmail
+		err = backend.db.Transaction(func(tx *gorm.DB) error {
+			var taken int64
+			if err := tx.Model(&table.User{}).Where("LOWER(user_email) = ? AND id <> ?", newEmail, user.ID).Count(&taken).Error; err != nil {
+				return err
+			}
+			if taken > 0 {
+				return errEmailTaken
+			}
+			res := tx.Model(&user).Updates(map[string]any{
+				"user_email":         newEmail,
+				"user_pending_email": "",
+			})
+			if res.Error != nil {
+				return res.Error
+			}
+			// The reset code of the old email shouldnt work anymore.
+			if err := tx.Unscoped().Where("user_email = ?", otpEmailKey(oldEmail)).Delete(&table.OTP{}).Error; err != nil {
+				return err
+			}
+			_, err := revokeUserSessions(tx, user.ID)
+			return err
+		})
+		if errors.Is(err, errEmailTaken) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"success":    false,
+				"message":    "The email is already used by another account.",
+				"error_code": 7,
+				"data":       nil,
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to change the email, %v", err),
+				"error_code": 8,
+				"data":       nil,
+			})
+		}
+
+		user.UserEmail = newEmail
+		recordSecurityEvent(backend, c, &user, newEmail, table.SecEmailChange, fmt.Sprintf("from %s to %s", oldEmail, newEmail))
+
+		mail, err := buildEmail(backend, oldEmail, "Your webrpl email was changed", "email-changed", map[string]any{
+			"Name":  user.UserFullName,
+			"Email": newEmail,
+		}, fmt.Sprintf("Hi %s,\n\nThe email of your account was just changed to %s. This address wont get any more email from us and every login is signed out.\n\nIf this was not you, please contact the admin right away.\n", user.UserFullName, newEmail))
+		if err == nil {
+			err = queueEmail(backend, backend.db, mail)
+		}
+		if err != nil {
+			log.Printf("Failed to queue the email change notice: %v", err)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "The email is changed, please login again with the new email.",
+			"error_code": 0,
+			"data":       fiber.Map{"email": newEmail},
+		})
+	})
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -31,10 +38,9 @@ func appHandleCertTempNew(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		claims := user.Claims.(jwt.MapClaims)
-		isAdmin := claims["admin"].(float64)
+		isAdmin := userCan(backend, c, permCertManage)
 
-		if isAdmin != 1 {
+		if !isAdmin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -96,7 +102,7 @@ func appHandleCertTempNew(backend *Backend, route fiber.Router) {
 // GET : api/protected/cert-info-of
 func appHandleCertTempInfoOf(backend *Backend, route fiber.Router) {
 	route.Get("cert-info-of", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -106,17 +112,6 @@ func appHandleCertTempInfoOf(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		email := claims["email"].(string)
-
-		if email == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Invalid email on JWT.",
-				"error_code": 2,
-				"data":       nil,
-			})
-		}
-
 		infoOf := c.Query("id")
 
 		if infoOf == "" {
@@ -161,7 +156,7 @@ func appHandleCertTempInfoOf(backend *Backend, route fiber.Router) {
 // POST : api/protected/cert-del
 func appHandleCertDel(backend *Backend, route fiber.Router) {
 	route.Post("cert-del", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -171,8 +166,8 @@ func appHandleCertDel(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		isAdmin := claims["admin"].(float64)
-		if isAdmin != 1 {
+		isAdmin := userCan(backend, c, permCertManage)
+		if !isAdmin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -217,7 +212,7 @@ func appHandleCertDel(backend *Backend, route fiber.Router) {
 // POST : api/protected/cert-edit
 func appHandleCertEdit(backend *Backend, route fiber.Router) {
 	route.Post("cert-edit", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -227,9 +222,9 @@ func appHandleCertEdit(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		isAdmin := claims["admin"].(float64)
+		isAdmin := userCan(backend, c, permCertManage)
 
-		if isAdmin != 1 {
+		if !isAdmin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -299,7 +294,7 @@ func appHandleCertEdit(backend *Backend, route fiber.Router) {
 // POST : api/protected/cert-upload-template
 func appHandleCertUploadTemplate(backend *Backend, route fiber.Router) {
 	route.Post("cert-upload-template", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -308,8 +303,8 @@ func appHandleCertUploadTemplate(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		admin := claims["admin"].(float64)
-		if admin != 1 {
+		admin := userCan(backend, c, permCertManage)
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -447,80 +442,408 @@ func appHandleCertUploadTemplate(backend *Backend, route fiber.Router) {
 	})
 }
 
+// NOTE: Lookup failure that can be turned straight into the json response.
+type certLookupError struct {
+	status  int
+	code    int
+	message string
+}
+
+func (e *certLookupError) Error() string {
+	return e.message
+}
+
+func (e *certLookupError) send(c *fiber.Ctx) error {
+	return c.Status(e.status).JSON(fiber.Map{
+		"success":    false,
+		"message":    e.message,
+		"error_code": e.code,
+		"data":       nil,
+	})
+}
+
+// NOTE: Find the participant (with the User and Event) that own the certificate code.
+func certFindParticipant(backend *Backend, code string) (*table.EventParticipant, *certLookupError) {
+	var evPart table.EventParticipant
+	res := backend.db.Preload("User").Preload("Event").Where(&table.EventParticipant{EventPCode: code}).First(&evPart)
+
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, &certLookupError{fiber.StatusBadRequest, 4, "Failed to get the cert for that code"}
+		}
+		return nil, &certLookupError{fiber.StatusInternalServerError, 1, fmt.Sprintf("Failed to fetch event participant for this code, %v", res.Error)}
+	}
+	return &evPart, nil
+}
+
+// NOTE: Everything that need to be checked before the certificate can be rendered.
+func certLookup(backend *Backend, code string) (*table.EventParticipant, *table.CertTemplate, *certLookupError) {
+	evPart, lerr := certFindParticipant(backend, code)
+	if lerr != nil {
+		return nil, nil, lerr
+	}
+
+	if !evPart.EventPCome {
+		return nil, nil, &certLookupError{fiber.StatusBadRequest, 4, "Failed to get the cert for that code"}
+	}
+
+	now := time.Now()
+	if evPart.Event.EventDEnd.After(now) {
+		return nil, nil, &certLookupError{fiber.StatusBadRequest, 3, "The event is not done yet."}
+	}
+
+	var cerTemp table.CertTemplate
+	res := backend.db.Where("event_id = ?", evPart.EventId).First(&cerTemp)
+	if res.Error != nil {
+		return nil, nil, &certLookupError{fiber.StatusInternalServerError, 2, fmt.Sprintf("Failed to fetch certificate template from the db, %v", res.Error)}
+	}
+
+	if _, err := os.Stat(fmt.Sprintf("./static/%s", cerTemp.CertTemplate)); os.IsNotExist(err) {
+		return nil, nil, &certLookupError{fiber.StatusBadRequest, 3, fmt.Sprintf("The Certificate template file didnt exist, Please contact the committee or admin to add them. DEBUG PURPOSE: %s", fmt.Sprintf("./static/%s", cerTemp.CertTemplate))}
+	}
+
+	return evPart, &cerTemp, nil
+}
+
+func certTemplateName(cerTemp *table.CertTemplate) string {
+	// Strip the .html from the cerTemp
+	return strings.TrimSuffix(cerTemp.CertTemplate, ".html")
+}
+
+func certTemplateData(code string, evPart *table.EventParticipant) fiber.Map {
+	return fiber.Map{
+		"UniqueID":  code,
+		"EventName": evPart.Event.EventName,
+		"UserName":  evPart.User.UserFullName,
+		"UserRole":  evPart.EventPRole,
+	}
+}
+
+// NOTE: Render the same template as the html certificate then lay it out
+//       on the pdf with the bg.png of the template directory.
+func certWritePDF(backend *Backend, out io.Writer, code string, evPart *table.EventParticipant, cerTemp *table.CertTemplate) error {
+	var rendered bytes.Buffer
+	err := backend.engine.Render(&rendered, certTemplateName(cerTemp), certTemplateData(code, evPart))
+	if err != nil {
+		return err
+	}
+
+	objects, err := certParseObjects(&rendered)
+	if err != nil {
+		return err
+	}
+
+	bgPath := filepath.Join("./static", filepath.Dir(cerTemp.CertTemplate), "bg.png")
+	return certRenderPDF(out, objects, bgPath)
+}
+
+// NOTE: Need to be registered before api/certificate/:base64 or that one will take it.
+// GET : api/certificate/:base64.pdf
+func appHandleCertificateRoomPDF(backend *Backend, route fiber.Router) {
+	route.Get("certificate/:base64.pdf", func(c *fiber.Ctx) error {
+		base64Param := c.Params("base64")
+
+		evPart, cerTemp, lerr := certLookup(backend, base64Param)
+		if lerr != nil {
+			return lerr.send(c)
+		}
+
+		var buf bytes.Buffer
+		if err := certWritePDF(backend, &buf, base64Param, evPart, cerTemp); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to render the certificate pdf, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("inline; filename=\"certificate-%d.pdf\"", evPart.ID))
+		return c.Status(fiber.StatusOK).Send(buf.Bytes())
+	})
+}
+
 // GET : api/certificate/:base64
 func appHandleCertificateRoom(backend *Backend, route fiber.Router) {
 	route.Get("certificate/:base64", func(c *fiber.Ctx) error {
 		base64Param := c.Params("base64")
 
-		var evPart table.EventParticipant
-		res := backend.db.Preload("User").Preload("Event").Where(&table.EventParticipant{EventPCode: base64Param, EventPCome: true}).First(&evPart)
+		evPart, cerTemp, lerr := certLookup(backend, base64Param)
+		if lerr != nil {
+			return lerr.send(c)
+		}
 
-		if res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"success":    false,
-					"message":    "Failed to get the cert for that code",
-					"error_code": 4,
-					"data":       nil,
-				})
-			}
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		return c.Render(certTemplateName(cerTemp), certTemplateData(base64Param, evPart))
+	})
+}
+
+// NOTE: What a third party get to see when verifying a certificate.
+type certVerifyRecord struct {
+	Code       string                  `json:"code"`
+	HolderName string                  `json:"holder_name"`
+	EventName  string                  `json:"event_name"`
+	EventStart time.Time               `json:"event_dstart"`
+	EventEnd   time.Time               `json:"event_dend"`
+	Role       table.UserEventRoleEnum `json:"role"`
+	Attended   bool                    `json:"attended"`
+}
+
+// NOTE: HMAC-SHA256 of every field (in the struct order), each one written as
+//       `len:value` so a name or event title with a separator in it cant be
+//       read as two field. The key is derived from the server secret so it
+//       cant be made outside.
+func certSignRecord(backend *Backend, rec *certVerifyRecord) string {
+	fields := []string{
+		rec.Code,
+		rec.HolderName,
+		rec.EventName,
+		rec.EventStart.UTC().Format(time.RFC3339),
+		rec.EventEnd.UTC().Format(time.RFC3339),
+		string(rec.Role),
+		strconv.FormatBool(rec.Attended),
+	}
+
+	mac := hmac.New(sha256.New, []byte("certificate-verify:"+backend.pass))
+	for _, field := range fields {
+		mac.Write([]byte(strconv.Itoa(len(field)) + ":" + field))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NOTE: Public, return json only and never render the template.
+// GET : api/certificate-verify/:code
+func appHandleCertificateVerify(backend *Backend, route fiber.Router) {
+	route.Get("certificate-verify/:code", func(c *fiber.Ctx) error {
+		evPart, lerr := certFindParticipant(backend, c.Params("code"))
+		if lerr != nil {
+			return lerr.send(c)
+		}
+
+		rec := certVerifyRecord{
+			Code:       evPart.EventPCode,
+			HolderName: evPart.User.UserFullName,
+			EventName:  evPart.Event.EventName,
+			EventStart: evPart.Event.EventDStart,
+			EventEnd:   evPart.Event.EventDEnd,
+			Role:       evPart.EventPRole,
+			Attended:   evPart.EventPCome,
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Certificate found.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"record":        rec,
+				"signature":     certSignRecord(backend, &rec),
+				"signature_alg": "HMAC-SHA256",
+			},
+		})
+	})
+}
+
+// NOTE: Check a record + signature that was saved from certificate-verify before,
+//       so nobody can edit the record and still pass it as real.
+// POST : api/certificate-verify
+func appHandleCertificateVerifySignature(backend *Backend, route fiber.Router) {
+	route.Post("certificate-verify", func(c *fiber.Ctx) error {
+		var body struct {
+			Record    certVerifyRecord `json:"record"`
+			Signature string           `json:"signature"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
-				"message":    fmt.Sprintf("Failed to fetch event participant for this code, %v", res.Error),
+				"message":    fmt.Sprintf("Invalid body request, %v", err),
 				"error_code": 1,
 				"data":       nil,
 			})
 		}
 
-		now := time.Now()
-		if evPart.Event.EventDEnd.After(now) {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		expected := certSignRecord(backend, &body.Record)
+		valid := hmac.Equal([]byte(expected), []byte(strings.ToLower(body.Signature)))
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"valid": valid,
+			},
+		})
+	})
+}
+
+// NOTE: Start rendering every certificate of the event (only the attended one) into a zip.
+//       `format` is `html` (default) or `pdf`. Use the returned id on
+//       cert-bulk-zip-status to see the progress and cert-bulk-zip-download to get it.
+// POST : api/protected/cert-bulk-zip
+func appHandleCertBulkZip(backend *Backend, route fiber.Router) {
+	route.Post("cert-bulk-zip", func(c *fiber.Ctx) error {
+		currentUser, err := GetJWT(c)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
-				"message":    "The event is not done yet.",
-				"error_code": 3,
+				"message":    "Invalid JWT token.",
+				"error_code": 1,
 				"data":       nil,
 			})
 		}
 
-		var cerTemp table.CertTemplate
-		res = backend.db.Where("event_id = ?", evPart.EventId).First(&cerTemp)
-		if res.Error != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		admin := userCan(backend, c, permCertManage)
+
+		var body struct {
+			EventID int    `json:"event_id"`
+			Format  string `json:"format"`
+		}
+
+		err = c.BodyParser(&body)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
-				"message":    fmt.Sprintf("Failed to fetch certificate template from the db, %v", res.Error),
+				"message":    fmt.Sprintf("Invalid body request, %v", err),
 				"error_code": 2,
 				"data":       nil,
 			})
 		}
 
-		if _, err := os.Stat(fmt.Sprintf("./static/%s", cerTemp.CertTemplate)); os.IsNotExist(err) {
+		if body.Format == "" {
+			body.Format = "html"
+		}
+		if body.Format != "html" && body.Format != "pdf" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
-				"message":    fmt.Sprintf("The Certificate template file didnt exist, Please contact the committee or admin to add them. DEBUG PURPOSE: %s", fmt.Sprintf("./static/%s", cerTemp.CertTemplate)),
+				"message":    "Invalid format, the only valid strings are : `html` and `pdf`",
 				"error_code": 3,
 				"data":       nil,
 			})
 		}
 
-		// Strip the .html from the cerTemp
-		stripped := strings.TrimSuffix(cerTemp.CertTemplate, ".html")
+		if !admin {
+			committee, err := eventCommitteeCan(backend, currentUser.ID, body.EventID, committeeCert)
+			if err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"success":    false,
+					"message":    fmt.Sprintf("Failed to fetch the event participant of this user, %v", err),
+					"error_code": 4,
+					"data":       nil,
+				})
+			}
+			if !committee {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Invalid credentials for this function",
+					"error_code": 5,
+					"data":       nil,
+				})
+			}
+		}
+
+		event, cerTemp, lerr := certBulkPrepare(backend.db, body.EventID)
+		if lerr != nil {
+			return lerr.send(c)
+		}
+
+		idBytes := make([]byte, 16)
+		if _, err := cryptorand.Read(idBytes); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to create the job id, %v", err),
+				"error_code": 10,
+				"data":       nil,
+			})
+		}
+
+		job := &certBulkJob{
+			ID:        hex.EncodeToString(idBytes),
+			EventID:   event.ID,
+			Format:    body.Format,
+			Owner:     currentUser.ID,
+			CreatedAt: time.Now(),
+			status:    certBulkRunning,
+		}
+		backend.certJobs.add(job)
+		go certBulkRun(backend, job, event, cerTemp)
 
-		return c.Render(stripped, fiber.Map{
-			"UniqueID":  base64Param,
-			"EventName": evPart.Event.EventName,
-			"UserName":  evPart.User.UserFullName,
-			"UserRole": evPart.EventPRole,
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Certificate zip generation started.",
+			"error_code": 0,
+			"data":       job.Progress(),
 		})
 	})
 }
 
+// NOTE: Only the one that start the job (or admin) can see it.
+func certBulkJobOf(backend *Backend, c *fiber.Ctx) (*certBulkJob, *certLookupError) {
+	currentUser, err := GetJWT(c)
+	if err != nil {
+		return nil, &certLookupError{fiber.StatusInternalServerError, 1, "Invalid JWT token."}
+	}
+
+	job := backend.certJobs.get(c.Query("id"))
+	if job == nil {
+		return nil, &certLookupError{fiber.StatusNotFound, 2, "There is no job with that id."}
+	}
+
+	if !userCan(backend, c, permCertManage) && currentUser.ID != job.Owner {
+		return nil, &certLookupError{fiber.StatusUnauthorized, 3, "Invalid credentials for this function"}
+	}
+	return job, nil
+}
+
+// GET : api/protected/cert-bulk-zip-status
+func appHandleCertBulkZipStatus(backend *Backend, route fiber.Router) {
+	route.Get("cert-bulk-zip-status", func(c *fiber.Ctx) error {
+		job, lerr := certBulkJobOf(backend, c)
+		if lerr != nil {
+			return lerr.send(c)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data":       job.Progress(),
+		})
+	})
+}
+
+// GET : api/protected/cert-bulk-zip-download
+func appHandleCertBulkZipDownload(backend *Backend, route fiber.Router) {
+	route.Get("cert-bulk-zip-download", func(c *fiber.Ctx) error {
+		job, lerr := certBulkJobOf(backend, c)
+		if lerr != nil {
+			return lerr.send(c)
+		}
+
+		job.mutex.Lock()
+		status, zipPath := job.status, job.zipPath
+		job.mutex.Unlock()
+
+		if status != certBulkDone {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("The zip is not ready, the job is %s.", status),
+				"error_code": 4,
+				"data":       job.Progress(),
+			})
+		}
+
+		return c.Download(zipPath, fmt.Sprintf("certificates-event-%d.zip", job.EventID))
+	})
+}
+
 // new but dumb stuff
 
 // NOTE: wrapper around alot of independent api so it is more locked up.
 // POST : api/protected/create-new-cert-from-event
 func appHandleCertNewDumb(backend *Backend, route fiber.Router) {
 	route.Post("create-new-cert-from-event", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		currentUser, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -530,17 +853,7 @@ func appHandleCertNewDumb(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		email := claims["email"].(string)
-		admin := claims["admin"].(float64)
-
-		if email == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Invalid email on JWT.",
-				"error_code": 2,
-				"data":       nil,
-			})
-		}
+		admin := userCan(backend, c, permCertManage)
 
 		var body struct {
 			EventID int `json:"event_id"`
@@ -556,20 +869,9 @@ func appHandleCertNewDumb(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		var currentUser table.User
-		res := backend.db.Where("user_email = ?", email).First(&currentUser)
-		if res.Error != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success":    false,
-				"message":    fmt.Sprintf("Failed to fetch user from db, %v", res.Error),
-				"error_code": 4,
-				"data":       nil,
-			})
-		}
-
 		var currentEvPart table.EventParticipant
-		if admin != 1 {
-			res = backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventID).First(&currentEvPart)
+		if !admin {
+			res := backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventID).First(&currentEvPart)
 			if res.Error != nil {
 				if errors.Is(res.Error, gorm.ErrRecordNotFound) {
 					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -587,7 +889,7 @@ func appHandleCertNewDumb(backend *Backend, route fiber.Router) {
 					})
 				}
 			}
-			if currentEvPart.EventPRole != "committee" {
+			if !committeeHas(&currentEvPart, committeeCert) {
 				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 					"success":    false,
 					"message":    "Invalid credentials for this function",
@@ -605,7 +907,7 @@ func appHandleCertNewDumb(backend *Backend, route fiber.Router) {
 			CertTemplate: cert_path,
 		}
 
-		res = backend.db.Save(&newCertTemplate)
+		res := backend.db.Save(&newCertTemplate)
 		if res.Error != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -631,7 +933,7 @@ func appHandleCertNewDumb(backend *Backend, route fiber.Router) {
 // GET : api/c/cert-editor
 func appHandleCertEditor(backend *Backend, route fiber.Router) {
 	route.Get("cert-editor", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -640,10 +942,9 @@ func appHandleCertEditor(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		admin := claims["admin"].(float64)
-		email := claims["email"].(string)
+		admin := userCan(backend, c, permCertManage)
 
-		if admin != 1 {
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -652,15 +953,6 @@ func appHandleCertEditor(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		if email == "" {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Invalid email on JWT.",
-				"error_code": 3,
-				"data":       nil,
-			})
-		}
-
 		event_id := c.Query("event_id")
 		if event_id == "" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -694,7 +986,7 @@ func appHandleCertEditor(backend *Backend, route fiber.Router) {
 // POST : api/c/-cert-editor-upload-image
 func appHandleCertEditorUploadImage(backend *Backend, route fiber.Router) {
 	route.Post("-cert-editor-upload-image", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		currentUser, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -704,28 +996,7 @@ func appHandleCertEditorUploadImage(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		admin := claims["admin"].(float64)
-		email := claims["email"].(string)
-
-		if email == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Invalid empty email.",
-				"error_code": 3,
-				"data":       nil,
-			})
-		}
-
-		var currentUser table.User
-		res := backend.db.Where("user_email = ?", email).First(&currentUser)
-		if res.Error != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success":    false,
-				"message":    fmt.Sprintf("Failed to get the user with that email from the db, %v", err),
-				"error_code": 9,
-				"data":       nil,
-			})
-		}
+		admin := userCan(backend, c, permCertManage)
 
 		var body struct {
 			Data    string `json:"data"`
@@ -743,8 +1014,8 @@ func appHandleCertEditorUploadImage(backend *Backend, route fiber.Router) {
 		}
 
 		var currentEventPart table.EventParticipant
-		res = backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventID).First(&currentEventPart)
-		if res.Error != nil && admin != 1 {
+		res := backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventID).First(&currentEventPart)
+		if res.Error != nil && !admin {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
 				"message":    fmt.Sprintf("Failed to get the event participant with that user and event from the db, %v", res.Error),
@@ -753,7 +1024,7 @@ func appHandleCertEditorUploadImage(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		if admin != 1 && currentEventPart.EventPRole != "committee" {
+		if !admin && !committeeHas(&currentEventPart, committeeCert) {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -848,7 +1119,7 @@ func appHandleCertEditorUploadImage(backend *Backend, route fiber.Router) {
 // POST : api/c/-cert-editor-upload-html
 func appHandleCertEditorUploadHtml(backend *Backend, route fiber.Router) {
 	route.Post("-cert-editor-upload-html", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		currentUser, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -858,28 +1129,7 @@ func appHandleCertEditorUploadHtml(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		admin := claims["admin"].(float64)
-		email := claims["email"].(string)
-
-		if email == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Invalid empty email.",
-				"error_code": 3,
-				"data":       nil,
-			})
-		}
-
-		var currentUser table.User
-		res := backend.db.Where("user_email = ?", email).First(&currentUser)
-		if res.Error != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success":    false,
-				"message":    fmt.Sprintf("Failed to get the user with that email from the db, %v", res.Error),
-				"error_code": 9,
-				"data":       nil,
-			})
-		}
+		admin := userCan(backend, c, permCertManage)
 
 		var body struct {
 			Data    string `json:"data"`
@@ -897,8 +1147,8 @@ func appHandleCertEditorUploadHtml(backend *Backend, route fiber.Router) {
 		}
 
 		var currentEventPart table.EventParticipant
-		res = backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventID).First(&currentEventPart)
-		if res.Error != nil && admin != 1 {
+		res := backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventID).First(&currentEventPart)
+		if res.Error != nil && !admin {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
 				"message":    fmt.Sprintf("Failed to get the event participant with that user and event from the db, %v", res.Error),
@@ -907,7 +1157,7 @@ func appHandleCertEditorUploadHtml(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		if admin != 1 && currentEventPart.EventPRole != "committee" {
+		if !admin && !committeeHas(&currentEventPart, committeeCert) {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestCertSignRecordFieldBoundary(t *testing.T) {
+	backend := &Backend{pass: "secret"}
+	a := certVerifyRecord{Code: "code", HolderName: "Budi\nWebinar", EventName: "Go"}
+	b := certVerifyRecord{Code: "code", HolderName: "Budi", EventName: "Webinar\nGo"}
+	if certSignRecord(backend, &a) == certSignRecord(backend, &b) {
+		t.Fatal("records with the same fields joined together got the same signature")
+	}
+	if certSignRecord(backend, &a) != certSignRecord(backend, &a) {
+		t.Fatal("the same record got a different signature")
+	}
+}
@@ -5,6 +5,7 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
+	"log"
 	"os"
 	"strconv"
 	"strings"
@@ -18,7 +19,7 @@ import (
 func appHandleEventNew(backend *Backend, route fiber.Router) {
 	route.Post("event-register", func(c *fiber.Ctx) error {
 
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -28,9 +29,9 @@ func appHandleEventNew(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		isAdmin := claims["admin"].(float64)
+		isAdmin := userCan(backend, c, permEventCreate)
 
-		if isAdmin != 1 {
+		if !isAdmin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -83,10 +84,11 @@ func appHandleEventNew(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		if body.Max <= 0 {
+		// NOTE: max of 0 is no limit.
+		if body.Max < 0 {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
-				"message":    "Event with max of <= 0 is not possible",
+				"message":    "Event with max of < 0 is not possible, use 0 for no limit.",
 				"error_code": 7,
 				"data":       nil,
 			})
@@ -100,8 +102,7 @@ func appHandleEventNew(backend *Backend, route fiber.Router) {
 			EventSpeaker: body.Speaker,
 			EventAtt:     table.AttTypeEnum(body.Att),
 			EventImg:     body.Img,
-			// EventMax:     body.Max,
-			EventMax:     1, // UNUSED
+			EventMax:     body.Max,
 			EventLink:    body.Link,
 		}
 
@@ -140,7 +141,7 @@ func appHandleEventNew(backend *Backend, route fiber.Router) {
 // GET : api/protected/event-info-all
 func appHandleEventInfoAll(backend *Backend, route fiber.Router) {
 	route.Get("event-info-all", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -150,16 +151,6 @@ func appHandleEventInfoAll(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		email := claims["email"].(string)
-		if email == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Not logged in.",
-				"error_code": 2,
-				"data":       nil,
-			})
-		}
-
 		offsetQuery := c.Query("offset")
 		if offsetQuery == "" {
 			offsetQuery = "0"
@@ -203,7 +194,7 @@ func appHandleEventInfoAll(backend *Backend, route fiber.Router) {
 // GET : api/protected/event-info-of
 func appHandleEventInfoOf(backend *Backend, route fiber.Router) {
 	route.Get("event-info-of", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -213,16 +204,6 @@ func appHandleEventInfoOf(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		email := claims["email"].(string)
-		if email == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Invalid email on JWT.",
-				"error_code": 2,
-				"data":       nil,
-			})
-		}
-
 		infoOf := c.Query("id")
 
 		infoOfInt, err := strconv.Atoi(infoOf)
@@ -255,10 +236,11 @@ func appHandleEventInfoOf(backend *Backend, route fiber.Router) {
 	})
 }
 
+// NOTE: The participant, material and certificate template go to the trash with the event.
 // POST : api/protected/event-del
 func appHandleEventDel(backend *Backend, route fiber.Router) {
 	route.Post("event-del", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -267,8 +249,8 @@ func appHandleEventDel(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		admin := claims["admin"].(float64)
-		if admin != 1 {
+		admin := userCan(backend, c, permEventDelete)
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials to acces this api.",
@@ -289,8 +271,8 @@ func appHandleEventDel(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		res := backend.db.Delete(&table.Event{}, body.EventId)
-		if res.Error != nil {
+		_, err = softDeleteEvent(backend.db, body.EventId)
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Failed to delete event from the DB.",
@@ -310,7 +292,7 @@ func appHandleEventDel(backend *Backend, route fiber.Router) {
 // POST : api/protected/event-edit
 func appHandleEventEdit(backend *Backend, route fiber.Router) {
 	route.Post("event-edit", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		currentUser, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -320,8 +302,7 @@ func appHandleEventEdit(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		isAdmin := claims["admin"].(float64)
-		email := claims["email"].(string)
+		isAdmin := userCan(backend, c, permEventEdit)
 
 		var body struct {
 			EventId      int        `json:"id"`
@@ -359,20 +340,9 @@ func appHandleEventEdit(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		if isAdmin != 1 {
-			var selUser table.User
-			res := backend.db.Where("user_email = ?", email).First(&selUser)
-			if res.Error != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"success":    false,
-					"message":    "Failed to fetch the event participant of this user.",
-					"error_code": 8,
-					"data":       nil,
-				})
-			}
-
+		if !isAdmin {
 			var evPart table.EventParticipant
-			res = backend.db.Where("event_id = ? AND user_id = ?", body.EventId, selUser.ID).First(&evPart)
+			res := backend.db.Where("event_id = ? AND user_id = ?", body.EventId, currentUser.ID).First(&evPart)
 			if res.Error != nil {
 				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 					"success":    false,
@@ -381,7 +351,7 @@ func appHandleEventEdit(backend *Backend, route fiber.Router) {
 					"data":       nil,
 				})
 			}
-			if evPart.EventPRole != "committee" {
+			if !committeeHas(&evPart, committeeEditEvent) {
 				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 					"success":    false,
 					"message":    "Invalid credentials for this function",
@@ -415,9 +385,17 @@ func appHandleEventEdit(backend *Backend, route fiber.Router) {
 		if body.Img != nil {
 			event.EventImg = *body.Img
 		}
-		// if body.Max != nil {
-		// 	event.EventMax = *body.Max
-		// }
+		if body.Max != nil {
+			if *body.Max < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Event with max of < 0 is not possible, use 0 for no limit.",
+					"error_code": 10,
+					"data":       nil,
+				})
+			}
+			event.EventMax = *body.Max
+		}
 		if body.CertTemplate != nil {
 			var cert_temp table.CertTemplate
 			res := backend.db.Where("id = ?", *body.CertTemplate).First(&cert_temp)
@@ -467,6 +445,13 @@ func appHandleEventEdit(backend *Backend, route fiber.Router) {
 			})
 		}
 
+		// NOTE: The max may be raised so give the free seat to the waitlist.
+		if body.Max != nil {
+			if _, err := promoteWaitlist(backend, event.ID); err != nil {
+				log.Printf("Failed to promote the waitlist of event %d: %v", event.ID, err)
+			}
+		}
+
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"success":    true,
 			"message":    "Event edited successfully.",
@@ -483,7 +468,7 @@ func appHandleEventUploadImage(backend *Backend, route fiber.Router) {
 			Data string `json:"data"`
 		}
 
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -493,9 +478,9 @@ func appHandleEventUploadImage(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		admin := claims["admin"].(float64)
+		admin := userCan(backend, c, permEventEdit)
 
-		if admin != 1 {
+		if !admin {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials for this function",
@@ -586,7 +571,7 @@ func appHandleEventUploadImage(backend *Backend, route fiber.Router) {
 // GET : api/protected/event-count
 func appHandleEventCount(backend *Backend, route fiber.Router) {
 	route.Get("event-count", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -596,16 +581,6 @@ func appHandleEventCount(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		email := claims["email"].(string)
-		if email == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Not logged in.",
-				"error_code": 2,
-				"data":       nil,
-			})
-		}
-
 		var count int64
 		res := backend.db.Model(&table.Event{}).Count(&count)
 		if res.Error != nil {
@@ -630,7 +605,7 @@ func appHandleEventCount(backend *Backend, route fiber.Router) {
 // GET : api/protected/event-search
 func appHandleEventSearch(backend *Backend, route fiber.Router) {
 	route.Get("event-search", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -640,16 +615,6 @@ func appHandleEventSearch(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		email := claims["email"].(string)
-		if email == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Not logged in.",
-				"error_code": 2,
-				"data":       nil,
-			})
-		}
-
 		// Get query parameters
 		offsetQuery := c.Query("offset", "0")
 		limitQuery := c.Query("limit", "10")
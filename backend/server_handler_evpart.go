@@ -1,14 +1,19 @@
 package main
 
 import (
+    "bufio"
+    "encoding/csv"
     "strconv"
     "fmt"
     "webrpl/table"
     "errors"
+    "log"
     "time"
+    "strings"
 
     "gorm.io/gorm"
     "github.com/gofiber/fiber/v2"
+    "github.com/xuri/excelize/v2"
 )
 
 // NOTE : if not supplied with `email` on the json it will presume to use
@@ -17,7 +22,7 @@ import (
 // POST : api/protected/event-participate-register
 func appHandleEventParticipateRegister(backend *Backend, route fiber.Router) {
     route.Post("event-participate-register", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        caller, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -26,17 +31,8 @@ func appHandleEventParticipateRegister(backend *Backend, route fiber.Router) {
                 "data": nil,
             })
         }
-        admin := claims["admin"].(float64)
-        email := claims["email"].(string)
-
-        if email == "" {
-            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-                "success": false,
-                "message": "Invalid email on JWT.",
-                "error_code": 2,
-                "data": nil,
-            })
-        }
+        admin := userCan(backend, c, permParticipantManage)
+        email := caller.UserEmail
 
         var body struct {
             EventId         int     `json:"id"`
@@ -54,7 +50,7 @@ func appHandleEventParticipateRegister(backend *Backend, route fiber.Router) {
             })
         }
 
-        if admin != 1 && body.Role == "committee" {
+        if !admin && body.Role == "committee" {
             return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid Credentials.",
@@ -83,28 +79,8 @@ func appHandleEventParticipateRegister(backend *Backend, route fiber.Router) {
             })
         }
 
-        // var eventParticipantCount int64
-        // res = backend.db.Model(&table.EventParticipant{}).Where("event_id = ?", body.EventId).Count(&eventParticipantCount)
-        // if res.Error != nil {
-        //     return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-        //         "success": false,
-        //         "message": "Failed to fetch event count from db.",
-        //         "error_code": 7,
-        //         "data": nil,
-        //     })
-        // }
-
-        // if event.EventMax <= int(eventParticipantCount) + 1 {
-        //     return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-        //         "success": false,
-        //         "message": "Event is already full.",
-        //         "error_code": 8,
-        //         "data": nil,
-        //     })
-        // }
-
         useThisEmail := email
-        if admin == 1 && body.CustomUserEmail != nil && *body.CustomUserEmail != "" {
+        if admin && body.CustomUserEmail != nil && *body.CustomUserEmail != "" {
             useThisEmail = *body.CustomUserEmail
         }
 
@@ -151,26 +127,61 @@ func appHandleEventParticipateRegister(backend *Backend, route fiber.Router) {
         NewEventParticipate := table.EventParticipant{
             EventId: body.EventId,
             UserId: currentUser.ID,
-            EventPRole: table.UserEventRoleEnum(body.Role),
             EventPCome: Absence,
             EventPCode: random_strings,
         }
+        setCommitteeRole(&NewEventParticipate, table.UserEventRoleEnum(body.Role))
 
-        res = backend.db.Create(&NewEventParticipate)
-        if res.Error != nil {
+        // NOTE: When the event is full the normal participant go to the waitlist,
+        //       the count and the insert is on the same transaction so it cant overbook.
+        var waitPosition int64
+        err = backend.db.Transaction(func(tx *gorm.DB) error {
+            if NewEventParticipate.EventPRole == table.NormalU {
+                full, err := isEventFull(tx, &event)
+                if err != nil {
+                    return err
+                }
+                NewEventParticipate.EventPWait = full
+            }
+            if err := tx.Create(&NewEventParticipate).Error; err != nil {
+                return err
+            }
+            if NewEventParticipate.EventPWait {
+                return tx.Model(&table.EventParticipant{}).
+                    Where("event_id = ? AND eventp_wait = ? AND id <= ?", body.EventId, true, NewEventParticipate.ID).
+                    Count(&waitPosition).Error
+            }
+            return nil
+        })
+        if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
-                "message": fmt.Sprintf("Failed to create new event participant, %v", res.Error),
+                "message": fmt.Sprintf("Failed to create new event participant, %v", err),
                 "error_code": 10,
                 "data": nil,
             })
         }
 
+        if NewEventParticipate.EventPWait {
+            return c.Status(fiber.StatusOK).JSON(fiber.Map{
+                "success": true,
+                "message": "Event is already full, registered on the waitlist.",
+                "error_code": 0,
+                "data": fiber.Map{
+                    "waitlist": true,
+                    "position": waitPosition,
+                },
+            })
+        }
+
         return c.Status(fiber.StatusOK).JSON(fiber.Map{
             "success": true,
             "message": "New Event EventParticipant created.",
             "error_code": 0,
-            "data": nil,
+            "data": fiber.Map{
+                "waitlist": false,
+                "position": 0,
+            },
         })
     })
 }
@@ -178,7 +189,7 @@ func appHandleEventParticipateRegister(backend *Backend, route fiber.Router) {
 // GET : api/protected/event-participate-info-of
 func appHandleEventParticipateInfoOf(backend *Backend, route fiber.Router) {
     route.Get("event-participate-info-of", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        caller, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -187,17 +198,8 @@ func appHandleEventParticipateInfoOf(backend *Backend, route fiber.Router) {
                 "data": nil,
             })
         }
-        email := claims["email"].(string)
-        admin := claims["admin"].(float64)
-
-        if email == "" {
-            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-                "success": false,
-                "message": "Invalid email on JWT.",
-                "error_code": 2,
-                "data": nil,
-            })
-        }
+        email := caller.UserEmail
+        admin := userCan(backend, c, permParticipantRead)
 
         emailQuery := c.Query("email")
         idQuery := c.Query("event_id")
@@ -220,7 +222,7 @@ func appHandleEventParticipateInfoOf(backend *Backend, route fiber.Router) {
         }
 
         useThisEmail := email
-        if admin == 1 && emailQuery != "" {
+        if admin && emailQuery != "" {
             useThisEmail = emailQuery
         }
 
@@ -263,7 +265,7 @@ func appHandleEventParticipateInfoOf(backend *Backend, route fiber.Router) {
 // POST : api/protected/event-participate-del
 func appHandleEventParticipateDel(backend *Backend, route fiber.Router) {
     route.Post("event-participate-del", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        caller, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -273,7 +275,7 @@ func appHandleEventParticipateDel(backend *Backend, route fiber.Router) {
             })
         }
 
-        isAdmin := claims["admin"].(float64)
+        isAdmin := userCan(backend, c, permParticipantManage)
 
         var body struct {
             EventID    int    `json:"event_id"`
@@ -312,13 +314,28 @@ func appHandleEventParticipateDel(backend *Backend, route fiber.Router) {
             })
         }
 
-        if isAdmin != 1 && selEvPart.EventPRole != "committee" {
-            return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-                "success": false,
-                "message": "Invalid credentials for this function",
-                "error_code": 2,
-                "data": nil,
-            })
+        if !isAdmin {
+            allowed, err := eventCommitteeCan(backend, caller.ID, body.EventID, committeeParticipants)
+            if err != nil || !allowed {
+                return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                    "success": false,
+                    "message": "Invalid credentials for this function",
+                    "error_code": 2,
+                    "data": nil,
+                })
+            }
+            // NOTE: Removing another committee is the lead job.
+            if selEvPart.EventPRole == table.CommitteeU {
+                isLead, err := eventCommitteeCan(backend, caller.ID, body.EventID, committeeLead)
+                if err != nil || !isLead {
+                    return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                        "success": false,
+                        "message": "Only the committee lead can remove another committee",
+                        "error_code": 2,
+                        "data": nil,
+                    })
+                }
+            }
         }
 
         res = backend.db.Delete(&table.EventParticipant{}, &selEvPart)
@@ -331,6 +348,13 @@ func appHandleEventParticipateDel(backend *Backend, route fiber.Router) {
             })
         }
 
+        // NOTE: A seat is freed so the waitlist move up.
+        if selEvPart.EventPRole == table.NormalU && !selEvPart.EventPWait {
+            if _, err := promoteWaitlist(backend, body.EventID); err != nil {
+                log.Printf("Failed to promote the waitlist of event %d: %v", body.EventID, err)
+            }
+        }
+
         return c.Status(fiber.StatusOK).JSON(fiber.Map{
             "success": true,
             "message": "event participant deleted.",
@@ -344,7 +368,7 @@ func appHandleEventParticipateDel(backend *Backend, route fiber.Router) {
 // POST : api/protected/event-participate-edit
 func appHandleEventParticipateEdit(backend *Backend, route fiber.Router) {
     route.Post("event-participate-edit", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        currentUser, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -354,8 +378,8 @@ func appHandleEventParticipateEdit(backend *Backend, route fiber.Router) {
             })
         }
 
-        admin := claims["admin"].(float64)
-        currentUserEmail := claims["email"].(string)
+        admin := userCan(backend, c, permParticipantManage)
+        currentUserEmail := currentUser.UserEmail
 
         var body struct {
             EventID    int     `json:"event_id"`
@@ -390,28 +414,18 @@ func appHandleEventParticipateEdit(backend *Backend, route fiber.Router) {
         }
 
         // Check authorization: Only admins or committee members can edit roles
-        if admin != 1 {
-            // Check if current user is committee for this event
-            var currentEventParticipant table.EventParticipant
-            res := backend.db.Where("user_email = ? AND event_id = ?", currentUserEmail, body.EventID).First(&currentEventParticipant)
-            if res.Error != nil {
-                if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-                    return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
-                        "success": false,
-                        "message": "You are not a participant of this event.",
-                        "error_code": 4,
-                        "data": nil,
-                    })
-                }
+        isLead := false
+        if !admin {
+            allowed, err := eventCommitteeCan(backend, currentUser.ID, body.EventID, committeeParticipants)
+            if err != nil {
                 return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                     "success": false,
-                    "message": fmt.Sprintf("Failed to fetch current user participation, %v", res.Error),
+                    "message": fmt.Sprintf("Failed to fetch current user participation, %v", err),
                     "error_code": 3,
                     "data": nil,
                 })
             }
-
-            if currentEventParticipant.EventPRole != "committee" {
+            if !allowed {
                 return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
                     "success": false,
                     "message": "Only committee members and admins can edit participant roles.",
@@ -419,6 +433,15 @@ func appHandleEventParticipateEdit(backend *Backend, route fiber.Router) {
                     "data": nil,
                 })
             }
+            isLead, err = eventCommitteeCan(backend, currentUser.ID, body.EventID, committeeLead)
+            if err != nil {
+                return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                    "success": false,
+                    "message": fmt.Sprintf("Failed to fetch current user participation, %v", err),
+                    "error_code": 3,
+                    "data": nil,
+                })
+            }
 
             // Prevent committee members from editing their own role (security measure)
             if targetUserEmail == currentUserEmail {
@@ -471,19 +494,59 @@ func appHandleEventParticipateEdit(backend *Backend, route fiber.Router) {
             })
         }
 
-        // Update the role
-        eventParticipant.EventPRole = table.UserEventRoleEnum(body.EventPRole)
+        // NOTE: Making or removing a committee is the same as granting permission,
+        //       so only the lead of the event can do it beside the admin.
+        newRole := table.UserEventRoleEnum(body.EventPRole)
+        if newRole != eventParticipant.EventPRole {
+            if !admin && !isLead {
+                return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+                    "success": false,
+                    "message": "Only the committee lead and admins can change the committee of this event.",
+                    "error_code": 10,
+                    "data": nil,
+                })
+            }
+        }
 
-        res = backend.db.Save(&eventParticipant)
-        if res.Error != nil {
+        // NOTE: Committee dont take a seat, so the role change move the seat too.
+        //       Going back to normal is the same as register (the waitlist when
+        //       full), and a normal that become committee free the seat.
+        freedSeat := false
+        err = backend.db.Transaction(func(tx *gorm.DB) error {
+            if newRole != eventParticipant.EventPRole {
+                if newRole == table.NormalU {
+                    var event table.Event
+                    if err := tx.Where("id = ?", body.EventID).First(&event).Error; err != nil {
+                        return err
+                    }
+                    full, err := isEventFull(tx, &event)
+                    if err != nil {
+                        return err
+                    }
+                    eventParticipant.EventPWait = full
+                } else {
+                    freedSeat = !eventParticipant.EventPWait
+                    eventParticipant.EventPWait = false
+                }
+                setCommitteeRole(&eventParticipant, newRole)
+            }
+            return tx.Save(&eventParticipant).Error
+        })
+        if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
-                "message": fmt.Sprintf("Failed to update event participant, %v", res.Error),
+                "message": fmt.Sprintf("Failed to update event participant, %v", err),
                 "error_code": 6,
                 "data": nil,
             })
         }
 
+        if freedSeat {
+            if _, err := promoteWaitlist(backend, body.EventID); err != nil {
+                log.Printf("Failed to promote the waitlist of event %d: %v", body.EventID, err)
+            }
+        }
+
         return c.Status(fiber.StatusOK).JSON(fiber.Map{
             "success": true,
             "message": "Participant role updated successfully.",
@@ -492,6 +555,7 @@ func appHandleEventParticipateEdit(backend *Backend, route fiber.Router) {
                 "event_id": body.EventID,
                 "user_email": targetUserEmail,
                 "new_role": body.EventPRole,
+                "waitlist": eventParticipant.EventPWait,
             },
         })
     })
@@ -500,7 +564,7 @@ func appHandleEventParticipateEdit(backend *Backend, route fiber.Router) {
 // GET : api/protected/event-participate-committee-of-event
 func appHandleEventParticipateCommitteeOfEvent(backend *Backend, route fiber.Router) {
     route.Get("event-participate-committee-of-event", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        _, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -510,8 +574,8 @@ func appHandleEventParticipateCommitteeOfEvent(backend *Backend, route fiber.Rou
             })
         }
 
-        admin := claims["admin"].(float64)
-        if admin != 1 {
+        admin := userCan(backend, c, permParticipantRead)
+        if !admin {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid Credentials.",
@@ -566,7 +630,7 @@ func appHandleEventParticipateCommitteeOfEvent(backend *Backend, route fiber.Rou
 // GET : api/protected/event-participate-of-event
 func appHandleEventParticipateOfEvent(backend *Backend, route fiber.Router) {
     route.Get("event-participate-of-event", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        _, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -576,8 +640,8 @@ func appHandleEventParticipateOfEvent(backend *Backend, route fiber.Router) {
             })
         }
 
-        admin := claims["admin"].(float64)
-        if admin != 1 {
+        admin := userCan(backend, c, permParticipantRead)
+        if !admin {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid Credentials.",
@@ -628,11 +692,192 @@ func appHandleEventParticipateOfEvent(backend *Backend, route fiber.Router) {
     })
 }
 
+var evPartExportHeader = []string{"No", "Name", "Email", "Instance", "Role", "Attended", "Certificate Code"}
+
+// NOTE: The name and instance is typed by the user, a cell starting with one of
+//       these is run as a formula by Excel, the `'` make it plain text.
+func exportCell(value string) string {
+    if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+        return "'" + value
+    }
+    return value
+}
+
+// NOTE: Walk the participant of the event in batch so big event didnt need to be
+//       loaded all at once, `fn` is called once for every row.
+func evPartExportRows(backend *Backend, eventID int, fn func(row []string) error) error {
+    var batch []table.EventParticipant
+    no := 0
+    res := backend.db.Preload("User").Where("event_id = ?", eventID).Order("id ASC").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+        for _, evPart := range batch {
+            no++
+            attended := "no"
+            if evPart.EventPCome {
+                attended = "yes"
+            }
+            err := fn([]string{
+                strconv.Itoa(no),
+                exportCell(evPart.User.UserFullName),
+                exportCell(evPart.User.UserEmail),
+                exportCell(evPart.User.UserInstance),
+                string(evPart.EventPRole),
+                attended,
+                evPart.EventPCode,
+            })
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    return res.Error
+}
+
+// NOTE: format query is `csv` (default) or `xlsx`.
+// GET : api/protected/event-participate-export
+func appHandleEventParticipateExport(backend *Backend, route fiber.Router) {
+    route.Get("event-participate-export", func (c *fiber.Ctx) error {
+        currentUser, err := GetJWT(c)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid JWT Token.",
+                "error_code": 1,
+                "data": nil,
+            })
+        }
+
+        admin := userCan(backend, c, permParticipantRead)
+
+        queryEventID := c.Query("event_id")
+        queryEventIDInt, err := strconv.Atoi(queryEventID)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "event_id need to be integer.",
+                "error_code": 2,
+                "data": nil,
+            })
+        }
+
+        format := c.Query("format", "csv")
+        if format != "csv" && format != "xlsx" {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid format, the only valid strings are : `csv` and `xlsx`",
+                "error_code": 3,
+                "data": nil,
+            })
+        }
+
+        if !admin {
+            committee, err := eventCommitteeCan(backend, currentUser.ID, queryEventIDInt, committeeParticipants)
+            if err != nil {
+                return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                    "success": false,
+                    "message": fmt.Sprintf("Failed to fetch the event participant of this user, %v", err),
+                    "error_code": 4,
+                    "data": nil,
+                })
+            }
+            if !committee {
+                return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                    "success": false,
+                    "message": "Invalid credentials for this function",
+                    "error_code": 5,
+                    "data": nil,
+                })
+            }
+        }
+
+        var selectedEvent table.Event
+        res := backend.db.Where("id = ?", queryEventIDInt).First(&selectedEvent)
+        if res.Error != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": "The specified event ID didnt exist.",
+                "error_code": 6,
+                "data": nil,
+            })
+        }
+
+        filename := fmt.Sprintf("event-%d-participants.%s", selectedEvent.ID, format)
+        c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+        if format == "csv" {
+            c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+            c.Status(fiber.StatusOK).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+                writer := csv.NewWriter(w)
+                writer.Write(evPartExportHeader)
+                err := evPartExportRows(backend, selectedEvent.ID, func(row []string) error {
+                    if err := writer.Write(row); err != nil {
+                        return err
+                    }
+                    writer.Flush()
+                    return writer.Error()
+                })
+                if err != nil {
+                    log.Printf("Failed to export participant of event %d: %v", selectedEvent.ID, err)
+                }
+                writer.Flush()
+            })
+            return nil
+        }
+
+        xlsx := excelize.NewFile()
+        defer xlsx.Close()
+
+        sheet := xlsx.GetSheetName(0)
+        stream, err := xlsx.NewStreamWriter(sheet)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to create the xlsx file, %v", err),
+                "error_code": 7,
+                "data": nil,
+            })
+        }
+
+        rowNum := 1
+        writeRow := func(row []string) error {
+            cells := make([]interface{}, len(row))
+            for i, v := range row {
+                cells[i] = v
+            }
+            cell, err := excelize.CoordinatesToCellName(1, rowNum)
+            if err != nil {
+                return err
+            }
+            rowNum++
+            return stream.SetRow(cell, cells)
+        }
+
+        err = writeRow(evPartExportHeader)
+        if err == nil {
+            err = evPartExportRows(backend, selectedEvent.ID, writeRow)
+        }
+        if err == nil {
+            err = stream.Flush()
+        }
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to write the xlsx file, %v", err),
+                "error_code": 7,
+                "data": nil,
+            })
+        }
+
+        c.Set(fiber.HeaderContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+        return xlsx.Write(c.Status(fiber.StatusOK).Response().BodyWriter())
+    })
+}
+
 // NOTE: return then event that participated by the selected user.
 // GET : api/protected/event-participate-of-user
 func appHandleEventParticipateOfUser(backend *Backend, route fiber.Router) {
     route.Get("event-participate-of-user", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        currentUser, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -642,13 +887,13 @@ func appHandleEventParticipateOfUser(backend *Backend, route fiber.Router) {
             })
         }
 
-        admin := claims["admin"].(float64)
-        email := claims["email"].(string)
+        admin := userCan(backend, c, permParticipantRead)
+        email := currentUser.UserEmail
 
         userEmail := c.Query("email")
 
         useThisEmail := email
-        if admin == 1 && userEmail != "" {
+        if admin && userEmail != "" {
             useThisEmail = userEmail
         }
 
@@ -691,7 +936,7 @@ func appHandleEventParticipateOfUser(backend *Backend, route fiber.Router) {
 // GET : api/protected/event-participate-of-user-ws
 func appHandleEventParticipateOfUserWithSearch(backend *Backend, route fiber.Router) {
     route.Get("event-participate-of-user-ws", func(c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        currentUser, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -701,13 +946,13 @@ func appHandleEventParticipateOfUserWithSearch(backend *Backend, route fiber.Rou
             })
         }
 
-        admin := claims["admin"].(float64)
-        email := claims["email"].(string)
+        admin := userCan(backend, c, permParticipantRead)
+        email := currentUser.UserEmail
 
         userEmail := c.Query("email")
 
         useThisEmail := email
-        if admin == 1 && userEmail != "" {
+        if admin && userEmail != "" {
             useThisEmail = userEmail
         }
 
@@ -869,7 +1114,7 @@ func appHandleEventParticipateOfUserWithSearch(backend *Backend, route fiber.Rou
 // POST : api/protected/event-participate-absence-itself
 func appHandleEventParticipateAbsenceItself(backend *Backend, route fiber.Router) {
     route.Post("event-participate-absence-itself", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        currentUser, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -879,8 +1124,6 @@ func appHandleEventParticipateAbsenceItself(backend *Backend, route fiber.Router
             })
         }
 
-        email := claims["email"].(string)
-
         var body struct {
             EventID int `json:"event_id"`
         }
@@ -895,28 +1138,9 @@ func appHandleEventParticipateAbsenceItself(backend *Backend, route fiber.Router
             })
         }
 
-        var currentUser table.User
-        res := backend.db.Where("user_email = ?", email).First(&currentUser)
-        if res.Error != nil {
-            if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
-                return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-                    "success": false,
-                    "message": "Invalid user on JWT.",
-                    "error_code": 3,
-                    "data": nil,
-                })
-            }
-            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-                "success": false,
-                "message": fmt.Sprintf("Something wrong happened on the backend, %v", res.Error),
-                "error_code": 4,
-                "data": nil,
-            })
-        }
-
         // Check if event is online
         var event table.Event
-        res = backend.db.Where("id = ? AND event_att = ?", body.EventID, "online").First(&event)
+        res := backend.db.Where("id = ? AND event_att = ?", body.EventID, "online").First(&event)
         if res.Error != nil {
             if errors.Is(res.Error, gorm.ErrRecordNotFound) {
                 return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -934,7 +1158,7 @@ func appHandleEventParticipateAbsenceItself(backend *Backend, route fiber.Router
             })
         }
 
-        res = backend.db.Model(&table.EventParticipant{}).Where("event_id = ? AND eventp_role = ? AND user_id = ?", body.EventID, "normal", currentUser.ID).Update("eventp_come", true)
+        res = backend.db.Model(&table.EventParticipant{}).Where("event_id = ? AND eventp_role = ? AND user_id = ? AND eventp_wait = ?", body.EventID, "normal", currentUser.ID, false).Update("eventp_come", true)
 
         return c.Status(fiber.StatusOK).JSON(fiber.Map{
             "success": true,
@@ -948,7 +1172,7 @@ func appHandleEventParticipateAbsenceItself(backend *Backend, route fiber.Router
 // POST : api/protected/event-participate-absence-bulk
 func appHandleEventParticipateAbsenceBulk(backend *Backend, route fiber.Router) {
     route.Post("event-participate-absence-bulk", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        currentUser, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -958,8 +1182,7 @@ func appHandleEventParticipateAbsenceBulk(backend *Backend, route fiber.Router)
             })
         }
 
-        email := claims["email"].(string)
-        admin := claims["admin"].(float64)
+        admin := userCan(backend, c, permParticipantManage)
 
         var body struct {
             EventID int `json:"event_id"`
@@ -975,28 +1198,9 @@ func appHandleEventParticipateAbsenceBulk(backend *Backend, route fiber.Router)
             })
         }
 
-        var currentUser table.User
-        res := backend.db.Where("user_email = ?", email).First(&currentUser)
-        if res.Error != nil {
-            if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
-                return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-                    "success": false,
-                    "message": "Invalid user on JWT.",
-                    "error_code": 3,
-                    "data": nil,
-                })
-            }
-            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-                "success": false,
-                "message": fmt.Sprintf("Something wrong happened on the backend, %v", res.Error),
-                "error_code": 4,
-                "data": nil,
-            })
-        }
-
-        if admin != 1 {
+        if !admin {
             var eventPart table.EventParticipant
-            res = backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventID).First(&eventPart)
+            res := backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventID).First(&eventPart)
             if res.Error != nil {
                 if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
                     return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1013,7 +1217,7 @@ func appHandleEventParticipateAbsenceBulk(backend *Backend, route fiber.Router)
                     "data": nil,
                 })
             }
-            if eventPart.EventPRole != "committee" {
+            if !committeeHas(&eventPart, committeeCheckIn) {
                 return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                     "success": false,
                     "message": "Invalid credentials for this API.",
@@ -1023,7 +1227,7 @@ func appHandleEventParticipateAbsenceBulk(backend *Backend, route fiber.Router)
             }
         }
 
-        res = backend.db.Model(&table.EventParticipant{}).Where("event_id = ? AND eventp_role = ?", body.EventID, "normal").Update("eventp_come", true)
+        res := backend.db.Model(&table.EventParticipant{}).Where("event_id = ? AND eventp_role = ? AND eventp_wait = ?", body.EventID, "normal", false).Update("eventp_come", true)
         if res.Error != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -1045,7 +1249,7 @@ func appHandleEventParticipateAbsenceBulk(backend *Backend, route fiber.Router)
 // POST : api/protected/event-participate-absence
 func appHandleEventParticipateAbsence(backend *Backend, route fiber.Router) {
     route.Post("event-participate-absence", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        currentUser, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -1054,8 +1258,7 @@ func appHandleEventParticipateAbsence(backend *Backend, route fiber.Router) {
                 "data": nil,
             })
         }
-        email := claims["email"].(string)
-        admin := claims["admin"].(float64)
+        admin := userCan(backend, c, permParticipantManage)
 
         var body struct  {
             EventId   int    `json:"id"`
@@ -1072,19 +1275,8 @@ func appHandleEventParticipateAbsence(backend *Backend, route fiber.Router) {
             })
         }
 
-        var userSender table.User
-        res := backend.db.Where("user_email = ?", email).First(&userSender)
-        if res.Error != nil {
-            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-                "success": false,
-                "message": fmt.Sprintf("Failed to fetch user from the db, %v", res.Error),
-                "error_code": 2,
-                "data": nil,
-            })
-        }
-
         var userEventPart table.EventParticipant
-        res = backend.db.Where("user_id = ? AND event_id = ?", userSender.ID, body.EventId).First(&userEventPart)
+        res := backend.db.Where("user_id = ? AND event_id = ?", currentUser.ID, body.EventId).First(&userEventPart)
         if res.Error != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -1095,17 +1287,17 @@ func appHandleEventParticipateAbsence(backend *Backend, route fiber.Router) {
         }
 
         // Check if the requestee is a committee
-        if userEventPart.EventPRole != "committee" && admin != 1 {
+        if !committeeHas(&userEventPart, committeeCheckIn) && !admin {
             return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
                 "success": false,
-                "message": fmt.Sprintf("Invalid credentials for this function. DEBUG: %s, %f", userEventPart.EventPRole, admin),
+                "message": fmt.Sprintf("Invalid credentials for this function. DEBUG: %s, %t", userEventPart.EventPRole, admin),
                 "error_code": 4,
                 "data": nil,
             })
         }
 
         var absenTarget table.EventParticipant
-        res = backend.db.Where("eventp_code = ?", body.Secret).First(&absenTarget)
+        res = backend.db.Where("eventp_code = ? AND event_id = ?", body.Secret, body.EventId).First(&absenTarget)
         if res.Error != nil {
             if errors.Is(res.Error, gorm.ErrRecordNotFound) {
                 return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1123,6 +1315,15 @@ func appHandleEventParticipateAbsence(backend *Backend, route fiber.Router) {
             })
         }
 
+        if absenTarget.EventPWait {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "This participant is still on the waitlist.",
+                "error_code": 8,
+                "data": nil,
+            })
+        }
+
         absenTarget.EventPCome = true
         res = backend.db.Save(&absenTarget)
         if res.Error != nil {
@@ -1143,10 +1344,241 @@ func appHandleEventParticipateAbsence(backend *Backend, route fiber.Router) {
     })
 }
 
+// NOTE: Return the QR image of the participant code, `format` query is `png` (default) or `svg`.
+//       Admin and the committee of the event can get the QR of other user with `email`.
+// GET : api/protected/event-participate-qr
+func appHandleEventParticipateQR(backend *Backend, route fiber.Router) {
+    route.Get("event-participate-qr", func (c *fiber.Ctx) error {
+        currentUser, err := GetJWT(c)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid JWT Token.",
+                "error_code": 1,
+                "data": nil,
+            })
+        }
+        admin := userCan(backend, c, permParticipantManage)
+        email := currentUser.UserEmail
+
+        queryEventID := c.Query("event_id")
+        queryEventIDInt, err := strconv.Atoi(queryEventID)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "event_id need to be integer.",
+                "error_code": 2,
+                "data": nil,
+            })
+        }
+
+        format := c.Query("format", "png")
+        if format != "png" && format != "svg" {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid format, the only valid strings are : `png` and `svg`",
+                "error_code": 3,
+                "data": nil,
+            })
+        }
+
+        useThisEmail := email
+        emailQuery := c.Query("email")
+        if emailQuery != "" && emailQuery != email {
+            if !admin {
+                committee, err := eventCommitteeCan(backend, currentUser.ID, queryEventIDInt, committeeCheckIn)
+                if err != nil || !committee {
+                    return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                        "success": false,
+                        "message": "Invalid credentials for this function",
+                        "error_code": 4,
+                        "data": nil,
+                    })
+                }
+            }
+            useThisEmail = emailQuery
+        }
+
+        var selectedUser table.User
+        res := backend.db.Where("user_email = ?", useThisEmail).First(&selectedUser)
+        if res.Error != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to fetch the specified user from db, %v", res.Error),
+                "error_code": 5,
+                "data": nil,
+            })
+        }
+
+        var evPart table.EventParticipant
+        res = backend.db.Where("user_id = ? AND event_id = ?", selectedUser.ID, queryEventIDInt).First(&evPart)
+        if res.Error != nil {
+            if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+                return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+                    "success": false,
+                    "message": "This user is not registered on that event.",
+                    "error_code": 6,
+                    "data": nil,
+                })
+            }
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to fetch event participant from the db, %v", res.Error),
+                "error_code": 7,
+                "data": nil,
+            })
+        }
+
+        var image []byte
+        if format == "svg" {
+            image, err = qrCodeSVG(evPart.EventPCode)
+            c.Set(fiber.HeaderContentType, "image/svg+xml")
+        } else {
+            image, err = qrCodePNG(evPart.EventPCode)
+            c.Set(fiber.HeaderContentType, "image/png")
+        }
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to generate the QR code, %v", err),
+                "error_code": 8,
+                "data": nil,
+            })
+        }
+
+        return c.Status(fiber.StatusOK).Send(image)
+    })
+}
+
+// NOTE: `code` is the scanned QR payload (the EventPCode), it only count for the event on `id`.
+//       The name and picture is returned so the committee can confirm who it is.
+// POST : api/protected/event-participate-checkin
+func appHandleEventParticipateCheckIn(backend *Backend, route fiber.Router) {
+    route.Post("event-participate-checkin", func (c *fiber.Ctx) error {
+        currentUser, err := GetJWT(c)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid JWT Token.",
+                "error_code": 1,
+                "data": nil,
+            })
+        }
+        admin := userCan(backend, c, permParticipantManage)
+
+        var body struct {
+            EventId int    `json:"id"`
+            Code    string `json:"code"`
+        }
+
+        err = c.BodyParser(&body)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Invalid body request, %v", err),
+                "error_code": 2,
+                "data": nil,
+            })
+        }
+
+        if body.Code == "" {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Empty code is not allowed.",
+                "error_code": 3,
+                "data": nil,
+            })
+        }
+
+        if !admin {
+            committee, err := eventCommitteeCan(backend, currentUser.ID, body.EventId, committeeCheckIn)
+            if err != nil {
+                return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                    "success": false,
+                    "message": fmt.Sprintf("Failed to fetch the event participant of this user, %v", err),
+                    "error_code": 4,
+                    "data": nil,
+                })
+            }
+            if !committee {
+                return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                    "success": false,
+                    "message": "Invalid credentials for this function",
+                    "error_code": 5,
+                    "data": nil,
+                })
+            }
+        }
+
+        var target table.EventParticipant
+        res := backend.db.Preload("User").Where("eventp_code = ?", body.Code).First(&target)
+        if res.Error != nil {
+            if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+                return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                    "success": false,
+                    "message": "Invalid secret code.",
+                    "error_code": 6,
+                    "data": nil,
+                })
+            }
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": "Failed to fetch event participant with that secret.",
+                "error_code": 7,
+                "data": nil,
+            })
+        }
+
+        if target.EventId != body.EventId {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "This code belong to another event.",
+                "error_code": 8,
+                "data": nil,
+            })
+        }
+
+        if target.EventPWait {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "This participant is still on the waitlist.",
+                "error_code": 10,
+                "data": nil,
+            })
+        }
+
+        alreadyIn := target.EventPCome
+        if !alreadyIn {
+            res = backend.db.Model(&target).Update("eventp_come", true)
+            if res.Error != nil {
+                return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                    "success": false,
+                    "message": "Failed to save event participant.",
+                    "error_code": 9,
+                    "data": nil,
+                })
+            }
+        }
+
+        return c.Status(fiber.StatusOK).JSON(fiber.Map{
+            "success": true,
+            "message": "User checked in.",
+            "error_code": 0,
+            "data": fiber.Map{
+                "name": target.User.UserFullName,
+                "email": target.User.UserEmail,
+                "picture": target.User.UserPicture,
+                "role": target.EventPRole,
+                "already_checked_in": alreadyIn,
+            },
+        })
+    })
+}
+
 // GET : api/protected/event-participate-of-event-count
 func appHandleEventParticipateOfEventCount(backend *Backend, route fiber.Router) {
     route.Get("event-participate-of-event-count", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        _, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -1155,9 +1587,9 @@ func appHandleEventParticipateOfEventCount(backend *Backend, route fiber.Router)
                 "data": nil,
             })
         }
-        admin := claims["admin"].(float64)
+        admin := userCan(backend, c, permParticipantRead)
 
-        if admin != 1 {
+        if !admin {
             return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid credentials to acces this api.",
@@ -1195,3 +1627,135 @@ func appHandleEventParticipateOfEventCount(backend *Backend, route fiber.Router)
         })
     })
 }
+
+// NOTE: Every flag is optional, only the one sent is changed. Only the admin can set `lead`
+//       and the lead cannot change their own flag.
+// POST : api/protected/event-participate-committee-perm
+func appHandleEventParticipateCommitteePerm(backend *Backend, route fiber.Router) {
+    route.Post("event-participate-committee-perm", func (c *fiber.Ctx) error {
+        currentUser, err := GetJWT(c)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid JWT Token.",
+                "error_code": 1,
+                "data": nil,
+            })
+        }
+
+        admin := userCan(backend, c, permParticipantManage)
+        currentUserEmail := currentUser.UserEmail
+
+        var body struct {
+            EventID      int    `json:"event_id"`
+            UserEmail    string `json:"email"`
+            CheckIn      *bool  `json:"checkin"`
+            EditEvent    *bool  `json:"edit_event"`
+            Cert         *bool  `json:"cert"`
+            Participants *bool  `json:"participants"`
+            Lead         *bool  `json:"lead"`
+        }
+
+        err = c.BodyParser(&body)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Invalid body request, %v", err),
+                "error_code": 2,
+                "data": nil,
+            })
+        }
+
+        if !admin {
+            isLead, err := eventCommitteeCan(backend, currentUser.ID, body.EventID, committeeLead)
+            if err != nil || !isLead {
+                return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+                    "success": false,
+                    "message": "Only the committee lead and admins can change committee permission.",
+                    "error_code": 3,
+                    "data": nil,
+                })
+            }
+            if body.Lead != nil || body.UserEmail == currentUserEmail {
+                return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+                    "success": false,
+                    "message": "Committee lead cannot change the lead or their own permission.",
+                    "error_code": 4,
+                    "data": nil,
+                })
+            }
+        }
+
+        var targetUser table.User
+        res := backend.db.Where("user_email = ?", body.UserEmail).First(&targetUser)
+        if res.Error != nil {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to fetch the target user, %v", res.Error),
+                "error_code": 5,
+                "data": nil,
+            })
+        }
+
+        var evPart table.EventParticipant
+        res = backend.db.Where("event_id = ? AND user_id = ?", body.EventID, targetUser.ID).First(&evPart)
+        if res.Error != nil {
+            return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to fetch the event participant, %v", res.Error),
+                "error_code": 6,
+                "data": nil,
+            })
+        }
+
+        if evPart.EventPRole != table.CommitteeU {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "The permission can only be set on a committee.",
+                "error_code": 7,
+                "data": nil,
+            })
+        }
+
+        if body.CheckIn != nil {
+            evPart.EventPPermCheckIn = *body.CheckIn
+        }
+        if body.EditEvent != nil {
+            evPart.EventPPermEditEvent = *body.EditEvent
+        }
+        if body.Cert != nil {
+            evPart.EventPPermCert = *body.Cert
+        }
+        if body.Participants != nil {
+            evPart.EventPPermParticipants = *body.Participants
+        }
+        if body.Lead != nil {
+            evPart.EventPLead = *body.Lead
+        }
+
+        res = backend.db.Save(&evPart)
+        if res.Error != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to update the event participant, %v", res.Error),
+                "error_code": 8,
+                "data": nil,
+            })
+        }
+
+        return c.Status(fiber.StatusOK).JSON(fiber.Map{
+            "success": true,
+            "message": "Committee permission updated.",
+            "error_code": 0,
+            "data": fiber.Map{
+                "event_id": body.EventID,
+                "email": body.UserEmail,
+                "lead": evPart.EventPLead,
+                "checkin": evPart.EventPPermCheckIn,
+                "edit_event": evPart.EventPPermEditEvent,
+                "cert": evPart.EventPPermCert,
+                "participants": evPart.EventPPermParticipants,
+            },
+        })
+    })
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NOTE: Every job with its schedule, the next run and how the last run went.
+//       `running` is true while a run hold the lock.
+// GET : api/protected/job-list
+func appHandleJobList(backend *Backend, route fiber.Router) {
+	route.Get("job-list", requirePermission(backend, permJobManage), func(c *fiber.Ctx) error {
+		var rows []table.ScheduledJob
+		res := backend.db.Find(&rows)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the job, %v", res.Error),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+		byName := make(map[string]*table.ScheduledJob, len(rows))
+		for i := range rows {
+			byName[rows[i].JobName] = &rows[i]
+		}
+
+		jobs := make([]fiber.Map, 0, len(backend.scheduler.jobs))
+		for _, job := range backend.scheduler.jobs {
+			entry := fiber.Map{
+				"name":        job.name,
+				"description": job.desc,
+				"schedule":    job.spec,
+				"running":     false,
+				"last_run":    nil,
+				"last_status": "",
+				"last_error":  "",
+				"next_run":    nil,
+			}
+			if row, ok := byName[job.name]; ok {
+				entry["running"] = row.JobLockedUntil != nil && row.JobLockedUntil.After(time.Now())
+				entry["last_run"] = row.JobLastRun
+				entry["last_status"] = row.JobLastStatus
+				entry["last_error"] = row.JobLastError
+				entry["next_run"] = row.JobNextRun
+			}
+			jobs = append(jobs, entry)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data":       jobs,
+		})
+	})
+}
+
+// NOTE: Start the job now, the answer didnt wait for it to finish. Check the
+//       run with api/protected/job-history.
+// POST : api/protected/job-run
+func appHandleJobRun(backend *Backend, route fiber.Router) {
+	route.Post("job-run", requirePermission(backend, permJobManage), func(c *fiber.Ctx) error {
+		var body struct {
+			Name string `json:"name"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the name of the job.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		runID, err := backend.scheduler.trigger(body.Name)
+		if errors.Is(err, errJobUnknown) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    "There is no job with that name.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+		if errors.Is(err, errJobLocked) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"success":    false,
+				"message":    "The job is already running.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to start the job, %v", err),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "The job is started.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"run_id": runID,
+			},
+		})
+	})
+}
+
+// NOTE: The run of every job, or only `?name=`. `status` is `running`, `success`
+//       or `failed`, empty for all. Newest first.
+// GET : api/protected/job-history
+func appHandleJobHistory(backend *Backend, route fiber.Router) {
+	route.Get("job-history", requirePermission(backend, permJobManage), func(c *fiber.Ctx) error {
+		offset, err := strconv.Atoi(c.Query("offset", "0"))
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		limit, err := strconv.Atoi(c.Query("limit", "50"))
+		if err != nil || limit <= 0 || limit > 200 {
+			limit = 50
+		}
+
+		query := backend.db.Model(&table.JobRun{})
+		if name := c.Query("name"); name != "" {
+			if backend.scheduler.find(name) == nil {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"success":    false,
+					"message":    "There is no job with that name.",
+					"error_code": 1,
+					"data":       nil,
+				})
+			}
+			query = query.Where("job_name = ?", name)
+		}
+		status := table.JobStatus(c.Query("status"))
+		switch status {
+		case "":
+		case table.JobRunning, table.JobSuccess, table.JobFailed:
+			query = query.Where("job_status = ?", status)
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid status, the only valid strings are : `running`, `success` and `failed`",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		var total int64
+		var runs []table.JobRun
+		if err = query.Count(&total).Error; err == nil {
+			err = query.Order("id DESC").Offset(offset).Limit(limit).Find(&runs).Error
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the job history, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		entries := make([]fiber.Map, 0, len(runs))
+		for _, run := range runs {
+			entries = append(entries, fiber.Map{
+				"id":          run.ID,
+				"name":        run.JobName,
+				"trigger":     run.JobTrigger,
+				"status":      run.JobStatus,
+				"error":       run.JobError,
+				"started_at":  run.JobStarted,
+				"finished_at": run.JobFinished,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"total":   total,
+				"entries": entries,
+			},
+		})
+	})
+}
@@ -11,7 +11,7 @@ import (
 // POST : api/protected/material-register
 func appHandleMaterialNew(backend *Backend, route fiber.Router) {
     route.Post("material-register", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        _, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -21,9 +21,9 @@ func appHandleMaterialNew(backend *Backend, route fiber.Router) {
             })
         }
 
-        isAdmin := claims["admin"].(float64)
+        isAdmin := userCan(backend, c, permEventEdit)
 
-        if isAdmin != 1 {
+        if !isAdmin {
             return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid credentials for this function",
@@ -84,7 +84,7 @@ func appHandleMaterialNew(backend *Backend, route fiber.Router) {
 // GET : api/protected/material-info-of
 func appHandleMaterialInfoOf(backend *Backend, route fiber.Router) {
     route.Get("material-info-of", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        _, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -94,17 +94,6 @@ func appHandleMaterialInfoOf(backend *Backend, route fiber.Router) {
             })
         }
 
-        email := claims["email"].(string)
-
-        if email == "" {
-            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-                "success": false,
-                "message": "Invalid email on JWT.",
-                "error_code": 2,
-                "data": nil,
-            })
-        }
-
         infoOf := c.Query("event_id")
         var infoOfInt int
 
@@ -150,7 +139,7 @@ func appHandleMaterialInfoOf(backend *Backend, route fiber.Router) {
 // POST : api/protected/material-del
 func appHandleMaterialDel(backend *Backend, route fiber.Router) {
     route.Post("material-del", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        _, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -160,8 +149,8 @@ func appHandleMaterialDel(backend *Backend, route fiber.Router) {
             })
         }
 
-        isAdmin := claims["admin"].(float64)
-        if isAdmin != 1 {
+        isAdmin := userCan(backend, c, permEventEdit)
+        if !isAdmin {
             return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid credentials for this function",
@@ -206,7 +195,7 @@ func appHandleMaterialDel(backend *Backend, route fiber.Router) {
 // POST : api/protected/material-edit
 func appHandleMaterialEdit(backend *Backend, route fiber.Router) {
     route.Post("material-edit", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        _, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -216,8 +205,8 @@ func appHandleMaterialEdit(backend *Backend, route fiber.Router) {
             })
         }
 
-        isAdmin := claims["admin"].(float64)
-        if isAdmin != 1 {
+        isAdmin := userCan(backend, c, permEventEdit)
+        if !isAdmin {
             return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid credentials for this function",
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NOTE: Redirect to the identity provider, the frontend follow the `Location`.
+//       The binding cookie have to come back on the callback, the frontend
+//       keep it for the browser when it call this from the server.
+// GET : api/oidc/login
+func appHandleOidcLogin(backend *Backend, route fiber.Router) {
+	route.Get("oidc/login", func(c *fiber.Ctx) error {
+		if backend.oidc == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Single sign-on is not configured.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		url, binding, err := backend.oidc.begin()
+		if errors.Is(err, errOidcBusy) {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Too many single sign-on in progress, please try again later.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+		if err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to start the single sign-on, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		c.Cookie(&fiber.Cookie{
+			Name:     oidcBindingCookie,
+			Value:    binding,
+			Path:     "/api/oidc",
+			HTTPOnly: true,
+			Secure:   false,
+			SameSite: "Lax",
+			Expires:  time.Now().Add(oidcStateTTL),
+		})
+
+		return c.Redirect(url, fiber.StatusFound)
+	})
+}
+
+// NOTE: The identity provider redirect here (or to the frontend that forward the
+//       query and the binding cookie here) with `code` and `state`. The response
+//       is the same as api/login.
+// GET : api/oidc/callback
+func appHandleOidcCallback(backend *Backend, route fiber.Router) {
+	route.Get("oidc/callback", func(c *fiber.Ctx) error {
+		if backend.oidc == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Single sign-on is not configured.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		if idpErr := c.Query("error"); idpErr != "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("The identity provider refused the login, %s %s", idpErr, c.Query("error_description")),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		code := c.Query("code")
+		state := c.Query("state")
+		if code == "" || state == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "`code` and `state` is required.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		binding := c.Cookies(oidcBindingCookie)
+		c.Cookie(&fiber.Cookie{
+			Name:     oidcBindingCookie,
+			Value:    "deleted",
+			Path:     "/api/oidc",
+			HTTPOnly: true,
+			Secure:   false,
+			SameSite: "Lax",
+			Expires:  time.Now().Add(-3 * time.Hour),
+		})
+
+		identity, err := backend.oidc.finish(c.Context(), state, binding, code)
+		if err != nil {
+			if errors.Is(err, errOidcState) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "The login is expired, already used or started from another browser, please try again.",
+					"error_code": 4,
+					"data":       nil,
+				})
+			}
+			if errors.Is(err, errOidcEmail) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    err.Error(),
+					"error_code": 6,
+					"data":       nil,
+				})
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to finish the single sign-on, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		user, err := oidcFindOrCreateUser(backend, identity)
+		if err != nil {
+			if errors.Is(err, errOidcLinkedOther) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"success":    false,
+					"message":    err.Error(),
+					"error_code": 8,
+					"data":       nil,
+				})
+			}
+			if errors.Is(err, errOidcEmail) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    err.Error(),
+					"error_code": 6,
+					"data":       nil,
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to find or create the user, %v", err),
+				"error_code": 7,
+				"data":       nil,
+			})
+		}
+
+		return beginLogin(backend, c, user, 9)
+	})
+}
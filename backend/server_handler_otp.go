@@ -5,12 +5,46 @@ import (
     "errors"
 	"webrpl/table"
     "regexp"
+    "time"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
-// NOTE: Gen otp for the inserted email
+// NOTE: The challenge for api/gen-otp-for-register, see verifyOTPChallenge for
+//       the work the browser need to do.
+// GET : api/otp-challenge
+func appHandleOTPChallenge(backend *Backend, route fiber.Router) {
+    route.Get("otp-challenge", func (c *fiber.Ctx) error {
+        challenge, expires, err := newOTPChallenge(backend)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to make the challenge, %v", err),
+                "error_code": 1,
+                "data": nil,
+            })
+        }
+
+        return c.Status(fiber.StatusOK).JSON(fiber.Map{
+            "success": true,
+            "message": "Solve the challenge.",
+            "error_code": 0,
+            "data": fiber.Map{
+                "challenge": challenge,
+                "difficulty": otpPowDifficulty,
+                "expires_at": expires,
+            },
+        })
+    })
+}
+
+// NOTE: Gen otp for the inserted email. `purpose` is `register` (default) or
+//       `reset` for api/user-reset-pass. The answer is the same when the email
+//       cant use the purpose (already registered / not registered) but nothing
+//       is sent, so the email cant be checked from here.
+//       `challenge` and `nonce` is the solved api/otp-challenge, every challenge
+//       work once. There is a cooldown and a daily cap per email and per ip.
 // GET : api/gen-otp-for-register
 func appHandleGenOTP(backend *Backend, route fiber.Router) {
     route.Get("gen-otp-for-register", func (c *fiber.Ctx) error {
@@ -19,6 +53,7 @@ func appHandleGenOTP(backend *Backend, route fiber.Router) {
         re := regexp.MustCompile(emailRegex)
 
         if email == "" || !re.MatchString(email) {
+            backend.otpStats.reject(otpRejectInput)
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid email.",
@@ -27,7 +62,53 @@ func appHandleGenOTP(backend *Backend, route fiber.Router) {
             })
         }
 
+        purpose := table.OTPPurpose(c.Query("purpose", string(table.OTPRegister)))
+        if purpose != table.OTPRegister && purpose != table.OTPReset {
+            backend.otpStats.reject(otpRejectInput)
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid purpose, the only valid strings are : `register` and `reset`",
+                "error_code": 6,
+                "data": nil,
+            })
+        }
+
+        challenge := c.Query("challenge")
+        if err := verifyOTPChallenge(backend, challenge, c.Query("nonce")); err != nil {
+            backend.otpStats.reject(otpRejectChallenge)
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid or expired challenge, get a new one from api/otp-challenge.",
+                "error_code": 7,
+                "data": nil,
+            })
+        }
+
+        reason, wait, err := otpRequestAllow(backend, email, throttleClientIP(c), challenge)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to check the OTP limit, %v", err),
+                "error_code": 2,
+                "data": nil,
+            })
+        }
+        if reason == otpRejectChallenge {
+            backend.otpStats.reject(reason)
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid or expired challenge, get a new one from api/otp-challenge.",
+                "error_code": 7,
+                "data": nil,
+            })
+        }
+        if reason != "" {
+            backend.otpStats.reject(reason)
+            return otpLimited(c, reason, wait, 8)
+        }
+
         // Check if the user with that email exist
+        exist := true
         sqlError := backend.db.Where("user_email = ?", email).First(&table.User{}).Error
         if sqlError != nil {
             if !errors.Is(sqlError, gorm.ErrRecordNotFound) {
@@ -38,9 +119,21 @@ func appHandleGenOTP(backend *Backend, route fiber.Router) {
                     "data": nil,
                 })
             }
+            exist = false
         }
 
-        newOTP, err := createOTPCode(backend, 4, email)
+        sent := fiber.Map{
+            "success": true,
+            "message": "Generated the OTP please check the email.",
+            "error_code": 0,
+            "data": nil,
+        }
+        if exist == (purpose == table.OTPRegister) {
+            backend.otpStats.accept(false)
+            return c.Status(fiber.StatusOK).JSON(sent)
+        }
+
+        code, err := createOTP(backend, backend.db, purpose, email, 4, 0)
         if err != nil {
             return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
@@ -50,29 +143,38 @@ func appHandleGenOTP(backend *Backend, route fiber.Router) {
             })
         }
 
-        fmt.Printf(" -###- The Generated OTP code are : %s -###-\n", newOTP.OtpCode)
-        if !sendEmailTo(backend, newOTP.UserEmail, "OTP code for webrpl", fmt.Sprintf("Your OTP code are : %s\n(Working for 5 mins)", newOTP.OtpCode)) {
-            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+        mail, err := buildEmail(backend, email, "OTP code for webrpl", "otp", map[string]any{
+            "Code": code,
+            "Minutes": int(otpExpiryDuration.Minutes()),
+        }, fmt.Sprintf("Your OTP code are : %s\n(Working for %d mins)", code, int(otpExpiryDuration.Minutes())))
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
                 "success": false,
-                "message": "Failed to send email.",
+                "message": fmt.Sprintf("Failed to create the email, %v", err),
                 "error_code": 4,
                 "data": nil,
             })
         }
+        err = queueEmail(backend, backend.db, mail)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to queue the email, %v", err),
+                "error_code": 5,
+                "data": nil,
+            })
+        }
 
-        return c.Status(fiber.StatusOK).JSON(fiber.Map{
-            "success": true,
-            "message": "Generated the OTP please check console or email.",
-            "error_code": 0,
-            "data": nil,
-        })
+        backend.otpStats.accept(true)
+        return c.Status(fiber.StatusOK).JSON(sent)
     })
 }
 
+// NOTE: The otp-cleanup job already do this every 5 minutes.
 // POST : api/protected/cleanup-otp-code
 func appHandleCleanupOTP(backend *Backend, route fiber.Router) {
     route.Post("cleanup-otp-code", func (c *fiber.Ctx) error {
-        claims, err := GetJWT(c)
+        _, err := GetJWT(c)
         if err != nil {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
@@ -82,8 +184,8 @@ func appHandleCleanupOTP(backend *Backend, route fiber.Router) {
             })
         }
 
-        admin := claims["admin"].(float64)
-        if admin != 1 {
+        admin := userCan(backend, c, permOTPManage)
+        if !admin {
             return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
                 "success": false,
                 "message": "Invalid Credentials.",
@@ -92,7 +194,14 @@ func appHandleCleanupOTP(backend *Backend, route fiber.Router) {
             })
         }
 
-        CleanupOTPTable(backend)
+        if err := CleanupOTPTable(backend); err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to cleanup the OTP, %v", err),
+                "error_code": 3,
+                "data": nil,
+            })
+        }
         return c.Status(fiber.StatusOK).JSON(fiber.Map{
             "success": true,
             "message": "Unused OTP code cleaned up.",
@@ -101,3 +210,30 @@ func appHandleCleanupOTP(backend *Backend, route fiber.Router) {
         })
     })
 }
+
+// NOTE: How many api/gen-otp-for-register call is answered and rejected (by
+//       reason) since the server started, and how many is saved in the last day.
+// GET : api/protected/otp-metrics
+func appHandleOTPMetrics(backend *Backend, route fiber.Router) {
+    route.Get("otp-metrics", requirePermission(backend, permOTPManage), func (c *fiber.Ctx) error {
+        var lastDay int64
+        res := backend.db.Model(&table.OTPRequest{}).Where("created_at > ?", time.Now().Add(-otpRequestWindow)).Count(&lastDay)
+        if res.Error != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to count the OTP request, %v", res.Error),
+                "error_code": 1,
+                "data": nil,
+            })
+        }
+
+        data := backend.otpStats.snapshot()
+        data["accepted_last_day"] = lastDay
+        return c.Status(fiber.StatusOK).JSON(fiber.Map{
+            "success": true,
+            "message": "Check data.",
+            "error_code": 0,
+            "data": data,
+        })
+    })
+}
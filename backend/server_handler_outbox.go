@@ -0,0 +1,182 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "time"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+)
+
+// NOTE: Admin only. `status` can be `pending`, `sent` or `dead`, empty mean all.
+// GET : api/protected/email-outbox
+func appHandleEmailOutbox(backend *Backend, route fiber.Router) {
+    route.Get("email-outbox", func (c *fiber.Ctx) error {
+        _, err := GetJWT(c)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid JWT token.",
+                "error_code": 1,
+                "data": nil,
+            })
+        }
+
+        admin := userCan(backend, c, permEmailRead)
+        if !admin {
+            return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid credentials for this function",
+                "error_code": 2,
+                "data": nil,
+            })
+        }
+
+        offset, err := strconv.Atoi(c.Query("offset", "0"))
+        if err != nil || offset < 0 {
+            offset = 0
+        }
+        limit, err := strconv.Atoi(c.Query("limit", "50"))
+        if err != nil || limit <= 0 {
+            limit = 50
+        }
+
+        query := backend.db.Model(&table.EmailOutbox{})
+        status := table.OutboxStatusEnum(c.Query("status"))
+        switch status {
+        case "":
+        case table.OutboxPending, table.OutboxSent, table.OutboxDead:
+            query = query.Where("status = ?", status)
+        default:
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid status, the only valid strings are : `pending`, `sent` and `dead`",
+                "error_code": 3,
+                "data": nil,
+            })
+        }
+
+        var total int64
+        var entries []table.EmailOutbox
+        if err := query.Count(&total).Error; err == nil {
+            err = query.Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error
+        }
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to fetch the email outbox, %v", err),
+                "error_code": 4,
+                "data": nil,
+            })
+        }
+
+        // The body can hold an otp code so dont send it back.
+        result := make([]fiber.Map, 0, len(entries))
+        for _, entry := range entries {
+            result = append(result, fiber.Map{
+                "id": entry.ID,
+                "to": entry.MailTo,
+                "subject": entry.MailSubject,
+                "status": entry.Status,
+                "attempts": entry.Attempts,
+                "next_attempt": entry.NextAttempt,
+                "last_error": entry.LastError,
+                "sent_at": entry.SentAt,
+                "created_at": entry.CreatedAt,
+            })
+        }
+
+        return c.Status(fiber.StatusOK).JSON(fiber.Map{
+            "success": true,
+            "message": "Check data.",
+            "error_code": 0,
+            "data": fiber.Map{
+                "total": total,
+                "entries": result,
+            },
+        })
+    })
+}
+
+// NOTE: Admin only. Put the mail back to pending with the attempt reset, only
+//       the one that is not sent yet can be resent.
+// POST : api/protected/email-outbox-resend
+func appHandleEmailOutboxResend(backend *Backend, route fiber.Router) {
+    route.Post("email-outbox-resend", func (c *fiber.Ctx) error {
+        _, err := GetJWT(c)
+        if err != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid JWT token.",
+                "error_code": 1,
+                "data": nil,
+            })
+        }
+
+        admin := userCan(backend, c, permEmailManage)
+        if !admin {
+            return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                "success": false,
+                "message": "Invalid credentials for this function",
+                "error_code": 2,
+                "data": nil,
+            })
+        }
+
+        var body struct {
+            IDs     []int `json:"ids"`
+            AllDead bool  `json:"all_dead"`
+        }
+
+        err = c.BodyParser(&body)
+        if err != nil {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Invalid body request, %v", err),
+                "error_code": 3,
+                "data": nil,
+            })
+        }
+
+        if len(body.IDs) == 0 && !body.AllDead {
+            return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+                "success": false,
+                "message": "Specify the `ids` or set `all_dead` to true.",
+                "error_code": 4,
+                "data": nil,
+            })
+        }
+
+        query := backend.db.Model(&table.EmailOutbox{}).Where("status <> ?", table.OutboxSent)
+        if body.AllDead {
+            query = query.Where("status = ?", table.OutboxDead)
+        } else {
+            query = query.Where("id IN ?", body.IDs)
+        }
+
+        res := query.Updates(map[string]any{
+            "status": table.OutboxPending,
+            "attempts": 0,
+            "next_attempt": time.Now(),
+        })
+        if res.Error != nil {
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success": false,
+                "message": fmt.Sprintf("Failed to update the email outbox, %v", res.Error),
+                "error_code": 5,
+                "data": nil,
+            })
+        }
+        wakeOutbox(backend)
+
+        return c.Status(fiber.StatusOK).JSON(fiber.Map{
+            "success": true,
+            "message": fmt.Sprintf("Queued %d email to be resent.", res.RowsAffected),
+            "error_code": 0,
+            "data": fiber.Map{
+                "queued": res.RowsAffected,
+            },
+        })
+    })
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func rolePermissionsByName(db *gorm.DB, names []string) ([]table.Permission, error) {
+	var perms []table.Permission
+	if len(names) == 0 {
+		return perms, nil
+	}
+	res := db.Where("perm_name IN ?", names).Find(&perms)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if len(perms) != len(names) {
+		return nil, errors.New("there is an unknown permission on the list")
+	}
+	return perms, nil
+}
+
+// GET : api/protected/role-list
+func appHandleRoleList(backend *Backend, route fiber.Router) {
+	route.Get("role-list", requirePermission(backend, permRoleManage), func(c *fiber.Ctx) error {
+		var roles []table.Role
+		res := backend.db.Preload("Permissions").Order("id ASC").Find(&roles)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the role from the db, %v", res.Error),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var perms []table.Permission
+		res = backend.db.Order("perm_name ASC").Find(&perms)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the permission from the db, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"roles":       roles,
+				"permissions": perms,
+			},
+		})
+	})
+}
+
+// NOTE: Without `user_id` it return the role of the current user.
+// GET : api/protected/role-of
+func appHandleRoleOf(backend *Backend, route fiber.Router) {
+	route.Get("role-of", func(c *fiber.Ctx) error {
+		userID := currentUserID(c)
+		if c.Query("user_id") != "" {
+			userID = c.QueryInt("user_id", -1)
+			if userID != currentUserID(c) && !userCan(backend, c, permUserRead) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Invalid credentials to access this api.",
+					"error_code": 1,
+					"data":       nil,
+				})
+			}
+		}
+
+		var assignments []table.RoleAssignment
+		res := backend.db.Preload("Role").Where("user_id = ?", userID).Find(&assignments)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the role from the db, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		perms, err := userPermissions(backend.db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the permission from the db, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		roles := make([]string, 0, len(assignments))
+		for _, assignment := range assignments {
+			roles = append(roles, assignment.Role.RoleName)
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"roles":       roles,
+				"permissions": perms,
+			},
+		})
+	})
+}
+
+// POST : api/protected/role-new
+func appHandleRoleNew(backend *Backend, route fiber.Router) {
+	route.Post("role-new", requirePermission(backend, permRoleManage), func(c *fiber.Ctx) error {
+		var body struct {
+			Name        string   `json:"name"`
+			Desc        string   `json:"desc"`
+			Permissions []string `json:"permissions"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.Name == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need at least the name.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		perms, err := rolePermissionsByName(backend.db, body.Permissions)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Invalid permission, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		callerPerms, err := requestPermissions(backend, c)
+		if err == nil {
+			err = checkPermissionGrant(callerPerms, body.Permissions)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Cant make a role with a permission you dont have, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		var count int64
+		backend.db.Unscoped().Model(&table.Role{}).Where("role_name = ?", body.Name).Count(&count)
+		if count > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Role with that name already exist.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		role := table.Role{
+			RoleName:    body.Name,
+			RoleDesc:    body.Desc,
+			Permissions: perms,
+		}
+		res := backend.db.Create(&role)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to write to db, %v", res.Error),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Role created.",
+			"error_code": 0,
+			"data":       role,
+		})
+	})
+}
+
+// NOTE: The permission list replace the old one. Builtin role cant be edited.
+// POST : api/protected/role-edit
+func appHandleRoleEdit(backend *Backend, route fiber.Router) {
+	route.Post("role-edit", requirePermission(backend, permRoleManage), func(c *fiber.Ctx) error {
+		var body struct {
+			ID          int       `json:"id"`
+			Desc        *string   `json:"desc"`
+			Permissions *[]string `json:"permissions"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Invalid request body, %v", err),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var role table.Role
+		res := backend.db.First(&role, body.ID)
+		if res.Error != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "There is no role with that id.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		if role.RoleBuiltin {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Builtin role cant be changed.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		// NOTE: Both the old and the new list, a role with more than the caller
+		//       cant be touched at all.
+		want, err := rolePermissionNames(backend.db, role.RoleName)
+		if err == nil && body.Permissions != nil {
+			want = append(want, *body.Permissions...)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to read the role, %v", err),
+				"error_code": 6,
+				"data":       nil,
+			})
+		}
+		callerPerms, err := requestPermissions(backend, c)
+		if err == nil {
+			err = checkPermissionGrant(callerPerms, want)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Cant change a role with a permission you dont have, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		err = backend.db.Transaction(func(tx *gorm.DB) error {
+			if body.Desc != nil {
+				if err := tx.Model(&role).Update("role_desc", *body.Desc).Error; err != nil {
+					return err
+				}
+			}
+			if body.Permissions != nil {
+				perms, err := rolePermissionsByName(tx, *body.Permissions)
+				if err != nil {
+					return err
+				}
+				return tx.Model(&role).Association("Permissions").Replace(perms)
+			}
+			return nil
+		})
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to update the role, %v", err),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Role updated.",
+			"error_code": 0,
+			"data":       nil,
+		})
+	})
+}
+
+// NOTE: Also remove the role from every user that have it.
+// POST : api/protected/role-del
+func appHandleRoleDel(backend *Backend, route fiber.Router) {
+	route.Post("role-del", requirePermission(backend, permRoleManage), func(c *fiber.Ctx) error {
+		var body struct {
+			ID int `json:"id"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Invalid request body, %v", err),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var role table.Role
+		res := backend.db.First(&role, body.ID)
+		if res.Error != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "There is no role with that id.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		if role.RoleBuiltin {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Builtin role cant be deleted.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		perms, err := rolePermissionNames(backend.db, role.RoleName)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to read the role, %v", err),
+				"error_code": 6,
+				"data":       nil,
+			})
+		}
+		callerPerms, err := requestPermissions(backend, c)
+		if err == nil {
+			err = checkPermissionGrant(callerPerms, perms)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Cant delete a role with a permission you dont have, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		err = backend.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Where("role_id = ?", role.ID).Delete(&table.RoleAssignment{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+				return err
+			}
+			return tx.Delete(&role).Error
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to delete the role, %v", err),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Role deleted.",
+			"error_code": 0,
+			"data":       nil,
+		})
+	})
+}
+
+// NOTE: `assign` false to take the role away.
+// POST : api/protected/role-assign
+func appHandleRoleAssign(backend *Backend, route fiber.Router) {
+	route.Post("role-assign", requirePermission(backend, permRoleManage), func(c *fiber.Ctx) error {
+		var body struct {
+			UserID int    `json:"user_id"`
+			Role   string `json:"role"`
+			Assign *bool  `json:"assign"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.UserID == 0 || body.Role == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the user_id and the role.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		res := backend.db.First(&table.User{}, body.UserID)
+		if res.Error != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "There is no user with that id.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		// NOTE: Same rule to give or take, only a role the caller could give.
+		callerPerms, err := requestPermissions(backend, c)
+		if err == nil {
+			err = checkRoleGrant(backend.db, callerPerms, body.Role)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Cant change that role, %v", err),
+				"error_code": 6,
+				"data":       nil,
+			})
+		}
+
+		if body.Assign == nil || *body.Assign {
+			err = assignRole(backend.db, body.UserID, body.Role)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    fmt.Sprintf("Failed to assign the role, %v", err),
+					"error_code": 3,
+					"data":       nil,
+				})
+			}
+
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"success":    true,
+				"message":    "Role assigned.",
+				"error_code": 0,
+				"data":       nil,
+			})
+		}
+
+		// Dont let the last super-admin go, no one could give it back.
+		if body.Role == roleSuperAdmin {
+			var count int64
+			backend.db.Model(&table.RoleAssignment{}).
+				Joins("JOIN roles ON roles.id = role_assignments.role_id").
+				Where("roles.role_name = ?", roleSuperAdmin).
+				Count(&count)
+			if count <= 1 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Cant remove the last super-admin.",
+					"error_code": 4,
+					"data":       nil,
+				})
+			}
+		}
+
+		count, err := unassignRole(backend.db, body.UserID, body.Role)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to remove the role, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    fmt.Sprintf("Removed %d role.", count),
+			"error_code": 0,
+			"data":       nil,
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NOTE: `kind` is one of login_success, login_failed, login_locked, password_reset,
+//       password_change and email_change, empty for all. Newest first.
+func securityLogResponse(backend *Backend, c *fiber.Ctx, userID int, errCode int) error {
+	offset, err := strconv.Atoi(c.Query("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(c.Query("limit", "50"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	query := backend.db.Model(&table.SecurityEvent{}).Where("user_id = ?", userID)
+	kind := table.SecurityEventKind(c.Query("kind"))
+	switch kind {
+	case "":
+	case table.SecLoginSuccess, table.SecLoginFailed, table.SecLoginLocked, table.SecPasswordReset, table.SecPasswordChange, table.SecEmailChange:
+		query = query.Where("sec_kind = ?", kind)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"message":    "Invalid kind, the only valid strings are : `login_success`, `login_failed`, `login_locked`, `password_reset`, `password_change` and `email_change`",
+			"error_code": errCode,
+			"data":       nil,
+		})
+	}
+
+	var total int64
+	var entries []table.SecurityEvent
+	if err = query.Count(&total).Error; err == nil {
+		err = query.Order("id DESC").Offset(offset).Limit(limit).Find(&entries).Error
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success":    false,
+			"message":    fmt.Sprintf("Failed to fetch the security log, %v", err),
+			"error_code": errCode + 1,
+			"data":       nil,
+		})
+	}
+
+	result := make([]fiber.Map, 0, len(entries))
+	for i := range entries {
+		result = append(result, securityEventJSON(&entries[i]))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":    true,
+		"message":    "Check data.",
+		"error_code": 0,
+		"data": fiber.Map{
+			"total":   total,
+			"entries": result,
+		},
+	})
+}
+
+// NOTE: The login and password history of the logged in user, see securityLogResponse
+//       for the query.
+// GET : api/protected/security-log
+func appHandleSecurityLog(backend *Backend, route fiber.Router) {
+	route.Get("security-log", func(c *fiber.Ctx) error {
+		return securityLogResponse(backend, c, currentUserID(c), 1)
+	})
+}
+
+// NOTE: Same as api/protected/security-log for any user with `?user_id=`.
+// GET : api/protected/security-log-of
+func appHandleSecurityLogOf(backend *Backend, route fiber.Router) {
+	route.Get("security-log-of", requirePermission(backend, permUserRead), func(c *fiber.Ctx) error {
+		userID, err := strconv.Atoi(c.Query("user_id"))
+		if err != nil || userID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid user_id.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+		return securityLogResponse(backend, c, userID, 2)
+	})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NOTE: Trade the refresh token for a new access token and a new refresh token,
+//       the old refresh token cant be used again.
+// POST : api/refresh
+func appHandleRefresh(backend *Backend, route fiber.Router) {
+	route.Post("refresh", func(c *fiber.Ctx) error {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.RefreshToken == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the refresh_token.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		t, refresh, err := rotateSession(backend, c, body.RefreshToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, errSessionInvalid):
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Invalid refresh token.",
+					"error_code": 2,
+					"data":       nil,
+				})
+			case errors.Is(err, errSessionRevoked):
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    "The session is revoked or expired, please login again.",
+					"error_code": 3,
+					"data":       nil,
+				})
+			case errors.Is(err, errSessionReused):
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    "The refresh token was already used, the session is revoked. Please login again.",
+					"error_code": 4,
+					"data":       nil,
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to refresh the session, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		c.Cookie(&fiber.Cookie{
+			Name:     "jwt",
+			Value:    t,
+			HTTPOnly: true,
+			Secure:   false,
+			SameSite: "Lax",
+			Expires:  time.Now().Add(accessTokenTTL),
+		})
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":       true,
+			"message":       "Session refreshed.",
+			"error_code":    0,
+			"data":          nil,
+			"token":         t,
+			"refresh_token": refresh,
+		})
+	})
+}
+
+// NOTE: Log out from every device, including this one.
+// POST : api/protected/logout-all
+func appHandleLogOutAll(backend *Backend, route fiber.Router) {
+	route.Post("logout-all", requireSession, func(c *fiber.Ctx) error {
+		user, err := GetJWT(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid credentials to access this api.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		count, err := revokeUserSessions(backend.db, user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to revoke the session, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+		c.ClearCookie("jwt")
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Logged out from every device.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"revoked": count,
+			},
+		})
+	})
+}
+
+// NOTE: Without `user_id` it list the session of the current user, admin can
+//       pass `user_id` to see someone else. Only the active one is listed.
+// GET : api/protected/session-list
+func appHandleSessionList(backend *Backend, route fiber.Router) {
+	route.Get("session-list", requireSession, func(c *fiber.Ctx) error {
+		claims, err := jwtClaims(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid credentials to access this api.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+		user, err := GetJWT(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid credentials to access this api.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+		admin := userCan(backend, c, permSessionManage)
+		sid, _ := claims["sid"].(string)
+
+		userID := user.ID
+		if c.Query("user_id") != "" {
+			userID = c.QueryInt("user_id", -1)
+			if userID != user.ID && !admin {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Invalid credentials to access this api.",
+					"error_code": 3,
+					"data":       nil,
+				})
+			}
+		}
+
+		var sessions []table.Session
+		res := backend.db.
+			Where("user_id = ? AND session_revoked IS NULL AND session_expires > ?", userID, time.Now()).
+			Order("session_last_used DESC").
+			Find(&sessions)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the session from the db, %v", res.Error),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		result := make([]fiber.Map, 0, len(sessions))
+		for _, session := range sessions {
+			result = append(result, fiber.Map{
+				"id":         session.ID,
+				"ip":         session.SessionIP,
+				"agent":      session.SessionAgent,
+				"created_at": session.CreatedAt,
+				"last_used":  session.SessionLastUsed,
+				"expires":    session.SessionExpires,
+				"current":    session.SessionSID == sid,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data":       result,
+		})
+	})
+}
+
+// NOTE: Revoke one session with `id` or every session of `user_id`.
+//       Normal user can only revoke their own, admin can revoke anyone.
+// POST : api/protected/session-revoke
+func appHandleSessionRevoke(backend *Backend, route fiber.Router) {
+	route.Post("session-revoke", requireSession, func(c *fiber.Ctx) error {
+		user, err := GetJWT(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid credentials to access this api.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+		admin := userCan(backend, c, permSessionManage)
+
+		var body struct {
+			ID     int `json:"id"`
+			UserID int `json:"user_id"`
+		}
+
+		err = c.BodyParser(&body)
+		if err != nil || (body.ID == 0 && body.UserID == 0) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the session id or the user_id.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		query := backend.db.Model(&table.Session{}).Where("session_revoked IS NULL")
+		if body.ID != 0 {
+			query = query.Where("id = ?", body.ID)
+		} else {
+			query = query.Where("user_id = ?", body.UserID)
+		}
+		if !admin {
+			query = query.Where("user_id = ?", user.ID)
+		}
+
+		res := query.Update("session_revoked", time.Now())
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to revoke the session, %v", res.Error),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    fmt.Sprintf("Revoked %d session.", res.RowsAffected),
+			"error_code": 0,
+			"data": fiber.Map{
+				"revoked": res.RowsAffected,
+			},
+		})
+	})
+}
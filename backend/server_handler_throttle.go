@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NOTE: Only the account and ip that is locked now. `kind` is `account` or `ip`,
+//       empty for both.
+// GET : api/protected/login-lock-list
+func appHandleLoginLockList(backend *Backend, route fiber.Router) {
+	route.Get("login-lock-list", requirePermission(backend, permUserRead), func(c *fiber.Ctx) error {
+		kind := c.Query("kind")
+		if kind != "" && kind != string(table.ThrottleAccount) && kind != string(table.ThrottleIP) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid kind, the only valid strings are : `account` and `ip`",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		query := backend.db.Where("throttle_locked_until > ?", time.Now())
+		if kind != "" {
+			query = query.Where("throttle_kind = ?", kind)
+		}
+
+		var entries []table.LoginThrottle
+		res := query.Order("throttle_locked_until DESC").Find(&entries)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the locked login, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		locked := make([]fiber.Map, 0, len(entries))
+		for _, entry := range entries {
+			locked = append(locked, fiber.Map{
+				"kind":         entry.ThrottleKind,
+				"key":          entry.ThrottleKey,
+				"failures":     entry.ThrottleFailures,
+				"last_failure": entry.ThrottleLastFail,
+				"locked_until": entry.ThrottleLocked,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Locked login.",
+			"error_code": 0,
+			"data":       locked,
+		})
+	})
+}
+
+// NOTE: Clear the failed login of `email` or `ip` (or both), not only the lock.
+// POST : api/protected/login-unlock
+func appHandleLoginUnlock(backend *Backend, route fiber.Router) {
+	route.Post("login-unlock", requirePermission(backend, permUserEdit), func(c *fiber.Ctx) error {
+		var body struct {
+			Email string `json:"email"`
+			IP    string `json:"ip"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || (body.Email == "" && body.IP == "") {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the email or ip.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		query := backend.db.Unscoped().Where("1 = 0")
+		if body.Email != "" {
+			query = query.Or("throttle_kind = ? AND throttle_key = ?", table.ThrottleAccount, throttleAccountKey(body.Email))
+		}
+		if body.IP != "" {
+			query = query.Or("throttle_kind = ? AND throttle_key = ?", table.ThrottleIP, body.IP)
+		}
+
+		res := query.Delete(&table.LoginThrottle{})
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to unlock, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Login unlocked.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"cleared": res.RowsAffected,
+			},
+		})
+	})
+}
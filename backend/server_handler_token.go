@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NOTE: `scopes` is a list of permission name (see api/protected/role-of), or
+//       `["*"]` for everything the user can do. `expires_in_days` 0 mean never.
+//       The token is only returned here, use it as `Authorization: Bearer <token>`.
+// POST : api/protected/token-new
+func appHandleTokenNew(backend *Backend, route fiber.Router) {
+	route.Post("token-new", requireSession, func(c *fiber.Ctx) error {
+		var body struct {
+			Name          string   `json:"name"`
+			Scopes        []string `json:"scopes"`
+			ExpiresInDays int      `json:"expires_in_days"`
+		}
+
+		err := c.BodyParser(&body)
+		body.Name = strings.TrimSpace(body.Name)
+		if err != nil || body.Name == "" || body.ExpiresInDays < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the name and a positive expires_in_days.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		userID := currentUserID(c)
+		err = validateApiTokenScopes(backend.db, userID, body.Scopes)
+		if err != nil {
+			if errors.Is(err, errApiTokenScope) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"success":    false,
+					"message":    err.Error(),
+					"error_code": 2,
+					"data":       nil,
+				})
+			}
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Invalid scopes, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		secret, err := newApiTokenSecret()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to generate the token, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		token := table.ApiToken{
+			UserId:      userID,
+			TokenName:   body.Name,
+			TokenPrefix: secret[:len(apiTokenPrefix)+6],
+			TokenHash:   hashRefreshSecret(secret),
+			TokenScopes: strings.Join(body.Scopes, ","),
+		}
+		if body.ExpiresInDays > 0 {
+			expires := time.Now().AddDate(0, 0, body.ExpiresInDays)
+			token.TokenExpires = &expires
+		}
+
+		res := backend.db.Create(&token)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to save the token, %v", res.Error),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Token created, it will not be shown again.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"id":      token.ID,
+				"name":    token.TokenName,
+				"token":   secret,
+				"scopes":  apiTokenScopes(&token),
+				"expires": token.TokenExpires,
+			},
+		})
+	})
+}
+
+// NOTE: Admin with session:manage can see the token of another user with `?user_id`.
+// GET : api/protected/token-list
+func appHandleTokenList(backend *Backend, route fiber.Router) {
+	route.Get("token-list", requireSession, func(c *fiber.Ctx) error {
+		userID := currentUserID(c)
+		if c.Query("user_id") != "" {
+			userID = c.QueryInt("user_id", -1)
+			if userID != currentUserID(c) && !userCan(backend, c, permSessionManage) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Invalid credentials to access this api.",
+					"error_code": 1,
+					"data":       nil,
+				})
+			}
+		}
+
+		var tokens []table.ApiToken
+		res := backend.db.Where("user_id = ? AND token_revoked IS NULL", userID).Order("id DESC").Find(&tokens)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the token from the db, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		now := time.Now()
+		result := make([]fiber.Map, 0, len(tokens))
+		for _, token := range tokens {
+			result = append(result, fiber.Map{
+				"id":         token.ID,
+				"name":       token.TokenName,
+				"prefix":     token.TokenPrefix,
+				"scopes":     apiTokenScopes(&token),
+				"created_at": token.CreatedAt,
+				"expires":    token.TokenExpires,
+				"expired":    token.TokenExpires != nil && token.TokenExpires.Before(now),
+				"last_used":  token.TokenLastUsed,
+				"last_ip":    token.TokenLastIP,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data":       result,
+		})
+	})
+}
+
+// NOTE: The owner or an admin with session:manage.
+// POST : api/protected/token-revoke
+func appHandleTokenRevoke(backend *Backend, route fiber.Router) {
+	route.Post("token-revoke", requireSession, func(c *fiber.Ctx) error {
+		var body struct {
+			ID int `json:"id"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.ID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the id.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var token table.ApiToken
+		res := backend.db.Where("id = ? AND token_revoked IS NULL", body.ID).First(&token)
+		if res.Error != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Token not found.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		if token.UserId != currentUserID(c) && !userCan(backend, c, permSessionManage) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid credentials to access this api.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		res = backend.db.Model(&token).Update("token_revoked", time.Now())
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to revoke the token, %v", res.Error),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Token revoked.",
+			"error_code": 0,
+			"data":       nil,
+		})
+	})
+}
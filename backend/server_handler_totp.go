@@ -0,0 +1,616 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"webrpl/table"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func totpEnrollData(user *table.User, secret string) (fiber.Map, error) {
+	provisioning := totpProvisioningURL(secret, user.UserEmail)
+	png, err := qrCodePNG(provisioning)
+	if err != nil {
+		return nil, err
+	}
+	return fiber.Map{
+		"secret":      secret,
+		"otpauth_url": provisioning,
+		"qr":          "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// NOTE: Second step of api/login. `code` is the code from the authenticator app
+//       or one recovery code. When the user must enroll first (see
+//       api/login-2fa-enroll) the first code confirm the secret and the
+//       recovery code is returned on `recovery_codes`. A wrong code count as a
+//       failed login (error_code 7 when locked).
+// POST : api/login-2fa
+func appHandleLogin2FA(backend *Backend, route fiber.Router) {
+	route.Post("login-2fa", func(c *fiber.Ctx) error {
+		var body struct {
+			MFAToken string `json:"mfa_token"`
+			Code     string `json:"code"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.MFAToken == "" || body.Code == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the mfa_token and code.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		user, err := parseMFAToken(backend, body.MFAToken)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    "The login is expired, please login again.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		// NOTE: The code is only 6 digit, it share the failed login limit of the account.
+		wait, err := loginThrottleAttempt(backend.db, user.UserEmail, throttleClientIP(c))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the failed login, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+		if wait > 0 {
+			recordSecurityEvent(backend, c, user, user.UserEmail, table.SecLoginLocked, "too many failed login")
+			return loginThrottled(c, wait, 7)
+		}
+
+		enabled, err := totpEnabled(backend.db, user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the two factor, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		if !enabled {
+			codes, err := totpConfirm(backend.db, user.ID, body.Code)
+			if err != nil {
+				if errors.Is(err, errTOTPNotEnabled) {
+					return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+						"success":    false,
+						"message":    "Two factor is required for this account, enroll first on api/login-2fa-enroll.",
+						"error_code": 4,
+						"data":       nil,
+					})
+				}
+				if errors.Is(err, errTOTPInvalid) {
+					recordSecurityEvent(backend, c, user, user.UserEmail, table.SecLoginFailed, "wrong two factor code")
+					return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+						"success":    false,
+						"message":    "Wrong two factor code.",
+						"error_code": 5,
+						"data":       nil,
+					})
+				}
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"success":    false,
+					"message":    fmt.Sprintf("Failed to enable the two factor, %v", err),
+					"error_code": 3,
+					"data":       nil,
+				})
+			}
+			if err := loginThrottleUndo(backend.db, user.UserEmail, throttleClientIP(c)); err != nil {
+				log.Printf("Failed to give back the login attempt: %v", err)
+			}
+			if err := loginThrottleSuccess(backend.db, user.UserEmail); err != nil {
+				log.Printf("Failed to clear the failed login: %v", err)
+			}
+			return loginResponseWith(backend, c, user, 6, fiber.Map{"recovery_codes": codes})
+		}
+
+		err = totpCheck(backend.db, user.ID, body.Code)
+		if err != nil {
+			if errors.Is(err, errTOTPInvalid) {
+				recordSecurityEvent(backend, c, user, user.UserEmail, table.SecLoginFailed, "wrong two factor code")
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Wrong two factor code.",
+					"error_code": 5,
+					"data":       nil,
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the two factor, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		if err := loginThrottleUndo(backend.db, user.UserEmail, throttleClientIP(c)); err != nil {
+			log.Printf("Failed to give back the login attempt: %v", err)
+		}
+		if err := loginThrottleSuccess(backend.db, user.UserEmail); err != nil {
+			log.Printf("Failed to clear the failed login: %v", err)
+		}
+		return loginResponse(backend, c, user, 6)
+	})
+}
+
+// NOTE: For the user that must have two factor but didnt enroll yet, they cant
+//       get a session to use api/protected/totp-enroll so it is done here.
+// POST : api/login-2fa-enroll
+func appHandleLogin2FAEnroll(backend *Backend, route fiber.Router) {
+	route.Post("login-2fa-enroll", func(c *fiber.Ctx) error {
+		var body struct {
+			MFAToken string `json:"mfa_token"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.MFAToken == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the mfa_token.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		user, err := parseMFAToken(backend, body.MFAToken)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    "The login is expired, please login again.",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		secret, err := totpEnroll(backend.db, user)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to enroll the two factor, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		data, err := totpEnrollData(user, secret)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to make the QR code, %v", err),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Scan the QR code and login with the first code.",
+			"error_code": 0,
+			"data":       data,
+		})
+	})
+}
+
+// GET : api/protected/totp-status
+func appHandleTOTPStatus(backend *Backend, route fiber.Router) {
+	route.Get("totp-status", func(c *fiber.Ctx) error {
+		var user table.User
+		res := backend.db.First(&user, currentUserID(c))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		enabled, err := totpEnabled(backend.db, user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the two factor, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		var recoveryLeft int64
+		res = backend.db.Model(&table.TOTPRecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).Count(&recoveryLeft)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to count the recovery code, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Two factor status.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"enabled":       enabled,
+				"required":      user.UserTOTPRequired,
+				"recovery_left": recoveryLeft,
+			},
+		})
+	})
+}
+
+// NOTE: The secret is not active until api/protected/totp-confirm.
+// POST : api/protected/totp-enroll
+func appHandleTOTPEnroll(backend *Backend, route fiber.Router) {
+	route.Post("totp-enroll", requireSession, func(c *fiber.Ctx) error {
+		var user table.User
+		res := backend.db.First(&user, currentUserID(c))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		secret, err := totpEnroll(backend.db, &user)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to enroll the two factor, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		data, err := totpEnrollData(&user, secret)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to make the QR code, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Scan the QR code and confirm with the first code.",
+			"error_code": 0,
+			"data":       data,
+		})
+	})
+}
+
+// NOTE: The recovery code is only shown here, save it.
+// POST : api/protected/totp-confirm
+func appHandleTOTPConfirm(backend *Backend, route fiber.Router) {
+	route.Post("totp-confirm", requireSession, func(c *fiber.Ctx) error {
+		var body struct {
+			Code string `json:"code"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.Code == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the code.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		codes, err := totpConfirm(backend.db, currentUserID(c), body.Code)
+		if err != nil {
+			if errors.Is(err, errTOTPInvalid) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Wrong two factor code.",
+					"error_code": 2,
+					"data":       nil,
+				})
+			}
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to enable the two factor, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Two factor enabled.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"recovery_codes": codes,
+			},
+		})
+	})
+}
+
+// NOTE: Need a valid code so a stolen session cant just turn it off.
+// POST : api/protected/totp-disable
+func appHandleTOTPDisable(backend *Backend, route fiber.Router) {
+	route.Post("totp-disable", requireSession, func(c *fiber.Ctx) error {
+		var body struct {
+			Code string `json:"code"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.Code == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the code.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var user table.User
+		res := backend.db.First(&user, currentUserID(c))
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		if user.UserTOTPRequired {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Two factor is required for this account.",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		err = totpCheck(backend.db, user.ID, body.Code)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the two factor, %v", err),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		err = totpDisable(backend.db, user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to disable the two factor, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Two factor disabled.",
+			"error_code": 0,
+			"data":       nil,
+		})
+	})
+}
+
+// NOTE: The old recovery code stop working.
+// POST : api/protected/totp-recovery-new
+func appHandleTOTPRecoveryNew(backend *Backend, route fiber.Router) {
+	route.Post("totp-recovery-new", requireSession, func(c *fiber.Ctx) error {
+		var body struct {
+			Code string `json:"code"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.Code == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the code.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		userID := currentUserID(c)
+		err = totpCheck(backend.db, userID, body.Code)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the two factor, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		codes, err := totpNewRecoveryCodes(backend.db, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to make the recovery code, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "New recovery code made.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"recovery_codes": codes,
+			},
+		})
+	})
+}
+
+// NOTE: For the user that lost the device and the recovery code. When two factor
+//       is required they have to enroll again on the next login. Same rule as
+//       user-edit-admin, only a user the caller could edit (see checkUserManage).
+// POST : api/protected/totp-reset
+func appHandleTOTPReset(backend *Backend, route fiber.Router) {
+	route.Post("totp-reset", requirePermission(backend, permUserEdit), func(c *fiber.Ctx) error {
+		var body struct {
+			UserID int `json:"user_id"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.UserID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the user_id.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var user table.User
+		res := backend.db.First(&user, body.UserID)
+		if res.Error != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		callerPerms, err := requestPermissions(backend, c)
+		if err == nil {
+			err = checkUserManage(backend.db, currentUserID(c), callerPerms, user.ID)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Cant reset the two factor of this user, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		err = totpDisable(backend.db, user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to reset the two factor, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		if _, err := revokeUserSessions(backend.db, user.ID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to revoke the sessions, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Two factor reset.",
+			"error_code": 0,
+			"data":       nil,
+		})
+	})
+}
+
+// NOTE: Only for the admin account (UserRole 1 or can open the admin panel).
+// POST : api/protected/totp-require
+func appHandleTOTPRequire(backend *Backend, route fiber.Router) {
+	route.Post("totp-require", requirePermission(backend, permUserEdit), func(c *fiber.Ctx) error {
+		var body struct {
+			UserID  int  `json:"user_id"`
+			Require bool `json:"require"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.UserID <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the user_id.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		var user table.User
+		res := backend.db.First(&user, body.UserID)
+		if res.Error != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the user, %v", res.Error),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		panel, err := userHasPermission(backend.db, user.ID, permAdminPanel)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the user permission, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+		if user.UserRole != 1 && !panel {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Two factor can only be required for an admin account.",
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		callerPerms, err := requestPermissions(backend, c)
+		if err == nil {
+			err = checkUserManage(backend.db, currentUserID(c), callerPerms, user.ID)
+		}
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Cant change the two factor of this user, %v", err),
+				"error_code": 6,
+				"data":       nil,
+			})
+		}
+
+		res = backend.db.Model(&user).Update("user_totp_required", body.Require)
+		if res.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to update the user, %v", res.Error),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Two factor requirement updated.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"user_id": user.ID,
+				"require": body.Require,
+			},
+		})
+	})
+}
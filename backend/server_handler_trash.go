@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NOTE: The permission depend on the type, user:delete for `user`, event:delete
+//       for `event`, event:edit for `material` and cert:manage for `cert`.
+func trashAllowed(backend *Backend, c *fiber.Ctx, kind trashType) (bool, error) {
+	perm, err := trashPermission(kind)
+	if err != nil {
+		return false, err
+	}
+	return requestHasPermission(backend, c, perm)
+}
+
+func trashErrorStatus(err error) (int, int) {
+	switch {
+	case errors.Is(err, errTrashNotFound):
+		return fiber.StatusNotFound, 3
+	case errors.Is(err, errTrashParent), errors.Is(err, errTrashConflict), errors.Is(err, errTrashAnonymized):
+		return fiber.StatusConflict, 4
+	}
+	return fiber.StatusInternalServerError, 5
+}
+
+// NOTE: `type` is `user`, `event`, `material` or `cert`. Newest deleted first.
+// GET : api/protected/trash-list
+func appHandleTrashList(backend *Backend, route fiber.Router) {
+	route.Get("trash-list", func(c *fiber.Ctx) error {
+		kind := trashType(c.Query("type"))
+		allowed, err := trashAllowed(backend, c, kind)
+		if errors.Is(err, errTrashType) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Invalid request, %v", err),
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+		if err != nil || !allowed {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid credentials for this function",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		offset, err := strconv.Atoi(c.Query("offset", "0"))
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		limit, err := strconv.Atoi(c.Query("limit", "50"))
+		if err != nil || limit <= 0 {
+			limit = 50
+		}
+
+		entries, total, err := trashList(backend.db, kind, offset, limit)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to fetch the trash, %v", err),
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    "Check data.",
+			"error_code": 0,
+			"data": fiber.Map{
+				"total":   total,
+				"entries": entries,
+			},
+		})
+	})
+}
+
+// NOTE: Bring back the record and everything that was deleted together with it.
+// POST : api/protected/trash-restore
+func appHandleTrashRestore(backend *Backend, route fiber.Router) {
+	route.Post("trash-restore", func(c *fiber.Ctx) error {
+		return trashAction(backend, c, func(kind trashType, id int) error {
+			return trashRestore(backend.db, kind, id)
+		}, "Record restored.")
+	})
+}
+
+// NOTE: Permanent, the record, everything that belong to it and the file on
+//       disk is removed. Only a record that is already in the trash.
+// POST : api/protected/trash-purge
+func appHandleTrashPurge(backend *Backend, route fiber.Router) {
+	route.Post("trash-purge", func(c *fiber.Ctx) error {
+		return trashAction(backend, c, func(kind trashType, id int) error {
+			return trashPurge(backend.db, kind, id)
+		}, "Record purged.")
+	})
+}
+
+func trashAction(backend *Backend, c *fiber.Ctx, action func(trashType, int) error, message string) error {
+	var body struct {
+		Type string `json:"type"`
+		ID   int    `json:"id"`
+	}
+
+	err := c.BodyParser(&body)
+	if err != nil || body.ID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"message":    "Invalid request body, need the type and id.",
+			"error_code": 1,
+			"data":       nil,
+		})
+	}
+
+	kind := trashType(body.Type)
+	allowed, err := trashAllowed(backend, c, kind)
+	if errors.Is(err, errTrashType) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"success":    false,
+			"message":    fmt.Sprintf("Invalid request, %v", err),
+			"error_code": 1,
+			"data":       nil,
+		})
+	}
+	if err != nil || !allowed {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"success":    false,
+			"message":    "Invalid credentials for this function",
+			"error_code": 2,
+			"data":       nil,
+		})
+	}
+
+	if err := action(kind, body.ID); err != nil {
+		status, code := trashErrorStatus(err)
+		return c.Status(status).JSON(fiber.Map{
+			"success":    false,
+			"message":    fmt.Sprintf("Failed, %v", err),
+			"error_code": code,
+			"data":       nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":    true,
+		"message":    message,
+		"error_code": 0,
+		"data":       nil,
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"log"
 	"os"
 	"strconv"
 	"strings"
@@ -11,7 +12,6 @@ import (
 	"webrpl/table"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 	"gorm.io/gorm"
 )
 
@@ -44,32 +44,48 @@ func appHandleUserResetPass(backend *Backend, route fiber.Router) {
 		}
 
 		var selUser table.User
-		var selOTP table.OTP
-		res := backend.db.Where("user_email = ? AND otp_code = ?", body.Email, body.OtpCode).First(&selOTP)
-		res2 := backend.db.Where("user_email = ?", body.Email).First(&selUser)
-
-		if res.Error != nil || res2.Error != nil {
-			if !errors.Is(res.Error, gorm.ErrRecordNotFound) && !errors.Is(res2.Error, gorm.ErrRecordNotFound) {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"success":    false,
-					"message":    "Failed to fetch user or otp from the db.",
-					"error_code": 3,
-					"data":       nil,
-				})
-			}
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		res := backend.db.Where("user_email = ?", body.Email).First(&selUser)
+		if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
-				"message":    "There is no otp or user with that email registered.",
-				"error_code": 4,
+				"message":    "Failed to fetch user or otp from the db.",
+				"error_code": 3,
 				"data":       nil,
 			})
 		}
 
-		if IsOTPExpired(&selOTP) || selOTP.Used {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		err = consumeOTP(backend, table.OTPReset, body.Email, body.OtpCode)
+		if err == nil && res.Error != nil {
+			err = errOTPInvalid
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, errOTPInvalid):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "There is no otp or user with that email registered.",
+					"error_code": 4,
+					"data":       nil,
+				})
+			case errors.Is(err, errOTPExpired):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "The specified OTP is expired. Please request new code.",
+					"error_code": 5,
+					"data":       nil,
+				})
+			case errors.Is(err, errOTPTooMany):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Too many wrong OTP, the code is no longer valid. Please request new code.",
+					"error_code": 8,
+					"data":       nil,
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
-				"message":    "The specified OTP is expired. Please request new code.",
-				"error_code": 5,
+				"message":    "Failed to fetch user or otp from the db.",
+				"error_code": 3,
 				"data":       nil,
 			})
 		}
@@ -94,7 +110,22 @@ func appHandleUserResetPass(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		selOTP.Used = true
+		recordSecurityEvent(backend, c, &selUser, selUser.UserEmail, table.SecPasswordReset, "with the email otp")
+
+		// Whoever had the old password should not stay logged in.
+		if _, err := revokeUserSessions(backend.db, selUser.ID); err != nil {
+			log.Printf("Failed to revoke the session of user %d: %v", selUser.ID, err)
+		}
+
+		mail, err := buildEmail(backend, selUser.UserEmail, "Your webrpl password was changed", "password-changed", map[string]any{
+			"Name": selUser.UserFullName,
+		}, fmt.Sprintf("Hi %s,\n\nThe password of your account was just reset. If this was not you, please reset it again right away.\n", selUser.UserFullName))
+		if err == nil {
+			err = queueEmail(backend, backend.db, mail)
+		}
+		if err != nil {
+			log.Printf("Failed to queue the password changed email: %v", err)
+		}
 
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"success":    true,
@@ -105,6 +136,9 @@ func appHandleUserResetPass(backend *Backend, route fiber.Router) {
 	})
 }
 
+// NOTE: When the user have two factor on, the response has `data.mfa_required`
+//       and `data.mfa_token` instead of the token, finish on api/login-2fa.
+//       Too many failure give error_code 7 with `data.retry_after` (second).
 // POST : api/login
 func appHandleLogin(backend *Backend, route fiber.Router) {
 	route.Post("login", func(c *fiber.Ctx) error {
@@ -141,69 +175,63 @@ func appHandleLogin(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		var user table.User
-		res := backend.db.Where("user_email = ?", body.UserEmail).First(&user)
-
-		if res.Error != nil {
+		wait, err := loginThrottleAttempt(backend.db, body.UserEmail, throttleClientIP(c))
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
-				"message":    fmt.Sprintf("There is a problem in the db, %v", res.Error),
+				"message":    fmt.Sprintf("There is a problem in the db, %v", err),
 				"error_code": 4,
 				"data":       nil,
 			})
 		}
+		if wait > 0 {
+			recordSecurityEvent(backend, c, nil, body.UserEmail, table.SecLoginLocked, "too many failed login")
+			return loginThrottled(c, wait, 7)
+		}
 
-		validPass := CheckPassword(user.UserPassword, body.UserPassword)
-		if !validPass {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		var user table.User
+		res := backend.db.Where("user_email = ?", body.UserEmail).First(&user)
+		if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
-				"message":    "Wrong Password",
-				"error_code": 5,
+				"message":    fmt.Sprintf("There is a problem in the db, %v", res.Error),
+				"error_code": 4,
 				"data":       nil,
 			})
 		}
 
-		claims := jwt.MapClaims{
-			"email": user.UserEmail,
-			"admin": user.UserRole,
-			"exp":   time.Now().Add(time.Hour * 72).Unix(),
+		// NOTE: The unknown email still check a password so both take the same
+		//       time and get the same answer, the email cant be guessed from it.
+		passwordHash := user.UserPassword
+		if res.Error != nil {
+			passwordHash = dummyPasswordHash()
 		}
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-		t, err := token.SignedString([]byte(backend.pass))
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		validPass := CheckPassword(passwordHash, body.UserPassword)
+		if res.Error != nil || !validPass {
+			if res.Error != nil {
+				recordSecurityEvent(backend, c, nil, body.UserEmail, table.SecLoginFailed, "unknown email")
+			} else {
+				recordSecurityEvent(backend, c, &user, body.UserEmail, table.SecLoginFailed, "wrong password")
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
-				"message":    fmt.Sprintf("Failed to generate JWT, %v", err),
-				"error_code": 6,
+				"message":    "Wrong email or password",
+				"error_code": 5,
 				"data":       nil,
 			})
 		}
 
-		c.Cookie(&fiber.Cookie{
-			Name:     "jwt",
-			Value:    t,
-			HTTPOnly: true,
-			Secure:   false,
-			SameSite: "Lax",
-			Expires:  time.Now().Add(72 * time.Hour),
-		})
-
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success":    true,
-			"message":    "successfully logged in.",
-			"data":       user,
-			"error_code": 0,
-			"token":      t,
-		})
+		if err := loginThrottleUndo(backend.db, body.UserEmail, throttleClientIP(c)); err != nil {
+			log.Printf("Failed to give back the login attempt: %v", err)
+		}
+		return beginLogin(backend, c, &user, 6)
 	})
 }
 
 // GET : api/protected/user-info-of
 func appHandleUserInfoOf(backend *Backend, route fiber.Router) {
 	route.Get("user-info-of", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
@@ -213,8 +241,8 @@ func appHandleUserInfoOf(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		admin := claims["admin"].(float64)
-		if admin != 1 {
+		admin := userCan(backend, c, permUserRead)
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials to access this api.",
@@ -265,7 +293,7 @@ func appHandleUserInfoOf(backend *Backend, route fiber.Router) {
 func appHandleUserEditAdmin(backend *Backend, route fiber.Router) {
 	route.Post("/user-edit-admin", func(c *fiber.Ctx) error {
 
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -274,8 +302,8 @@ func appHandleUserEditAdmin(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		admin := claims["admin"].(float64)
-		if admin != 1 {
+		admin := userCan(backend, c, permUserEdit)
+		if !admin {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials to acces this api.",
@@ -311,6 +339,35 @@ func appHandleUserEditAdmin(backend *Backend, route fiber.Router) {
 			})
 		}
 
+		var target table.User
+		res := backend.db.Where("user_email = ?", body.Email).First(&target)
+		if res.Error != nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"success":    false,
+				"message":    "User not found or no changes made.",
+				"error_code": 7,
+				"data":       nil,
+			})
+		}
+
+		callerPerms, err := requestPermissions(backend, c)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the permission, %v", err),
+				"error_code": 8,
+				"data":       nil,
+			})
+		}
+		if err := checkUserManage(backend.db, currentUserID(c), callerPerms, target.ID); err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Cant edit this user, %v", err),
+				"error_code": 9,
+				"data":       nil,
+			})
+		}
+
 		updates := make(map[string]any)
 		if body.FullName != "" {
 			updates["user_full_name"] = body.FullName
@@ -337,7 +394,7 @@ func appHandleUserEditAdmin(backend *Backend, route fiber.Router) {
 			updates["user_password"] = hashedPassword
 		}
 
-		result := backend.db.Model(&table.User{}).Where("user_email = ?", body.Email).Updates(updates)
+		result := backend.db.Model(&table.User{}).Where("id = ?", target.ID).Updates(updates)
 		if result.Error != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -356,6 +413,10 @@ func appHandleUserEditAdmin(backend *Backend, route fiber.Router) {
 			})
 		}
 
+		if _, ok := updates["user_password"]; ok {
+			recordSecurityEvent(backend, c, &target, target.UserEmail, table.SecPasswordChange, fmt.Sprintf("by admin user %d", currentUserID(c)))
+		}
+
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"success":    true,
 			"message":    "Data modified.",
@@ -365,6 +426,7 @@ func appHandleUserEditAdmin(backend *Backend, route fiber.Router) {
 	})
 }
 
+// NOTE: The user and the event participation go to the trash, see api/protected/trash-list.
 // POST: api/protected/user-del-admin
 func appHandleUserDelAdmin(backend *Backend, route fiber.Router) {
 	route.Post("/user-del-admin", func(c *fiber.Ctx) error {
@@ -372,7 +434,7 @@ func appHandleUserDelAdmin(backend *Backend, route fiber.Router) {
 			UserID int `json:"id"`
 		}
 
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -392,8 +454,8 @@ func appHandleUserDelAdmin(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		admin := claims["admin"].(float64)
-		if admin != 1 {
+		admin := userCan(backend, c, permUserDelete)
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials to acces this api.",
@@ -402,8 +464,8 @@ func appHandleUserDelAdmin(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		res := backend.db.Delete(&table.User{}, body.UserID)
-		if res.Error != nil || res.RowsAffected <= 0 {
+		affected, err := softDeleteUser(backend.db, body.UserID)
+		if err != nil || affected <= 0 {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Failed to delete user from the DB.",
@@ -412,6 +474,10 @@ func appHandleUserDelAdmin(backend *Backend, route fiber.Router) {
 			})
 		}
 
+		if _, err := revokeUserSessions(backend.db, body.UserID); err != nil {
+			log.Printf("Failed to revoke the session of deleted user %d: %v", body.UserID, err)
+		}
+
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"success":    true,
 			"message":    "User deleted.",
@@ -424,7 +490,7 @@ func appHandleUserDelAdmin(backend *Backend, route fiber.Router) {
 // POST: api/protected/user-edit
 func appHandleUserEdit(backend *Backend, route fiber.Router) {
 	route.Post("/user-edit", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		currentUser, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -434,8 +500,6 @@ func appHandleUserEdit(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		email := claims["email"].(string)
-
 		var body struct {
 			FullName    *string `json:"name"`
 			Instance    *string `json:"instance"`
@@ -454,25 +518,7 @@ func appHandleUserEdit(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		var currentUser table.User
-		res := backend.db.Where("user_email = ?", email).First(&currentUser)
-		if res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"success":    false,
-					"message":    "The specified email is not registered.",
-					"error_code": 3,
-					"data":       nil,
-				})
-			}
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success":    false,
-				"message":    fmt.Sprintf("There is a problem on the backend when fetching the user, %v", res.Error),
-				"error_code": 4,
-				"data":       nil,
-			})
-		}
-
+		passwordChanged := false
 		if body.FullName != nil {
 			currentUser.UserFullName = *body.FullName
 		}
@@ -481,13 +527,24 @@ func appHandleUserEdit(backend *Backend, route fiber.Router) {
 			currentUser.UserInstance = *body.Instance
 		}
 
-		if body.Picture != nil {
+		// NOTE: Only the picture uploaded by this user (or none), the account
+		//       deletion remove that file so it cant point to anything else.
+		if body.Picture != nil && *body.Picture != currentUser.UserPicture {
+			if *body.Picture != "" && !isUserUpload(currentUser, staticFileFromURL(*body.Picture)) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "The picture has to be uploaded with user-upload-image first.",
+					"error_code": 8,
+					"data":       nil,
+				})
+			}
 			currentUser.UserPicture = *body.Picture
 		}
 
 		if (body.Password != nil && *body.Password != "") && (body.OldPassword != nil && *body.OldPassword != "") {
 
 			if !CheckPassword(currentUser.UserPassword, *body.OldPassword) {
+				recordSecurityEvent(backend, c, currentUser, currentUser.UserEmail, table.SecPasswordChange, "failed, wrong old password")
 				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 					"success":    false,
 					"message":    "Failed to change password because your password is not match.",
@@ -506,9 +563,10 @@ func appHandleUserEdit(backend *Backend, route fiber.Router) {
 				})
 			}
 			currentUser.UserPassword = hashedPassword
+			passwordChanged = true
 		}
 
-		result := backend.db.Save(&currentUser)
+		result := backend.db.Save(currentUser)
 		if result.Error != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -526,6 +584,9 @@ func appHandleUserEdit(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
+		if passwordChanged {
+			recordSecurityEvent(backend, c, currentUser, currentUser.UserEmail, table.SecPasswordChange, "by the user")
+		}
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"success":    true,
 			"message":    "Data modified.",
@@ -557,7 +618,7 @@ func appHandleUserInfoAll(backend *Backend, route fiber.Router) {
 			limit = 10000
 		}
 
-		claims, err := GetJWT(c)
+		_, err = GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -566,9 +627,9 @@ func appHandleUserInfoAll(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		admin := claims["admin"].(float64)
+		admin := userCan(backend, c, permUserRead)
 
-		if admin != 1 {
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials to acces this api.",
@@ -602,7 +663,7 @@ func appHandleUserInfoAll(backend *Backend, route fiber.Router) {
 func appHandleUserInfo(backend *Backend, route fiber.Router) {
 	route.Get("user-info", func(c *fiber.Ctx) error {
 
-		claims, err := GetJWT(c)
+		currentUser, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -611,24 +672,12 @@ func appHandleUserInfo(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		email := claims["email"].(string)
-
-		var userData table.User
-		res := backend.db.Where("user_email = ?", email).First(&userData)
-		if res.Error != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Failed to fetch user data from db.",
-				"error_code": 1,
-				"data":       nil,
-			})
-		}
 
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"success":    true,
 			"message":    "Success",
 			"error_code": 0,
-			"data":       userData,
+			"data":       currentUser,
 		})
 	})
 }
@@ -640,7 +689,7 @@ func appHandleUserUploadImage(backend *Backend, route fiber.Router) {
 			Data string `json:"data"`
 		}
 
-		claims, err := GetJWT(c)
+		currentUser, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
@@ -649,7 +698,6 @@ func appHandleUserUploadImage(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		email := claims["email"].(string)
 
 		err = c.BodyParser(&body)
 		if err != nil {
@@ -680,16 +728,6 @@ func appHandleUserUploadImage(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		username := strings.Split(email, "@")[0]
-		if username == "" {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"success":    false,
-				"message":    "Invalid email format",
-				"error_code": 5,
-				"data":       nil,
-			})
-		}
-
 		// Check if the string contains the base64 prefix and remove if present
 		base64Data := body.Data
 		if i := strings.Index(base64Data, ","); i != -1 {
@@ -717,7 +755,8 @@ func appHandleUserUploadImage(backend *Backend, route fiber.Router) {
 			fileExt = ".webp"
 		}
 
-		filename := fmt.Sprintf("%s/%s%s", imgDir, username, fileExt)
+		// NOTE: Named after the id, the email part is not unique (a@x and a@y).
+		filename := fmt.Sprintf("%s/%s%s", imgDir, userUploadName(currentUser), fileExt)
 
 		err = os.WriteFile(filename, imageData, 0644)
 		if err != nil {
@@ -801,34 +840,39 @@ func appHandleRegister(backend *Backend, route fiber.Router) {
 		}
 
 		// Do the OTP check.
-		var selOTP table.OTP
-		res = backend.db.Where("otp_code = ? AND user_email = ?", body.OTPCode, body.Email).First(&selOTP)
-		if res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		err = consumeOTP(backend, table.OTPRegister, body.Email, body.OTPCode)
+		if err != nil {
+			switch {
+			case errors.Is(err, errOTPInvalid):
 				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 					"success":    false,
 					"message":    "The specified OTP doesnt exist.",
 					"error_code": 10,
 					"data":       nil,
 				})
+			case errors.Is(err, errOTPExpired):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "The specified OTP is expired. Please request new code.",
+					"error_code": 11,
+					"data":       nil,
+				})
+			case errors.Is(err, errOTPTooMany):
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"success":    false,
+					"message":    "Too many wrong OTP, the code is no longer valid. Please request new code.",
+					"error_code": 12,
+					"data":       nil,
+				})
 			}
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
-				"message":    fmt.Sprintf("Failed to get the otp table, %v", res.Error),
+				"message":    fmt.Sprintf("Failed to get the otp table, %v", err),
 				"error_code": 9,
 				"data":       nil,
 			})
 		}
 
-		if IsOTPExpired(&selOTP) || selOTP.Used {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"success":    false,
-				"message":    "The specified OTP is expired. Please request new code.",
-				"error_code": 11,
-				"data":       nil,
-			})
-		}
-
 		hashedPassword, err := HashPassword(body.Password)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -849,18 +893,21 @@ func appHandleRegister(backend *Backend, route fiber.Router) {
 			UserCreatedAt: time.Now(),
 		}
 
-		result := backend.db.Create(&newUser)
-		if result.Error != nil {
+		err = backend.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newUser).Error; err != nil {
+				return err
+			}
+			return assignRole(tx, newUser.ID, roleParticipant)
+		})
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
-				"message":    fmt.Sprintf("Failed to write to db, %v", result.Error),
+				"message":    fmt.Sprintf("Failed to write to db, %v", err),
 				"error_code": 8,
 				"data":       nil,
 			})
 		}
 
-		selOTP.Used = true
-
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"success":    true,
 			"message":    "successfully created new user",
@@ -874,7 +921,7 @@ func appHandleRegister(backend *Backend, route fiber.Router) {
 func appHandleUserCount(backend *Backend, route fiber.Router) {
 	route.Get("/user-count", func(c *fiber.Ctx) error {
 
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -883,9 +930,9 @@ func appHandleUserCount(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		admin := claims["admin"].(float64)
+		admin := userCan(backend, c, permUserRead)
 
-		if admin != 1 {
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials to acces this api.",
@@ -915,7 +962,7 @@ func appHandleUserCount(backend *Backend, route fiber.Router) {
 // POST : api/protected/register-admin
 func appHandleRegisterAdmin(backend *Backend, route fiber.Router) {
 	route.Post("register-admin", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"success":    false,
@@ -924,9 +971,9 @@ func appHandleRegisterAdmin(backend *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
-		admin := claims["admin"].(float64)
+		admin := userCan(backend, c, permUserCreate)
 
-		if admin != 1 {
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials to acces this api.",
@@ -941,6 +988,7 @@ func appHandleRegisterAdmin(backend *Backend, route fiber.Router) {
 			Instance string `json:"instance"`
 			Picture  string `json:"picture"`
 			UserRole *int   `json:"user_role"`
+			Role     string `json:"role"`
 		}
 
 		err = c.BodyParser(&body)
@@ -991,6 +1039,39 @@ func appHandleRegisterAdmin(backend *Backend, route fiber.Router) {
 			})
 		}
 
+		// NOTE: `role` is the name of the role to give, without it the old
+		//       `user_role` still work (1 is super-admin, else participant).
+		//       Nothing given is a participant.
+		roleName := body.Role
+		if roleName == "" {
+			roleName = roleParticipant
+			if body.UserRole != nil && *body.UserRole == 1 {
+				roleName = roleSuperAdmin
+			}
+		}
+		useMe := 0
+		if roleName == roleSuperAdmin {
+			useMe = 1
+		}
+
+		callerPerms, err := requestPermissions(backend, c)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the permission, %v", err),
+				"error_code": 10,
+				"data":       nil,
+			})
+		}
+		if err := checkRoleGrant(backend.db, callerPerms, roleName); err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Cant create the user with that role, %v", err),
+				"error_code": 11,
+				"data":       nil,
+			})
+		}
+
 		hashedPassword, err := HashPassword(body.Password)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -1001,11 +1082,6 @@ func appHandleRegisterAdmin(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		useMe := 1
-		if body.UserRole != nil {
-			useMe = *body.UserRole
-		}
-
 		newUser := table.User{
 			UserFullName:  body.FullName,
 			UserEmail:     body.Email,
@@ -1016,11 +1092,16 @@ func appHandleRegisterAdmin(backend *Backend, route fiber.Router) {
 			UserCreatedAt: time.Now(),
 		}
 
-		result := backend.db.Create(&newUser)
-		if result.Error != nil {
+		err = backend.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&newUser).Error; err != nil {
+				return err
+			}
+			return assignRole(tx, newUser.ID, roleName)
+		})
+		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"success":    false,
-				"message":    fmt.Sprintf("Failed to write to db, %v", result.Error),
+				"message":    fmt.Sprintf("Failed to write to db, %v", err),
 				"error_code": 9,
 				"data":       nil,
 			})
@@ -1038,9 +1119,9 @@ func appHandleRegisterAdmin(backend *Backend, route fiber.Router) {
 // NOTE: Call this to logout (eg. delete the cookie)
 // POST : api/c/logout
 // POST : api/protected/logout
-func appHandleUserLogOut(_ *Backend, route fiber.Router) {
+func appHandleUserLogOut(backend *Backend, route fiber.Router) {
 	route.Post("logout", func(c *fiber.Ctx) error {
-		_, err := GetJWT(c)
+		claims, err := jwtClaims(c)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
@@ -1049,6 +1130,16 @@ func appHandleUserLogOut(_ *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
+
+		sid, _ := claims["sid"].(string)
+		if err := revokeSession(backend.db, sid); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to revoke the session, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
 		c.ClearCookie("jwt")
 
 		c.Cookie(&fiber.Cookie{
@@ -1068,7 +1159,7 @@ func appHandleUserLogOut(_ *Backend, route fiber.Router) {
 		})
 	})
 	route.Get("logout", func(c *fiber.Ctx) error {
-		_, err := GetJWT(c)
+		claims, err := jwtClaims(c)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
@@ -1077,6 +1168,16 @@ func appHandleUserLogOut(_ *Backend, route fiber.Router) {
 				"data":       nil,
 			})
 		}
+
+		sid, _ := claims["sid"].(string)
+		if err := revokeSession(backend.db, sid); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to revoke the session, %v", err),
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
 		c.ClearCookie("jwt")
 
 		c.Cookie(&fiber.Cookie{
@@ -1131,7 +1232,7 @@ func appHandleUserRegistered(backend *Backend, route fiber.Router) {
 // GET : api/protected/user-search
 func appHandleUserSearch(backend *Backend, route fiber.Router) {
 	route.Get("user-search", func(c *fiber.Ctx) error {
-		claims, err := GetJWT(c)
+		_, err := GetJWT(c)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
@@ -1141,8 +1242,8 @@ func appHandleUserSearch(backend *Backend, route fiber.Router) {
 			})
 		}
 
-		admin := claims["admin"].(float64)
-		if admin != 1 {
+		admin := userCan(backend, c, permUserRead)
+		if !admin {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"success":    false,
 				"message":    "Invalid credentials to access this api.",
@@ -1223,4 +1324,144 @@ func appHandleUserSearch(backend *Backend, route fiber.Router) {
 			},
 		})
 	})
-}
\ No newline at end of file
+}
+// NOTE: `data` is the base64 of the csv or xlsx file (a data url is fine), the
+//       header need `email` and `name`, `instance` and `role` (role name, default
+//       participant) is optional. With `dry_run` nothing is written and every
+//       row is checked. A file with any invalid row is not imported at all.
+//       `send_otp` mail every new user a code to set the password on
+//       api/user-reset-pass.
+// POST : api/protected/user-import
+func appHandleUserImport(backend *Backend, route fiber.Router) {
+	route.Post("user-import", requirePermission(backend, permUserCreate), func(c *fiber.Ctx) error {
+		var body struct {
+			Data    string `json:"data"`
+			Format  string `json:"format"`
+			DryRun  bool   `json:"dry_run"`
+			SendOTP bool   `json:"send_otp"`
+		}
+
+		err := c.BodyParser(&body)
+		if err != nil || body.Data == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid request body, need the file on data.",
+				"error_code": 1,
+				"data":       nil,
+			})
+		}
+
+		if body.Format == "" {
+			body.Format = "csv"
+			if strings.Contains(body.Data, "spreadsheetml") {
+				body.Format = "xlsx"
+			}
+		}
+		if body.Format != "csv" && body.Format != "xlsx" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid format, the only valid strings are : `csv` and `xlsx`",
+				"error_code": 2,
+				"data":       nil,
+			})
+		}
+
+		base64Data := body.Data
+		if i := strings.Index(base64Data, ","); i != -1 {
+			base64Data = base64Data[i+1:]
+		}
+		fileData, err := base64.StdEncoding.DecodeString(base64Data)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    "Invalid base64 file data",
+				"error_code": 3,
+				"data":       nil,
+			})
+		}
+
+		records, err := parseImportRows(fileData, body.Format)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to read the file, %v", err),
+				"error_code": 4,
+				"data":       nil,
+			})
+		}
+
+		callerPerms, err := requestPermissions(backend, c)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the permission, %v", err),
+				"error_code": 8,
+				"data":       nil,
+			})
+		}
+
+		rows, invalid, err := validateImportRows(backend.db, callerPerms, records)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to check the file, %v", err),
+				"error_code": 5,
+				"data":       nil,
+			})
+		}
+
+		invalidRows := make([]importRow, 0, invalid)
+		for _, row := range rows {
+			if len(row.Errors) > 0 {
+				invalidRows = append(invalidRows, row)
+			}
+		}
+		report := fiber.Map{
+			"dry_run": body.DryRun,
+			"total":   len(rows),
+			"valid":   len(rows) - invalid,
+			"invalid": invalid,
+			"errors":  invalidRows,
+		}
+
+		if body.DryRun {
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"success":    true,
+				"message":    "Dry run, nothing is imported.",
+				"error_code": 0,
+				"data":       report,
+			})
+		}
+
+		if invalid > 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("%d row is invalid, nothing is imported.", invalid),
+				"error_code": 6,
+				"data":       report,
+			})
+		}
+
+		err = importUsers(backend, rows, body.SendOTP)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success":    false,
+				"message":    fmt.Sprintf("Failed to import, nothing is imported, %v", err),
+				"error_code": 7,
+				"data":       nil,
+			})
+		}
+		if body.SendOTP {
+			wakeOutbox(backend)
+		}
+
+		report["created"] = len(rows)
+		report["otp_sent"] = body.SendOTP
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success":    true,
+			"message":    fmt.Sprintf("%d user imported.", len(rows)),
+			"error_code": 0,
+			"data":       report,
+		})
+	})
+}
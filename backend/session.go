@@ -0,0 +1,309 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/golang-jwt/jwt/v5"
+    "gorm.io/gorm"
+)
+
+// NOTE: The access token is the jwt that every protected api take, it is short
+//       so a revoked session didnt stay usable for long. The refresh token is
+//       what keep the user logged in.
+const (
+    accessTokenTTL    = 15 * time.Minute
+    refreshTokenTTL   = 7 * 24 * time.Hour
+    refreshReuseGrace = 30 * time.Second
+)
+
+var (
+    errSessionInvalid = errors.New("invalid refresh token")
+    errSessionRevoked = errors.New("session is revoked or expired")
+    errSessionReused  = errors.New("refresh token already used, session revoked")
+)
+
+func randomHex(n int) (string, error) {
+    b := make([]byte, n)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(b), nil
+}
+
+func hashRefreshSecret(secret string) string {
+    sum := sha256.Sum256([]byte(secret))
+    return hex.EncodeToString(sum[:])
+}
+
+// NOTE: The next secret come from the current one (and the server secret), so
+//       a refresh that arrive a bit late with the same token get the exact
+//       same new pair instead of a second one.
+func nextRefreshSecret(backend *Backend, sid string, secret string) string {
+    mac := hmac.New(sha256.New, []byte(backend.pass+":refresh"))
+    mac.Write([]byte(sid + "." + secret))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NOTE: `sub` is the user id, the backend only trust that (and the session). The
+//       `email` and `admin` is only for the frontend, to show the user and the
+//       admin panel, the backend check the permission itself.
+func signAccessToken(backend *Backend, user *table.User, sid string) (string, error) {
+    panel, err := userHasPermission(backend.db, user.ID, permAdminPanel)
+    if err != nil {
+        return "", err
+    }
+    admin := 0
+    if panel {
+        admin = 1
+    }
+
+    claims := jwt.MapClaims{
+        "sub":   strconv.Itoa(user.ID),
+        "email": user.UserEmail,
+        "admin": admin,
+        "sid":   sid,
+        "exp":   time.Now().Add(accessTokenTTL).Unix(),
+    }
+
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    return token.SignedString([]byte(backend.pass))
+}
+
+// NOTE: Make a new session for the user and return the access and refresh token.
+func createSession(backend *Backend, c *fiber.Ctx, user *table.User) (string, string, error) {
+    sid, err := randomHex(16)
+    if err != nil {
+        return "", "", err
+    }
+    secret, err := randomHex(32)
+    if err != nil {
+        return "", "", err
+    }
+
+    now := time.Now()
+    session := table.Session{
+        UserId:             user.ID,
+        SessionSID:         sid,
+        SessionRefreshHash: hashRefreshSecret(secret),
+        SessionExpires:     now.Add(refreshTokenTTL),
+        SessionLastUsed:    now,
+        SessionIP:          c.IP(),
+        SessionAgent:       c.Get(fiber.HeaderUserAgent),
+    }
+    if err := backend.db.Create(&session).Error; err != nil {
+        return "", "", err
+    }
+
+    access, err := signAccessToken(backend, user, sid)
+    if err != nil {
+        return "", "", err
+    }
+    return access, sid + "." + secret, nil
+}
+
+// NOTE: Every way to login end here so the password and the single sign-on login
+//       give the exact same token, cookie and response. `errCode` is the error code
+//       of the caller when the session cant be made.
+func loginResponse(backend *Backend, c *fiber.Ctx, user *table.User, errCode int) error {
+    return loginResponseWith(backend, c, user, errCode, nil)
+}
+
+// NOTE: Same as loginResponse, `extra` is added to the response.
+func loginResponseWith(backend *Backend, c *fiber.Ctx, user *table.User, errCode int, extra fiber.Map) error {
+    t, refresh, err := createSession(backend, c, user)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "success":    false,
+            "message":    fmt.Sprintf("Failed to generate JWT, %v", err),
+            "error_code": errCode,
+            "data":       nil,
+        })
+    }
+    recordSecurityEvent(backend, c, user, user.UserEmail, table.SecLoginSuccess, loginMethod(c))
+
+    c.Cookie(&fiber.Cookie{
+        Name:     "jwt",
+        Value:    t,
+        HTTPOnly: true,
+        Secure:   false,
+        SameSite: "Lax",
+        Expires:  time.Now().Add(accessTokenTTL),
+    })
+
+    response := fiber.Map{
+        "success":       true,
+        "message":       "successfully logged in.",
+        "data":          user,
+        "error_code":    0,
+        "token":         t,
+        "refresh_token": refresh,
+    }
+    for key, value := range extra {
+        response[key] = value
+    }
+    return c.Status(fiber.StatusOK).JSON(response)
+}
+
+// NOTE: Swap the refresh token for a new pair. Using an old refresh token again
+//       mean it got stolen (or the client is broken) so the whole session is killed.
+//       The exception is the token right before the current one for
+//       refreshReuseGrace after the swap, a page load send a few request at once
+//       and each of them refresh with the same token.
+func rotateSession(backend *Backend, c *fiber.Ctx, refreshToken string) (string, string, error) {
+    sid, secret, found := strings.Cut(refreshToken, ".")
+    if !found || sid == "" || secret == "" {
+        return "", "", errSessionInvalid
+    }
+
+    var access, newRefresh string
+    err := backend.db.Transaction(func(tx *gorm.DB) error {
+        var session table.Session
+        res := tx.Preload("User").Where("session_sid = ?", sid).First(&session)
+        if res.Error != nil {
+            if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+                return errSessionInvalid
+            }
+            return res.Error
+        }
+
+        now := time.Now()
+        if session.SessionRevoked != nil || now.After(session.SessionExpires) || session.User.ID == 0 {
+            return errSessionRevoked
+        }
+
+        hash := hashRefreshSecret(secret)
+        newSecret := nextRefreshSecret(backend, sid, secret)
+        updates := map[string]any{
+            "session_expires":   now.Add(refreshTokenTTL),
+            "session_last_used": now,
+            "session_ip":        c.IP(),
+            "session_agent":     c.Get(fiber.HeaderUserAgent),
+        }
+        switch {
+        case subtle.ConstantTimeCompare([]byte(hash), []byte(session.SessionRefreshHash)) == 1:
+            updates["session_refresh_hash"] = hashRefreshSecret(newSecret)
+            updates["session_prev_hash"] = hash
+            updates["session_rotated_at"] = now
+        case session.SessionPrevHash != "" &&
+            subtle.ConstantTimeCompare([]byte(hash), []byte(session.SessionPrevHash)) == 1 &&
+            session.SessionRotatedAt != nil && now.Sub(*session.SessionRotatedAt) <= refreshReuseGrace:
+            // Same token as the request that just rotated, it get the same pair.
+        default:
+            return errSessionReused
+        }
+
+        res = tx.Model(&session).Updates(updates)
+        if res.Error != nil {
+            return res.Error
+        }
+
+        var err error
+        access, err = signAccessToken(backend, &session.User, sid)
+        if err != nil {
+            return err
+        }
+        newRefresh = sid + "." + newSecret
+        return nil
+    })
+    if errors.Is(err, errSessionReused) {
+        // Outside the transaction, returning the error there roll it back.
+        if rerr := revokeSession(backend.db, sid); rerr != nil {
+            return "", "", rerr
+        }
+    }
+    if err != nil {
+        return "", "", err
+    }
+    return access, newRefresh, nil
+}
+
+func revokeSession(db *gorm.DB, sid string) error {
+    return db.Model(&table.Session{}).
+        Where("session_sid = ? AND session_revoked IS NULL", sid).
+        Update("session_revoked", time.Now()).Error
+}
+
+func revokeUserSessions(db *gorm.DB, userID int) (int64, error) {
+    res := db.Model(&table.Session{}).
+        Where("user_id = ? AND session_revoked IS NULL", userID).
+        Update("session_revoked", time.Now())
+    return res.RowsAffected, res.Error
+}
+
+// NOTE: The token from before `sub` was added only has the email, it is still
+//       taken until it expire as long as the email is still the one of the user.
+func claimsMatchUser(claims jwt.MapClaims, user *table.User) bool {
+    if sub, err := claims.GetSubject(); err == nil && sub != "" {
+        return sub == strconv.Itoa(user.ID)
+    }
+    email, ok := claims["email"].(string)
+    return ok && strings.EqualFold(email, user.UserEmail)
+}
+
+// NOTE: Run right after the jwt middleware on the protected group. The jwt
+//       only prove the token is signed by us, this check the session behind
+//       it is still alive and the user is not deleted. The user is loaded
+//       here once, the handler get it with GetJWT.
+func sessionMiddleware(backend *Backend) fiber.Handler {
+    return func(c *fiber.Ctx) error {
+        if isApiTokenRequest(c) {
+            return c.Next()
+        }
+
+        claims, err := jwtClaims(c)
+        sid, ok := claims["sid"].(string)
+        if err != nil || !ok || sid == "" {
+            return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                "success":    false,
+                "message":    "Invalid session, please login again.",
+                "error_code": -1,
+                "data":       nil,
+            })
+        }
+
+        var session table.Session
+        res := backend.db.
+            InnerJoins("User").
+            Where("session_sid = ? AND session_revoked IS NULL AND session_expires > ?", sid, time.Now()).
+            First(&session)
+        if res.Error != nil {
+            if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+                return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                    "success":    false,
+                    "message":    "The session is revoked, please login again.",
+                    "error_code": -1,
+                    "data":       nil,
+                })
+            }
+            return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+                "success":    false,
+                "message":    "Failed to check the session.",
+                "error_code": -1,
+                "data":       nil,
+            })
+        }
+        if !claimsMatchUser(claims, &session.User) {
+            return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+                "success":    false,
+                "message":    "Invalid session, please login again.",
+                "error_code": -1,
+                "data":       nil,
+            })
+        }
+
+        c.Locals("user_id", session.UserId)
+        c.Locals("current_user", &session.User)
+        return c.Next()
+    }
+}
@@ -0,0 +1,26 @@
+package table
+
+import (
+    "time"
+    "gorm.io/gorm"
+)
+
+// NOTE: Personal access token for script and integration. Only the hash is kept,
+//       `TokenPrefix` is the start of the token so the user can tell them apart.
+//       `TokenScopes` is the permission name joined by a comma, `*` for every
+//       permission the user has.
+type ApiToken struct {
+    gorm.Model
+    ID            int        `gorm:"primaryKey"`
+    UserId        int        `gorm:"column:user_id;index"`
+    TokenName     string     `gorm:"column:token_name"`
+    TokenPrefix   string     `gorm:"column:token_prefix"`
+    TokenHash     string     `gorm:"column:token_hash;uniqueIndex" json:"-"`
+    TokenScopes   string     `gorm:"column:token_scopes"`
+    TokenExpires  *time.Time `gorm:"column:token_expires;type:datetime"`
+    TokenLastUsed *time.Time `gorm:"column:token_last_used;type:datetime"`
+    TokenLastIP   string     `gorm:"column:token_last_ip"`
+    TokenRevoked  *time.Time `gorm:"column:token_revoked;type:datetime"`
+
+    User User `gorm:"foreignKey:UserId" json:"-"`
+}
@@ -19,6 +19,16 @@ type EventParticipant struct {
     EventPRole   UserEventRoleEnum `gorm:"column:eventp_role"`
     EventPCome   bool              `gorm:"column:eventp_come"`
     EventPCode   string            `gorm:"column:eventp_code"`
+    EventPWait   bool              `gorm:"column:eventp_wait"`
+    EventPCertSent bool            `gorm:"column:eventp_cert_sent"`
+
+    // NOTE: Only mean something for committee, the lead can do everything
+    //       and can grant the rest to the other committee of the event.
+    EventPLead             bool `gorm:"column:eventp_lead"`
+    EventPPermCheckIn      bool `gorm:"column:eventp_perm_checkin"`
+    EventPPermEditEvent    bool `gorm:"column:eventp_perm_edit_event"`
+    EventPPermCert         bool `gorm:"column:eventp_perm_cert"`
+    EventPPermParticipants bool `gorm:"column:eventp_perm_participants"`
 
     Event        Event  `gorm:"foreignKey:EventId"`
     User         User   `gorm:"foreignKey:UserId"`
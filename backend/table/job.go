@@ -0,0 +1,48 @@
+package table
+
+import (
+    "time"
+    "gorm.io/gorm"
+)
+
+type JobStatus string
+
+const (
+    JobRunning JobStatus = "running"
+    JobSuccess JobStatus = "success"
+    JobFailed  JobStatus = "failed"
+)
+
+type JobTrigger string
+
+const (
+    JobTriggerSchedule JobTrigger = "schedule"
+    JobTriggerManual   JobTrigger = "manual"
+)
+
+// NOTE: One row per background job, the schedule itself is in the code. The lock
+//       make sure only one run of a job at a time, it is free again after
+//       JobLockedUntil even when the server died in the middle of the run.
+type ScheduledJob struct {
+    gorm.Model
+    ID             int        `gorm:"primaryKey"`
+    JobName        string     `gorm:"column:job_name;uniqueIndex"`
+    JobLockedBy    string     `gorm:"column:job_locked_by"`
+    JobLockedUntil *time.Time `gorm:"column:job_locked_until;type:datetime"`
+    JobLastRun     *time.Time `gorm:"column:job_last_run;type:datetime"`
+    JobLastStatus  JobStatus  `gorm:"column:job_last_status"`
+    JobLastError   string     `gorm:"column:job_last_error"`
+    JobNextRun     *time.Time `gorm:"column:job_next_run;type:datetime"`
+}
+
+// NOTE: The history of every run, JobError is empty when it went fine.
+type JobRun struct {
+    gorm.Model
+    ID          int        `gorm:"primaryKey"`
+    JobName     string     `gorm:"column:job_name;index"`
+    JobTrigger  JobTrigger `gorm:"column:job_trigger"`
+    JobStatus   JobStatus  `gorm:"column:job_status"`
+    JobError    string     `gorm:"column:job_error"`
+    JobStarted  time.Time  `gorm:"column:job_started;type:datetime"`
+    JobFinished *time.Time `gorm:"column:job_finished;type:datetime"`
+}
@@ -0,0 +1,25 @@
+package table
+
+import (
+    "time"
+    "gorm.io/gorm"
+)
+
+type LoginThrottleKind string
+
+const (
+    ThrottleAccount LoginThrottleKind = "account"
+    ThrottleIP      LoginThrottleKind = "ip"
+)
+
+// NOTE: The failed login of one email (even when the user doesnt exist) or one ip.
+//       The count start again after a success (account only) or a quiet while.
+type LoginThrottle struct {
+    gorm.Model
+    ID               int               `gorm:"primaryKey"`
+    ThrottleKind     LoginThrottleKind `gorm:"column:throttle_kind;uniqueIndex:idx_throttle_key"`
+    ThrottleKey      string            `gorm:"column:throttle_key;uniqueIndex:idx_throttle_key"`
+    ThrottleFailures int               `gorm:"column:throttle_failures"`
+    ThrottleLastFail time.Time         `gorm:"column:throttle_last_fail;type:datetime"`
+    ThrottleLocked   *time.Time        `gorm:"column:throttle_locked_until;type:datetime"`
+}
@@ -5,13 +5,29 @@ import (
     "gorm.io/gorm"
 )
 
+type OTPPurpose string
+
+const (
+    OTPRegister    OTPPurpose = "register"
+    OTPReset       OTPPurpose = "reset"
+    OTPEmailChange OTPPurpose = "email-change"
+    OTPLogin       OTPPurpose = "login"
+)
+
 // Change UserId to UserEmail so it work...
 // Added time TimeCreated
+// NOTE: OtpCode is the HMAC of the code, the code itself is only in the email.
+//       A code only work for the purpose it was made for.
 type OTP struct {
     gorm.Model
-    ID          int       `gorm:"primaryKey"`
-    UserEmail   string    `gorm:"column:user_email"`
-    OtpCode     string    `gorm:"column:otp_code"`
-    TimeCreated time.Time `gorm:"column:time_created"`
-    Used        bool      `gorm:"column:used"`
+    ID          int        `gorm:"primaryKey"`
+    UserEmail   string     `gorm:"column:user_email;index:idx_otp_email_purpose"`
+    OtpPurpose  OTPPurpose `gorm:"column:otp_purpose;index:idx_otp_email_purpose"`
+    OtpCode     string     `gorm:"column:otp_code" json:"-"`
+    TimeCreated time.Time  `gorm:"column:time_created"`
+    Used        bool       `gorm:"column:used"`
+    OtpAttempts int        `gorm:"column:otp_attempts"`
+    // NOTE: Only for the code that live longer than the default, eg. the set
+    //       password code of an imported user. Empty mean the default expiry.
+    OtpExpiresAt *time.Time `gorm:"column:otp_expires_at;type:datetime"`
 }
@@ -0,0 +1,17 @@
+package table
+
+import (
+    "gorm.io/gorm"
+)
+
+// NOTE: One call of api/gen-otp-for-register that passed the proof of work and
+//       the limit, even when nothing is sent, or an email change request. The
+//       cooldown and the daily cap are counted from here. A challenge can only
+//       be used once.
+type OTPRequest struct {
+    gorm.Model
+    ID           int    `gorm:"primaryKey"`
+    ReqEmail     string `gorm:"column:req_email;index"`
+    ReqIP        string `gorm:"column:req_ip;index"`
+    ReqChallenge string `gorm:"column:req_challenge;uniqueIndex"`
+}
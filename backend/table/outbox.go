@@ -0,0 +1,28 @@
+package table
+
+import (
+    "time"
+    "gorm.io/gorm"
+)
+
+type OutboxStatusEnum string
+
+const (
+    OutboxPending OutboxStatusEnum = "pending"
+    OutboxSent    OutboxStatusEnum = "sent"
+    OutboxDead    OutboxStatusEnum = "dead"
+)
+
+type EmailOutbox struct {
+    gorm.Model
+    ID          int              `gorm:"primaryKey"`
+    MailTo      string           `gorm:"column:mail_to"`
+    MailSubject string           `gorm:"column:mail_subject"`
+    MailText    string           `gorm:"column:mail_text;type:text"`
+    MailHTML    string           `gorm:"column:mail_html;type:text"`
+    Status      OutboxStatusEnum `gorm:"column:status;index"`
+    Attempts    int              `gorm:"column:attempts"`
+    NextAttempt time.Time        `gorm:"column:next_attempt;type:datetime;index"`
+    LastError   string           `gorm:"column:last_error"`
+    SentAt      *time.Time       `gorm:"column:sent_at;type:datetime"`
+}
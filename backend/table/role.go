@@ -0,0 +1,34 @@
+package table
+
+import (
+    "gorm.io/gorm"
+)
+
+// NOTE: Replace the old UserRole int (1 = admin). The builtin role is created
+//       on every start and cant be deleted, the custom one can.
+type Role struct {
+    gorm.Model
+    ID          int          `gorm:"primaryKey"`
+    RoleName    string       `gorm:"column:role_name;uniqueIndex"`
+    RoleDesc    string       `gorm:"column:role_desc"`
+    RoleBuiltin bool         `gorm:"column:role_builtin"`
+
+    Permissions []Permission `gorm:"many2many:role_permissions;"`
+}
+
+type Permission struct {
+    gorm.Model
+    ID       int    `gorm:"primaryKey"`
+    PermName string `gorm:"column:perm_name;uniqueIndex"`
+    PermDesc string `gorm:"column:perm_desc"`
+}
+
+type RoleAssignment struct {
+    gorm.Model
+    ID     int  `gorm:"primaryKey"`
+    UserId int  `gorm:"column:user_id;uniqueIndex:idx_role_assignment"`
+    RoleId int  `gorm:"column:role_id;uniqueIndex:idx_role_assignment"`
+
+    User   User `gorm:"foreignKey:UserId" json:"-"`
+    Role   Role `gorm:"foreignKey:RoleId"`
+}
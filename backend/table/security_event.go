@@ -0,0 +1,30 @@
+package table
+
+import (
+    "gorm.io/gorm"
+)
+
+type SecurityEventKind string
+
+const (
+    SecLoginSuccess   SecurityEventKind = "login_success"
+    SecLoginFailed    SecurityEventKind = "login_failed"
+    SecLoginLocked    SecurityEventKind = "login_locked"
+    SecPasswordReset  SecurityEventKind = "password_reset"
+    SecPasswordChange SecurityEventKind = "password_change"
+    SecEmailChange    SecurityEventKind = "email_change"
+)
+
+// NOTE: One entry of the security log. UserId is 0 when the login used an
+//       email that doesnt exist, the email is kept as it was typed.
+type SecurityEvent struct {
+    gorm.Model
+    ID          int               `gorm:"primaryKey"`
+    UserId      int               `gorm:"column:user_id;index"`
+    SecKind     SecurityEventKind `gorm:"column:sec_kind;index"`
+    SecEmail    string            `gorm:"column:sec_email"`
+    SecIP       string            `gorm:"column:sec_ip"`
+    SecAgent    string            `gorm:"column:sec_agent"`
+    SecDetail   string            `gorm:"column:sec_detail"`
+    SecNewDevice bool             `gorm:"column:sec_new_device"`
+}
@@ -0,0 +1,27 @@
+package table
+
+import (
+    "time"
+    "gorm.io/gorm"
+)
+
+// NOTE: One login (one device). The refresh token is `<sid>.<secret>` and only
+//       the hash of the current secret is kept, the old one stop working after
+//       every refresh. The hash of the one before is kept for a few second so
+//       parallel refresh with the same token didnt look like a reuse.
+type Session struct {
+    gorm.Model
+    ID                 int        `gorm:"primaryKey"`
+    UserId             int        `gorm:"column:user_id;index"`
+    SessionSID         string     `gorm:"column:session_sid;uniqueIndex"`
+    SessionRefreshHash string     `gorm:"column:session_refresh_hash" json:"-"`
+    SessionPrevHash    string     `gorm:"column:session_prev_hash" json:"-"`
+    SessionRotatedAt   *time.Time `gorm:"column:session_rotated_at;type:datetime" json:"-"`
+    SessionExpires     time.Time  `gorm:"column:session_expires;type:datetime"`
+    SessionLastUsed    time.Time  `gorm:"column:session_last_used;type:datetime"`
+    SessionRevoked     *time.Time `gorm:"column:session_revoked;type:datetime"`
+    SessionIP          string     `gorm:"column:session_ip"`
+    SessionAgent       string     `gorm:"column:session_agent"`
+
+    User User `gorm:"foreignKey:UserId" json:"-"`
+}
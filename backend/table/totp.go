@@ -0,0 +1,31 @@
+package table
+
+import (
+    "time"
+    "gorm.io/gorm"
+)
+
+// NOTE: One per user. The secret is saved on enroll but only count after it is
+//       confirmed with a code, `TOTPLastStep` stop the same code from being used twice.
+type UserTOTP struct {
+    gorm.Model
+    ID              int        `gorm:"primaryKey"`
+    UserId          int        `gorm:"column:user_id;uniqueIndex"`
+    TOTPSecret      string     `gorm:"column:totp_secret" json:"-"`
+    TOTPEnabled     bool       `gorm:"column:totp_enabled"`
+    TOTPConfirmedAt *time.Time `gorm:"column:totp_confirmed_at;type:datetime"`
+    TOTPLastStep    int64      `gorm:"column:totp_last_step" json:"-"`
+
+    User User `gorm:"foreignKey:UserId" json:"-"`
+}
+
+// NOTE: Only the hash is kept, the code is shown once when it is made.
+type TOTPRecoveryCode struct {
+    gorm.Model
+    ID       int        `gorm:"primaryKey"`
+    UserId   int        `gorm:"column:user_id;index"`
+    CodeHash string     `gorm:"column:code_hash" json:"-"`
+    UsedAt   *time.Time `gorm:"column:used_at;type:datetime"`
+
+    User User `gorm:"foreignKey:UserId" json:"-"`
+}
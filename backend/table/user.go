@@ -16,6 +16,14 @@ type User struct {
     UserRole       int       `gorm:"column:user_role"`
     UserPicture    string    `gorm:"column:user_picture"`
     UserCreatedAt  time.Time `gorm:"column:user_created_at;type:datetime"`
+    // NOTE: The `sub` of the identity provider, set on the first single sign-on login.
+    UserOidcSubject string   `gorm:"column:user_oidc_subject;index" json:"-"`
+    // NOTE: Set by the admin, the user cant login without the second factor.
+    UserTOTPRequired bool     `gorm:"column:user_totp_required"`
+    // NOTE: When the user asked for the account to be deleted, anonymized after this.
+    UserDeleteAt   *time.Time `gorm:"column:user_delete_at;type:datetime;index"`
+    // NOTE: The new email waiting for the OTP sent to it, empty when there is none.
+    UserPendingEmail string   `gorm:"column:user_pending_email"`
 
     EventParticipants []EventParticipant `gorm:"foreignKey:UserId"`
 }
@@ -20,3 +20,10 @@ if __name__ == "__main__":
         desc="Test accessing the editor."
     )
     test2.test(0)
+
+    test3 = TestApi.TestApi(
+        "certificate-verify/this-code-does-not-exist",
+        method="get",
+        desc="Test verifying a certificate that doesnt exist, no login needed."
+    )
+    test3.test(4)
@@ -56,6 +56,34 @@ if __name__ == "__main__":
         desc="Test edit webinar with valid payload, should return error_code 0.",
     )
     edit_webinar_success.test(0)
+
+    edit_webinar_no_limit = debug(
+        "protected/event-edit",
+        method="POST",
+        headers={
+            "Authorization": f"Bearer {admin_token}",
+        },
+        payload={
+            "id": 13,
+            "max": 0,
+        },
+        desc="Test set the max of a webinar back to 0 (no limit), should return error_code 0.",
+    )
+    edit_webinar_no_limit.test(0)
+
+    edit_webinar_negative_max = debug(
+        "protected/event-edit",
+        method="POST",
+        headers={
+            "Authorization": f"Bearer {admin_token}",
+        },
+        payload={
+            "id": 13,
+            "max": -1,
+        },
+        desc="Test edit webinar with a negative max, should return error_code 10.",
+    )
+    edit_webinar_negative_max.test(10)
     
     # 3. Test deleting a webinar with valid payload
     delete_webinar_success = debug(
@@ -120,3 +148,38 @@ if __name__ == "__main__":
         desc="Test get total webinar count, should return error_code 0.",
     )
     get_total_webinar_count_success.test(0)
+
+    # 8. Test the trash
+    get_trash_success = debug(
+        "protected/trash-list?type=event",
+        method="GET",
+        headers={
+            "Authorization": f"Bearer {admin_token}",
+        },
+        desc="Test list the deleted webinar, should return error_code 0.",
+    )
+    get_trash_success.test(0)
+
+    get_trash_invalid_type = debug(
+        "protected/trash-list?type=nothing",
+        method="GET",
+        headers={
+            "Authorization": f"Bearer {admin_token}",
+        },
+        desc="Test list the trash with an invalid type, should return error_code 1.",
+    )
+    get_trash_invalid_type.test(1)
+
+    restore_not_deleted = debug(
+        "protected/trash-restore",
+        method="POST",
+        headers={
+            "Authorization": f"Bearer {admin_token}",
+        },
+        payload={
+            "type": "event",
+            "id": 999999,
+        },
+        desc="Test restore a webinar that is not in the trash, should return error_code 3.",
+    )
+    restore_not_deleted.test(3)
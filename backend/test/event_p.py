@@ -130,3 +130,34 @@ if __name__ == "__main__":
         desc="Test get absence status of a participant in a webinar (Online), should return error_code 0.",
     )
     get_absence_status_online_success.test(0)
+    
+    # 10. Test check in a participant from the scanned QR payload on the wrong event
+    checkin_wrong_event_failed = debug(
+        "protected/event-participate-checkin",
+        method="POST",
+        headers={
+            "Authorization": f"Bearer {admin_token}"
+        },
+        payload={
+            "id": 7, # Make sure this is another event than the code belong to
+            "code": "Y29tbXJhZGVAZXhhbXBsZS5jb20tNi0xLTE=", # The EventPCode from the QR
+        },
+        desc="Test check in with a code of another webinar, should return error_code 8.",
+    )
+    checkin_wrong_event_failed.test(8)
+    
+    # 11. Test set the committee permission on a normal participant
+    committee_perm_normal_failed = debug(
+        "protected/event-participate-committee-perm",
+        method="POST",
+        headers={
+            "Authorization": f"Bearer {admin_token}"
+        },
+        payload={
+            "event_id": 6,
+            "email": "commrade@example.com", # Make sure this is a normal participant
+            "checkin": True,
+        },
+        desc="Test set committee permission on a normal participant, should return error_code 7.",
+    )
+    committee_perm_normal_failed.test(7)
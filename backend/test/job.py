@@ -0,0 +1,46 @@
+import TestApi
+import utils
+
+debug = TestApi.TestApi
+
+if __name__ == "__main__":
+
+    admin_token = utils.login("admin@wowadmin.com", "secret")
+    headers = { "Authorization": f"Bearer {admin_token}", "Content-Type": "application/json" }
+
+    debug(
+        "protected/job-list",
+        method="GET",
+        headers=headers,
+        desc="Test admin can see every background job, it should return error_code 0."
+    ).test(0)
+
+    debug(
+        "protected/job-run",
+        method="POST",
+        headers=headers,
+        payload={ "name": "otp-cleanup" },
+        desc="Test admin can run a job by hand, it should return error_code 0."
+    ).test(0)
+
+    debug(
+        "protected/job-run",
+        method="POST",
+        headers=headers,
+        payload={ "name": "not-a-job" },
+        desc="Test running a job that doesnt exist, it should return error_code 2."
+    ).test(2)
+
+    debug(
+        "protected/job-history?name=otp-cleanup",
+        method="GET",
+        headers=headers,
+        desc="Test admin can see the run of a job, it should return error_code 0."
+    ).test(0)
+
+    debug(
+        "protected/job-history?status=done",
+        method="GET",
+        headers=headers,
+        desc="Test the job history with an invalid status, it should return error_code 2."
+    ).test(2)
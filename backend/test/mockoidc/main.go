@@ -0,0 +1,246 @@
+// A tiny OpenID Connect issuer for testing the single sign-on without a real
+// identity provider. Every login is approved right away as `-email` (or the
+// `login_hint` of the request), nothing here is safe for production. The
+// `email_verified` of the request (true, false or omit) override `-verified`.
+//
+//     go run ./test/mockoidc -addr 127.0.0.1:9999
+//     WRPL_OIDC_ISSUER=http://127.0.0.1:9999 WRPL_OIDC_CLIENT_ID=webrpl \
+//     WRPL_OIDC_CLIENT_SECRET=secret \
+//     WRPL_OIDC_REDIRECT_URL=http://localhost:3000/api/oidc/callback ./webrpl
+package main
+
+import (
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "log"
+    "math/big"
+    "net/http"
+    "net/url"
+    "sync"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+type authCode struct {
+    clientID    string
+    redirectURI string
+    nonce       string
+    challenge   string
+    email       string
+    verified    any
+    created     time.Time
+}
+
+type mockIssuer struct {
+    issuer       string
+    clientID     string
+    clientSecret string
+    email        string
+    name         string
+    verified     bool
+    key          *rsa.PrivateKey
+
+    mutex sync.Mutex
+    codes map[string]authCode
+}
+
+func randomString() string {
+    b := make([]byte, 16)
+    if _, err := rand.Read(b); err != nil {
+        log.Fatal(err)
+    }
+    return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, map[string]any{
+        "issuer":                                m.issuer,
+        "authorization_endpoint":                m.issuer + "/authorize",
+        "token_endpoint":                        m.issuer + "/token",
+        "jwks_uri":                              m.issuer + "/jwks",
+        "response_types_supported":              []string{"code"},
+        "subject_types_supported":               []string{"public"},
+        "id_token_signing_alg_values_supported": []string{"RS256"},
+        "code_challenge_methods_supported":      []string{"S256"},
+    })
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+    pub := m.key.PublicKey
+    writeJSON(w, http.StatusOK, map[string]any{
+        "keys": []map[string]string{{
+            "kty": "RSA",
+            "use": "sig",
+            "alg": "RS256",
+            "kid": "mock",
+            "n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+            "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+        }},
+    })
+}
+
+func (m *mockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    if q.Get("client_id") != m.clientID || q.Get("response_type") != "code" {
+        http.Error(w, "invalid client_id or response_type", http.StatusBadRequest)
+        return
+    }
+    if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+        http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+        return
+    }
+    redirect, err := url.Parse(q.Get("redirect_uri"))
+    if err != nil || redirect.Scheme == "" {
+        http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+        return
+    }
+
+    email := m.email
+    if hint := q.Get("login_hint"); hint != "" {
+        email = hint
+    }
+    var verified any = m.verified
+    switch q.Get("email_verified") {
+    case "true":
+        verified = true
+    case "false":
+        verified = false
+    case "omit":
+        verified = nil
+    }
+
+    code := randomString()
+    m.mutex.Lock()
+    m.codes[code] = authCode{
+        clientID:    q.Get("client_id"),
+        redirectURI: q.Get("redirect_uri"),
+        nonce:       q.Get("nonce"),
+        challenge:   q.Get("code_challenge"),
+        email:       email,
+        verified:    verified,
+        created:     time.Now(),
+    }
+    m.mutex.Unlock()
+
+    back := redirect.Query()
+    back.Set("code", code)
+    back.Set("state", q.Get("state"))
+    redirect.RawQuery = back.Encode()
+    http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+    if err := r.ParseForm(); err != nil {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+        return
+    }
+
+    clientID, clientSecret, ok := r.BasicAuth()
+    if !ok {
+        clientID = r.PostForm.Get("client_id")
+        clientSecret = r.PostForm.Get("client_secret")
+    }
+    if clientID != m.clientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(m.clientSecret)) != 1 {
+        writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+        return
+    }
+
+    m.mutex.Lock()
+    code, found := m.codes[r.PostForm.Get("code")]
+    delete(m.codes, r.PostForm.Get("code"))
+    m.mutex.Unlock()
+    if !found || time.Since(code.created) > time.Minute ||
+        r.PostForm.Get("grant_type") != "authorization_code" ||
+        code.clientID != clientID || code.redirectURI != r.PostForm.Get("redirect_uri") {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+        return
+    }
+
+    sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+    if base64.RawURLEncoding.EncodeToString(sum[:]) != code.challenge {
+        writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+        return
+    }
+
+    now := time.Now()
+    claims := jwt.MapClaims{
+        "iss":            m.issuer,
+        "sub":            "mock-" + code.email,
+        "aud":            clientID,
+        "iat":            now.Unix(),
+        "exp":            now.Add(5 * time.Minute).Unix(),
+        "nonce":          code.nonce,
+        "email":          code.email,
+        "email_verified": code.verified,
+        "name":           m.name,
+    }
+    if code.verified == nil {
+        delete(claims, "email_verified")
+    }
+    idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+    idToken.Header["kid"] = "mock"
+    signed, err := idToken.SignedString(m.key)
+    if err != nil {
+        writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+        return
+    }
+
+    writeJSON(w, http.StatusOK, map[string]any{
+        "access_token": randomString(),
+        "token_type":   "Bearer",
+        "expires_in":   300,
+        "id_token":     signed,
+    })
+}
+
+func main() {
+    addr := flag.String("addr", "127.0.0.1:9999", "listen address")
+    issuer := flag.String("issuer", "", "issuer url, default is http://<addr>")
+    clientID := flag.String("client-id", "webrpl", "accepted client id")
+    clientSecret := flag.String("client-secret", "secret", "accepted client secret")
+    email := flag.String("email", "sso@example.com", "email of the approved user")
+    name := flag.String("name", "SSO User", "name of the approved user")
+    verified := flag.Bool("verified", true, "value of email_verified")
+    flag.Parse()
+
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        log.Fatal(err)
+    }
+    if *issuer == "" {
+        *issuer = "http://" + *addr
+    }
+
+    m := &mockIssuer{
+        issuer:       *issuer,
+        clientID:     *clientID,
+        clientSecret: *clientSecret,
+        email:        *email,
+        name:         *name,
+        verified:     *verified,
+        key:          key,
+        codes:        make(map[string]authCode),
+    }
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+    mux.HandleFunc("/jwks", m.jwks)
+    mux.HandleFunc("/authorize", m.authorize)
+    mux.HandleFunc("/token", m.token)
+
+    log.Printf("mock OIDC issuer on %s", m.issuer)
+    log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,60 @@
+import requests
+
+# NOTE : Need the mock issuer and the backend pointed to it, see mockoidc/main.go.
+#   go run ./test/mockoidc
+#   WRPL_OIDC_ISSUER=http://127.0.0.1:9999 WRPL_OIDC_CLIENT_ID=webrpl WRPL_OIDC_CLIENT_SECRET=secret \
+#   WRPL_OIDC_REDIRECT_URL=http://localhost:3000/api/oidc/callback ./webrpl
+
+BASE = "http://localhost:3000/api"
+
+# NOTE : The session keep the wrpl_oidc cookie from the login like a browser.
+def sso_login(login_hint: str = "", email_verified: str = "") -> requests.Response:
+    browser = requests.Session()
+    start = browser.get(f"{BASE}/oidc/login", allow_redirects=False)
+    authorize = start.headers["Location"]
+    if login_hint:
+        authorize += f"&login_hint={login_hint}"
+    if email_verified:
+        authorize += f"&email_verified={email_verified}"
+    back = browser.get(authorize, allow_redirects=False)
+    return browser.get(back.headers["Location"])
+
+def check(desc: str, response: requests.Response, expected_err_code: int):
+    print ("=" * 20)
+    print(f"Status : {response.status_code}\nResponse : {response.text}")
+    ok = response.json().get("error_code", -1) == expected_err_code
+    status = "PASSED" if ok else "FAIL"
+    print(f"[{status}]: {desc}\n")
+
+if __name__ == "__main__":
+
+    # 1. Test single sign-on with a new user
+    check("Test single sign-on creating a new user, should return error_code 0.", sso_login(), 0)
+
+    # 2. Test single sign-on with email_verified false on an existing account
+    check("Test single sign-on with an unverified email of an existing user, should return error_code 6.", sso_login("admin@wowadmin.com", "false"), 6)
+
+    # 3. Test single sign-on linking an existing user by email
+    check("Test single sign-on linking the admin account, should return error_code 0.", sso_login("admin@wowadmin.com"), 0)
+
+    # 4. Test reusing the callback
+    browser = requests.Session()
+    start = browser.get(f"{BASE}/oidc/login", allow_redirects=False)
+    back = browser.get(start.headers["Location"], allow_redirects=False)
+    cookie = browser.cookies.get("wrpl_oidc")
+    browser.get(back.headers["Location"])
+    check("Test reusing the same state, should return error_code 4.", requests.get(back.headers["Location"], cookies={"wrpl_oidc": cookie}), 4)
+
+    # 5. Test callback with unknown state
+    check("Test callback with an unknown state, should return error_code 4.", requests.get(f"{BASE}/oidc/callback?code=x&state=y"), 4)
+
+    # 6. Test finishing the login started by another browser
+    start = requests.Session().get(f"{BASE}/oidc/login", allow_redirects=False)
+    back = requests.get(start.headers["Location"], allow_redirects=False)
+    check("Test callback without the cookie of the browser that started the login, should return error_code 4.", requests.get(back.headers["Location"]), 4)
+
+    # 7. Test single sign-on without the email_verified claim for a new email
+    check("Test single sign-on without email_verified creating a user, should return error_code 6.", sso_login("unverified@example.com", "omit"), 6)
+
+    # 8. Test single sign-on without email_verified on the account already linked
+    check("Test single sign-on without email_verified on a linked account, should return error_code 0.", sso_login("", "omit"), 0)
@@ -5,7 +5,7 @@ if __name__ == "__main__":
     admin_token = utils.login("admin@wowadmin.com", "secret")
 
     test1 = TestApi.TestApi(
-        "gen-otp-for-register?email=federicomatthewpratamaa@gmail.com",
+        f"gen-otp-for-register?email=federicomatthewpratamaa@gmail.com&{utils.otp_challenge()}",
         method="GET",
         desc="Test the gen OTP. If given a valid email, it should return error_code 0."
     )
@@ -40,3 +40,64 @@ if __name__ == "__main__":
     #     headers={ "Authorization": f"Bearer some_token", "Content-Type": "application/json" },
     # )
     # test5.test(1)
+
+    test6 = TestApi.TestApi(
+        url="protected/email-outbox?status=pending",
+        method="GET",
+        desc="Test admin can see the queued email, it should return error_code 0.",
+        headers={ "Authorization": f"Bearer {admin_token}", "Content-Type": "application/json" },
+    )
+    test6.test(0)
+
+    test7 = TestApi.TestApi(
+        url="protected/email-outbox-resend",
+        method="POST",
+        desc="Test resend without any id, it should return error_code 4.",
+        headers={ "Authorization": f"Bearer {admin_token}", "Content-Type": "application/json" },
+        payload={}
+    )
+    test7.test(4)
+
+    test8 = TestApi.TestApi(
+        "gen-otp-for-register?email=federicomatthewpratamaa@gmail.com&purpose=login",
+        method="GET",
+        desc="Test the gen OTP with a purpose that cant be asked, it should return error_code 6."
+    )
+    test8.test(6)
+
+    test9 = TestApi.TestApi(
+        url="register",
+        method="POST",
+        desc="Test register with a wrong OTP, it should return error_code 10.",
+        headers={ "Content-Type": "application/json" },
+        payload={
+            "email": "federicomatthewpratamaa@gmail.com",
+            "pass": "secret123",
+            "name": "Federico",
+            "instance": "UKDW",
+            "otp_code": "wrong",
+        }
+    )
+    test9.test(10)
+
+    test10 = TestApi.TestApi(
+        "gen-otp-for-register?email=federicomatthewpratamaa@gmail.com",
+        method="GET",
+        desc="Test the gen OTP without solving the challenge, it should return error_code 7."
+    )
+    test10.test(7)
+
+    test11 = TestApi.TestApi(
+        f"gen-otp-for-register?email=federicomatthewpratamaa@gmail.com&{utils.otp_challenge()}",
+        method="GET",
+        desc="Test the gen OTP again for the same email right away, it should return error_code 8 (cooldown)."
+    )
+    test11.test(8)
+
+    test12 = TestApi.TestApi(
+        url="protected/otp-metrics",
+        method="GET",
+        desc="Test admin can see the OTP metrics, it should return error_code 0.",
+        headers={ "Authorization": f"Bearer {admin_token}", "Content-Type": "application/json" },
+    )
+    test12.test(0)
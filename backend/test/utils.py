@@ -1,6 +1,18 @@
+import base64
+import hashlib
+import hmac
+import json
+import os
+import time
 import TestApi as t
 
 def login(email: str, password: str) -> str:
+    # NOTE : A personal access token (api/protected/token-new) on WRPL_TOKEN
+    # is used instead of logging in with the password.
+    token = os.environ.get("WRPL_TOKEN", "")
+    if token:
+        return token
+
     login = t.TestApi(
             "login",
             method="post",
@@ -13,3 +25,34 @@ def login(email: str, password: str) -> str:
     if response:
         return response.get("token")
     return ""
+
+def otp_challenge() -> str:
+    # NOTE : Solve api/otp-challenge the same way the browser does, the result
+    # is the query string gen-otp-for-register need.
+    challenge = t.TestApi("otp-challenge", method="get").send()
+    if not challenge:
+        return ""
+    data = challenge.get("data")
+    nonce = 0
+    while True:
+        digest = hashlib.sha256(f"{data['challenge']}:{nonce}".encode()).digest()
+        if int.from_bytes(digest, "big") >> (256 - data["difficulty"]) == 0:
+            return f"challenge={data['challenge']}&nonce={nonce}"
+        nonce += 1
+
+def _b64(raw: bytes) -> str:
+    return base64.urlsafe_b64encode(raw).rstrip(b"=").decode()
+
+def jwt_claims(token: str) -> dict:
+    payload = token.split(".")[1]
+    return json.loads(base64.urlsafe_b64decode(payload + "=" * (-len(payload) % 4)))
+
+def sign_jwt(claims: dict) -> str:
+    # NOTE : Sign a token like the backend does, with WRPL_SECRET (the same
+    # default as the backend). Used to test the old token without `sub`.
+    secret = os.environ.get("WRPL_SECRET", "secret")
+    claims = {"exp": int(time.time()) + 600, **claims}
+    head = _b64(json.dumps({"alg": "HS256", "typ": "JWT"}).encode())
+    body = _b64(json.dumps(claims).encode())
+    sig = hmac.new(secret.encode(), f"{head}.{body}".encode(), hashlib.sha256).digest()
+    return f"{head}.{body}.{_b64(sig)}"
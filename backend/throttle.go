@@ -0,0 +1,195 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// NOTE: After the free tries every failure double the wait before the next try,
+//       and at the max the key is locked. The ip limit is higher because a lot of
+//       student can be behind the same campus ip.
+const (
+    throttleAccountFree  = 3
+    throttleAccountMax   = 10
+    throttleIPFree       = 20
+    throttleIPMax        = 50
+    throttleBaseDelay    = 1 * time.Second
+    throttleMaxDelay     = 60 * time.Second
+    throttleLockDuration = 15 * time.Minute
+    throttleForgetAfter  = 1 * time.Hour
+)
+
+func throttleAccountKey(email string) string {
+    return strings.ToLower(strings.TrimSpace(email))
+}
+
+func throttleDelay(kind table.LoginThrottleKind, failures int) time.Duration {
+    free := throttleAccountFree
+    if kind == table.ThrottleIP {
+        free = throttleIPFree
+    }
+    if failures < free {
+        return 0
+    }
+    delay := time.Duration(float64(throttleBaseDelay) * math.Pow(2, float64(failures-free)))
+    if delay > throttleMaxDelay {
+        return throttleMaxDelay
+    }
+    return delay
+}
+
+// NOTE: The login come through the frontend server, without a trusted proxy
+//       c.IP() is that server for everyone and the ip limit would lock out the
+//       whole site at once. Empty mean the ip limit is off (see WRPL_TRUSTED_PROXIES).
+func throttleClientIP(c *fiber.Ctx) string {
+    if !c.App().Config().EnableTrustedProxyCheck {
+        return ""
+    }
+    return c.IP()
+}
+
+// NOTE: Return how long the caller has to wait, 0 mean the login can be tried.
+//       Use loginThrottleAttempt, this alone didnt count anything.
+func loginThrottleWait(db *gorm.DB, email string, ip string) (time.Duration, error) {
+    var entries []table.LoginThrottle
+    res := db.Where("(throttle_kind = ? AND throttle_key = ?) OR (throttle_kind = ? AND throttle_key = ?)",
+        table.ThrottleAccount, throttleAccountKey(email), table.ThrottleIP, ip).Find(&entries)
+    if res.Error != nil {
+        return 0, res.Error
+    }
+
+    now := time.Now()
+    var wait time.Duration
+    for _, entry := range entries {
+        if now.Sub(entry.ThrottleLastFail) > throttleForgetAfter && (entry.ThrottleLocked == nil || entry.ThrottleLocked.Before(now)) {
+            continue
+        }
+        if entry.ThrottleLocked != nil && entry.ThrottleLocked.After(now) {
+            wait = max(wait, entry.ThrottleLocked.Sub(now))
+            continue
+        }
+        next := entry.ThrottleLastFail.Add(throttleDelay(entry.ThrottleKind, entry.ThrottleFailures))
+        if next.After(now) {
+            wait = max(wait, next.Sub(now))
+        }
+    }
+    return wait, nil
+}
+
+// NOTE: One upsert so two login at the same time cant both read the old count
+//       and save the same number. The count start again after a quiet while or
+//       when the lock is over.
+func loginThrottleBump(db *gorm.DB, kind table.LoginThrottleKind, key string, limit int) error {
+    now := time.Now()
+    reset := gorm.Expr("login_throttles.throttle_last_fail < ? OR login_throttles.throttle_locked_until < ?",
+        now.Add(-throttleForgetAfter), now)
+    failures := gorm.Expr("CASE WHEN ? THEN 1 ELSE login_throttles.throttle_failures + 1 END", reset)
+
+    entry := table.LoginThrottle{
+        ThrottleKind:     kind,
+        ThrottleKey:      key,
+        ThrottleFailures: 1,
+        ThrottleLastFail: now,
+    }
+    return db.Clauses(clause.OnConflict{
+        Columns: []clause.Column{{Name: "throttle_kind"}, {Name: "throttle_key"}},
+        DoUpdates: clause.Assignments(map[string]any{
+            "throttle_failures":  failures,
+            "throttle_last_fail": now,
+            "throttle_locked_until": gorm.Expr("CASE WHEN ? >= ? THEN ? WHEN ? THEN NULL ELSE login_throttles.throttle_locked_until END",
+                failures, limit, now.Add(throttleLockDuration), reset),
+            "updated_at": now,
+        }),
+    }).Create(&entry).Error
+}
+
+func loginThrottleDrop(db *gorm.DB, kind table.LoginThrottleKind, key string, limit int) error {
+    return db.Model(&table.LoginThrottle{}).
+        Where("throttle_kind = ? AND throttle_key = ? AND throttle_failures > 0", kind, key).
+        Updates(map[string]any{
+            "throttle_failures":     gorm.Expr("throttle_failures - 1"),
+            "throttle_locked_until": gorm.Expr("CASE WHEN throttle_failures - 1 < ? THEN NULL ELSE throttle_locked_until END", limit),
+        }).Error
+}
+
+// NOTE: Count the login (password or 2FA code) before it is checked, the check
+//       and the count is on one transaction. Checking the password take a while,
+//       a burst of login sent at once would all get past the wait before the
+//       first failure is saved otherwise. When the login turn out good give the
+//       count back with loginThrottleUndo. Return the wait when it is not counted.
+func loginThrottleAttempt(db *gorm.DB, email string, ip string) (time.Duration, error) {
+    var wait time.Duration
+    err := db.Transaction(func(tx *gorm.DB) error {
+        var err error
+        wait, err = loginThrottleWait(tx, email, ip)
+        if err != nil || wait > 0 {
+            return err
+        }
+        if err := loginThrottleBump(tx, table.ThrottleAccount, throttleAccountKey(email), throttleAccountMax); err != nil {
+            return err
+        }
+        if ip == "" {
+            return nil
+        }
+        return loginThrottleBump(tx, table.ThrottleIP, ip, throttleIPMax)
+    })
+    return wait, err
+}
+
+// NOTE: The attempt counted by loginThrottleAttempt was a good password or code.
+func loginThrottleUndo(db *gorm.DB, email string, ip string) error {
+    if err := loginThrottleDrop(db, table.ThrottleAccount, throttleAccountKey(email), throttleAccountMax); err != nil {
+        return err
+    }
+    if ip == "" {
+        return nil
+    }
+    return loginThrottleDrop(db, table.ThrottleIP, ip, throttleIPMax)
+}
+
+// NOTE: Only the account is forgiven, one good password from an ip that is
+//       guessing a lot of account shouldnt clear the ip.
+func loginThrottleSuccess(db *gorm.DB, email string) error {
+    return db.Unscoped().
+        Where("throttle_kind = ? AND throttle_key = ?", table.ThrottleAccount, throttleAccountKey(email)).
+        Delete(&table.LoginThrottle{}).Error
+}
+
+var (
+    dummyHashOnce sync.Once
+    dummyHash     string
+)
+
+// NOTE: A real bcrypt hash of nothing, only used to spend the same time as a
+//       real password check.
+func dummyPasswordHash() string {
+    dummyHashOnce.Do(func() {
+        secret, err := randomHex(16)
+        if err == nil {
+            dummyHash, _ = HashPassword(secret)
+        }
+    })
+    return dummyHash
+}
+
+func loginThrottled(c *fiber.Ctx, wait time.Duration, errCode int) error {
+    seconds := int(math.Ceil(wait.Seconds()))
+    c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+    return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+        "success":    false,
+        "message":    fmt.Sprintf("Too many failed login, try again in %d second.", seconds),
+        "error_code": errCode,
+        "data": fiber.Map{
+            "retry_after": seconds,
+        },
+    })
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+    "sync"
+    "sync/atomic"
+    "testing"
+    "webrpl/table"
+)
+
+func TestLoginThrottleBumpParallel(t *testing.T) {
+    db := newTestDB(t, &table.LoginThrottle{})
+
+    var wg sync.WaitGroup
+    for range 2 * throttleAccountMax {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := loginThrottleBump(db, table.ThrottleAccount, "a@example.com", throttleAccountMax); err != nil {
+                t.Errorf("bump returned %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    var entry table.LoginThrottle
+    if err := db.First(&entry).Error; err != nil {
+        t.Fatal(err)
+    }
+    if entry.ThrottleFailures != 2*throttleAccountMax {
+        t.Fatalf("counted %d failure, want %d", entry.ThrottleFailures, 2*throttleAccountMax)
+    }
+    if entry.ThrottleLocked == nil {
+        t.Fatal("the account is not locked after the max")
+    }
+}
+
+func TestLoginThrottleAttemptParallel(t *testing.T) {
+    db := newTestDB(t, &table.LoginThrottle{})
+
+    var allowed atomic.Int64
+    var wg sync.WaitGroup
+    for range 4 * throttleAccountMax {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            wait, err := loginThrottleAttempt(db, "a@example.com", "")
+            if err == nil && wait == 0 {
+                allowed.Add(1)
+            }
+        }()
+    }
+    wg.Wait()
+
+    if allowed.Load() > throttleAccountFree {
+        t.Fatalf("%d login got past the wait, the free try is %d", allowed.Load(), throttleAccountFree)
+    }
+    var entry table.LoginThrottle
+    if err := db.First(&entry).Error; err != nil {
+        t.Fatal(err)
+    }
+    if int64(entry.ThrottleFailures) != allowed.Load() {
+        t.Fatalf("counted %d attempt but %d got past the wait", entry.ThrottleFailures, allowed.Load())
+    }
+
+    if err := loginThrottleUndo(db, "a@example.com", ""); err != nil {
+        t.Fatal(err)
+    }
+    if err := db.First(&entry).Error; err != nil {
+        t.Fatal(err)
+    }
+    if int64(entry.ThrottleFailures) != allowed.Load()-1 {
+        t.Fatalf("the good login is still counted, %d attempt", entry.ThrottleFailures)
+    }
+}
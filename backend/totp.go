@@ -0,0 +1,313 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/base32"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "log"
+    "net/url"
+    "strings"
+    "time"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+    "github.com/golang-jwt/jwt/v5"
+    "gorm.io/gorm"
+)
+
+// NOTE: RFC 6238 with the default every authenticator app use, SHA1, 6 digit and
+//       30 second. One step before and after is accepted for the clock drift.
+const (
+    totpIssuer        = "Webinar-RPL"
+    totpPeriod        = 30
+    totpDigits        = 6
+    totpSkew          = 1
+    totpRecoveryCount = 10
+    mfaTokenTTL       = 5 * time.Minute
+)
+
+var (
+    errTOTPInvalid    = errors.New("invalid two factor code")
+    errTOTPNotEnabled = errors.New("two factor is not enabled")
+    errMFATokenBad    = errors.New("invalid or expired login token")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+    b := make([]byte, 20)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return totpEncoding.EncodeToString(b), nil
+}
+
+func totpCodeAt(secret string, step int64) (string, error) {
+    key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+    if err != nil {
+        return "", err
+    }
+    var msg [8]byte
+    binary.BigEndian.PutUint64(msg[:], uint64(step))
+    mac := hmac.New(sha1.New, key)
+    mac.Write(msg[:])
+    sum := mac.Sum(nil)
+
+    offset := sum[len(sum)-1] & 0x0f
+    value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+    return fmt.Sprintf("%06d", value%1000000), nil
+}
+
+// NOTE: Return the step of the matching code, it has to be newer than `lastStep`
+//       so a code that already logged in cant be replayed.
+func totpVerify(secret string, code string, lastStep int64) (int64, bool) {
+    code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+    if len(code) != totpDigits {
+        return 0, false
+    }
+    now := time.Now().Unix() / totpPeriod
+    for i := -totpSkew; i <= totpSkew; i++ {
+        step := now + int64(i)
+        if step <= lastStep {
+            continue
+        }
+        expected, err := totpCodeAt(secret, step)
+        if err != nil {
+            return 0, false
+        }
+        if hmac.Equal([]byte(expected), []byte(code)) {
+            return step, true
+        }
+    }
+    return 0, false
+}
+
+func totpProvisioningURL(secret string, email string) string {
+    v := url.Values{}
+    v.Set("secret", secret)
+    v.Set("issuer", totpIssuer)
+    v.Set("algorithm", "SHA1")
+    v.Set("digits", fmt.Sprint(totpDigits))
+    v.Set("period", fmt.Sprint(totpPeriod))
+    return fmt.Sprintf("otpauth://totp/%s:%s?%s", url.PathEscape(totpIssuer), url.PathEscape(email), v.Encode())
+}
+
+// NOTE: Make a new secret that is not enabled yet, enrolling again before the
+//       confirm replace the pending one.
+func totpEnroll(db *gorm.DB, user *table.User) (string, error) {
+    secret, err := newTOTPSecret()
+    if err != nil {
+        return "", err
+    }
+
+    var entry table.UserTOTP
+    res := db.Where("user_id = ?", user.ID).First(&entry)
+    if res.Error != nil && !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+        return "", res.Error
+    }
+    if entry.TOTPEnabled {
+        return "", errors.New("two factor is already enabled, disable it first")
+    }
+    entry.UserId = user.ID
+    entry.TOTPSecret = secret
+    entry.TOTPLastStep = 0
+    if err := db.Save(&entry).Error; err != nil {
+        return "", err
+    }
+    return secret, nil
+}
+
+// NOTE: Enable the pending secret and return the new recovery code.
+func totpConfirm(db *gorm.DB, userID int, code string) ([]string, error) {
+    var codes []string
+    err := db.Transaction(func(tx *gorm.DB) error {
+        var entry table.UserTOTP
+        if err := tx.Where("user_id = ?", userID).First(&entry).Error; err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                return errTOTPNotEnabled
+            }
+            return err
+        }
+        if entry.TOTPEnabled {
+            return errors.New("two factor is already enabled")
+        }
+        step, ok := totpVerify(entry.TOTPSecret, code, entry.TOTPLastStep)
+        if !ok {
+            return errTOTPInvalid
+        }
+
+        now := time.Now()
+        entry.TOTPEnabled = true
+        entry.TOTPConfirmedAt = &now
+        entry.TOTPLastStep = step
+        if err := tx.Save(&entry).Error; err != nil {
+            return err
+        }
+
+        var err error
+        codes, err = totpNewRecoveryCodes(tx, userID)
+        return err
+    })
+    return codes, err
+}
+
+func totpNewRecoveryCodes(db *gorm.DB, userID int) ([]string, error) {
+    if err := db.Unscoped().Where("user_id = ?", userID).Delete(&table.TOTPRecoveryCode{}).Error; err != nil {
+        return nil, err
+    }
+    codes := make([]string, 0, totpRecoveryCount)
+    for range totpRecoveryCount {
+        raw, err := randomHex(5)
+        if err != nil {
+            return nil, err
+        }
+        code := raw[:5] + "-" + raw[5:]
+        entry := table.TOTPRecoveryCode{
+            UserId:   userID,
+            CodeHash: hashRefreshSecret(code),
+        }
+        if err := db.Create(&entry).Error; err != nil {
+            return nil, err
+        }
+        codes = append(codes, code)
+    }
+    return codes, nil
+}
+
+// NOTE: Accept either the code from the app or one unused recovery code. The
+//       row is updated with a condition so the same code cant win twice.
+func totpCheck(db *gorm.DB, userID int, code string) error {
+    var entry table.UserTOTP
+    res := db.Where("user_id = ? AND totp_enabled = ?", userID, true).First(&entry)
+    if res.Error != nil {
+        if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+            return errTOTPNotEnabled
+        }
+        return res.Error
+    }
+
+    if step, ok := totpVerify(entry.TOTPSecret, code, entry.TOTPLastStep); ok {
+        res = db.Model(&table.UserTOTP{}).
+            Where("id = ? AND totp_last_step < ?", entry.ID, step).
+            Update("totp_last_step", step)
+        if res.Error != nil {
+            return res.Error
+        }
+        if res.RowsAffected == 0 {
+            return errTOTPInvalid
+        }
+        return nil
+    }
+
+    res = db.Model(&table.TOTPRecoveryCode{}).
+        Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRefreshSecret(strings.ToLower(strings.TrimSpace(code)))).
+        Update("used_at", time.Now())
+    if res.Error != nil {
+        return res.Error
+    }
+    if res.RowsAffected == 0 {
+        return errTOTPInvalid
+    }
+    return nil
+}
+
+func totpDisable(db *gorm.DB, userID int) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&table.UserTOTP{}).Error; err != nil {
+            return err
+        }
+        return tx.Unscoped().Where("user_id = ?", userID).Delete(&table.TOTPRecoveryCode{}).Error
+    })
+}
+
+func totpEnabled(db *gorm.DB, userID int) (bool, error) {
+    var count int64
+    err := db.Model(&table.UserTOTP{}).Where("user_id = ? AND totp_enabled = ?", userID, true).Count(&count).Error
+    return count > 0, err
+}
+
+// NOTE: The second step token is signed with another key so it can never pass
+//       as an access token, it only say the password (or SSO) part is done.
+func mfaKey(backend *Backend) []byte {
+    return []byte(backend.pass + ":mfa")
+}
+
+func signMFAToken(backend *Backend, user *table.User) (string, error) {
+    claims := jwt.MapClaims{
+        "email": user.UserEmail,
+        "uid":   user.ID,
+        "mfa":   true,
+        "exp":   time.Now().Add(mfaTokenTTL).Unix(),
+    }
+    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+    return token.SignedString(mfaKey(backend))
+}
+
+func parseMFAToken(backend *Backend, raw string) (*table.User, error) {
+    token, err := jwt.Parse(raw, func(t *jwt.Token) (any, error) {
+        return mfaKey(backend), nil
+    }, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+    if err != nil || !token.Valid {
+        return nil, errMFATokenBad
+    }
+    claims, ok := token.Claims.(jwt.MapClaims)
+    if !ok || claims["mfa"] != true {
+        return nil, errMFATokenBad
+    }
+    uid, ok := claims["uid"].(float64)
+    if !ok {
+        return nil, errMFATokenBad
+    }
+
+    var user table.User
+    if err := backend.db.First(&user, int(uid)).Error; err != nil {
+        return nil, errMFATokenBad
+    }
+    return &user, nil
+}
+
+// NOTE: Called after the first factor is ok. When the user have 2FA (or must
+//       have it) the session is not made yet, the caller get `mfa_token` to
+//       finish on api/login-2fa.
+func beginLogin(backend *Backend, c *fiber.Ctx, user *table.User, errCode int) error {
+    enabled, err := totpEnabled(backend.db, user.ID)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "success":    false,
+            "message":    fmt.Sprintf("Failed to check the two factor, %v", err),
+            "error_code": errCode,
+            "data":       nil,
+        })
+    }
+    if !enabled && !user.UserTOTPRequired {
+        if err := loginThrottleSuccess(backend.db, user.UserEmail); err != nil {
+            log.Printf("Failed to clear the failed login: %v", err)
+        }
+        return loginResponse(backend, c, user, errCode)
+    }
+
+    mfaToken, err := signMFAToken(backend, user)
+    if err != nil {
+        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+            "success":    false,
+            "message":    fmt.Sprintf("Failed to generate JWT, %v", err),
+            "error_code": errCode,
+            "data":       nil,
+        })
+    }
+
+    return c.Status(fiber.StatusOK).JSON(fiber.Map{
+        "success":    true,
+        "message":    "Two factor code required.",
+        "error_code": 0,
+        "data": fiber.Map{
+            "mfa_required": true,
+            "mfa_enroll":   !enabled,
+            "mfa_token":    mfaToken,
+        },
+    })
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+    "webrpl/table"
+
+    "gorm.io/gorm"
+)
+
+// NOTE: Everything that embed gorm.Model is only soft deleted. The row that is
+//       deleted together with the parent get the exact same deleted_at, that is
+//       how the restore know which one to bring back. The row that was deleted
+//       by itself before stay deleted.
+type trashType string
+
+const (
+    trashUser     trashType = "user"
+    trashEvent    trashType = "event"
+    trashMaterial trashType = "material"
+    trashCert     trashType = "cert"
+)
+
+var (
+    errTrashType       = errors.New("invalid type, the only valid strings are : `user`, `event`, `material` and `cert`")
+    errTrashNotFound   = errors.New("there is no deleted record with that id")
+    errTrashParent     = errors.New("the event of this record is deleted, restore the event first")
+    errTrashConflict   = errors.New("the email is already used by another user")
+    errTrashAnonymized = errors.New("the user is anonymized and can only be purged")
+)
+
+func trashPermission(kind trashType) (string, error) {
+    switch kind {
+    case trashUser:
+        return permUserDelete, nil
+    case trashEvent:
+        return permEventDelete, nil
+    case trashMaterial:
+        return permEventEdit, nil
+    case trashCert:
+        return permCertManage, nil
+    }
+    return "", errTrashType
+}
+
+func trashModel(kind trashType) any {
+    switch kind {
+    case trashUser:
+        return &table.User{}
+    case trashEvent:
+        return &table.Event{}
+    case trashMaterial:
+        return &table.EventMaterial{}
+    case trashCert:
+        return &table.CertTemplate{}
+    }
+    return nil
+}
+
+// NOTE: The stamp of the parent as a subquery, comparing the column directly
+//       didnt depend on how the driver format the time.
+func deletedWith(db *gorm.DB, tableName string, id int) *gorm.DB {
+    return db.Unscoped().Table(tableName).Select("deleted_at").Where("id = ?", id)
+}
+
+func softDeleteEvent(db *gorm.DB, eventID int) (int64, error) {
+    var affected int64
+    err := db.Transaction(func(tx *gorm.DB) error {
+        now := time.Now()
+        res := tx.Model(&table.Event{}).Where("id = ?", eventID).Update("deleted_at", now)
+        if res.Error != nil {
+            return res.Error
+        }
+        affected = res.RowsAffected
+        if affected == 0 {
+            return nil
+        }
+        for _, model := range []any{&table.EventParticipant{}, &table.EventMaterial{}, &table.CertTemplate{}} {
+            if err := tx.Model(model).Where("event_id = ?", eventID).Update("deleted_at", now).Error; err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    return affected, err
+}
+
+func softDeleteUser(db *gorm.DB, userID int) (int64, error) {
+    var affected int64
+    err := db.Transaction(func(tx *gorm.DB) error {
+        now := time.Now()
+        res := tx.Model(&table.User{}).Where("id = ?", userID).Update("deleted_at", now)
+        if res.Error != nil {
+            return res.Error
+        }
+        affected = res.RowsAffected
+        if affected == 0 {
+            return nil
+        }
+        return tx.Model(&table.EventParticipant{}).Where("user_id = ?", userID).Update("deleted_at", now).Error
+    })
+    return affected, err
+}
+
+// NOTE: The participant, material and template of an event deleted before the
+//       cascade existed are still there, put them in the trash with the event.
+func cascadeDeletedEvents(db *gorm.DB) error {
+    for _, model := range []any{&table.EventParticipant{}, &table.EventMaterial{}, &table.CertTemplate{}} {
+        err := db.Model(model).
+            Where("event_id IN (?)", db.Unscoped().Model(&table.Event{}).Select("id").Where("deleted_at IS NOT NULL")).
+            Update("deleted_at", gorm.Expr("(SELECT events.deleted_at FROM events WHERE events.id = event_id)")).Error
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func trashList(db *gorm.DB, kind trashType, offset int, limit int) ([]map[string]any, int64, error) {
+    model := trashModel(kind)
+    if model == nil {
+        return nil, 0, errTrashType
+    }
+
+    var total int64
+    query := db.Unscoped().Model(model).Where("deleted_at IS NOT NULL")
+    if err := query.Count(&total).Error; err != nil {
+        return nil, 0, err
+    }
+    query = query.Order("deleted_at DESC").Offset(offset).Limit(limit)
+
+    result := []map[string]any{}
+    switch kind {
+    case trashUser:
+        var rows []table.User
+        if err := query.Find(&rows).Error; err != nil {
+            return nil, 0, err
+        }
+        for _, row := range rows {
+            result = append(result, map[string]any{
+                "id":         row.ID,
+                "name":       row.UserFullName,
+                "detail":     row.UserEmail,
+                "deleted_at": row.DeletedAt.Time,
+            })
+        }
+    case trashEvent:
+        var rows []table.Event
+        if err := query.Find(&rows).Error; err != nil {
+            return nil, 0, err
+        }
+        for _, row := range rows {
+            result = append(result, map[string]any{
+                "id":         row.ID,
+                "name":       row.EventName,
+                "detail":     row.EventDStart,
+                "deleted_at": row.DeletedAt.Time,
+            })
+        }
+    case trashMaterial:
+        var rows []table.EventMaterial
+        if err := query.Find(&rows).Error; err != nil {
+            return nil, 0, err
+        }
+        for _, row := range rows {
+            result = append(result, map[string]any{
+                "id":         row.ID,
+                "name":       row.EventMatAttachment,
+                "detail":     row.EventId,
+                "deleted_at": row.DeletedAt.Time,
+            })
+        }
+    case trashCert:
+        var rows []table.CertTemplate
+        if err := query.Find(&rows).Error; err != nil {
+            return nil, 0, err
+        }
+        for _, row := range rows {
+            result = append(result, map[string]any{
+                "id":         row.ID,
+                "name":       row.CertTemplate,
+                "detail":     row.EventId,
+                "deleted_at": row.DeletedAt.Time,
+            })
+        }
+    }
+    return result, total, nil
+}
+
+func eventAlive(tx *gorm.DB, eventID int) (bool, error) {
+    var count int64
+    err := tx.Model(&table.Event{}).Where("id = ?", eventID).Count(&count).Error
+    return count > 0, err
+}
+
+func trashRestore(db *gorm.DB, kind trashType, id int) error {
+    model := trashModel(kind)
+    if model == nil {
+        return errTrashType
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        res := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(model)
+        if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+            return errTrashNotFound
+        }
+        if res.Error != nil {
+            return res.Error
+        }
+
+        switch row := model.(type) {
+        case *table.User:
+            if strings.HasSuffix(row.UserEmail, anonymizedEmailDomain) {
+                return errTrashAnonymized
+            }
+            var count int64
+            if err := tx.Model(&table.User{}).Where("user_email = ?", row.UserEmail).Count(&count).Error; err != nil {
+                return err
+            }
+            if count > 0 {
+                return errTrashConflict
+            }
+            err := tx.Unscoped().Model(&table.EventParticipant{}).
+                Where("user_id = ? AND deleted_at = (?)", id, deletedWith(tx, "users", id)).
+                Where("event_id IN (?)", tx.Model(&table.Event{}).Select("id")).
+                Update("deleted_at", nil).Error
+            if err != nil {
+                return err
+            }
+        case *table.Event:
+            for _, dep := range []any{&table.EventParticipant{}, &table.EventMaterial{}, &table.CertTemplate{}} {
+                query := tx.Unscoped().Model(dep).Where("event_id = ? AND deleted_at = (?)", id, deletedWith(tx, "events", id))
+                if _, ok := dep.(*table.EventParticipant); ok {
+                    query = query.Where("user_id IN (?)", tx.Model(&table.User{}).Select("id"))
+                }
+                if err := query.Update("deleted_at", nil).Error; err != nil {
+                    return err
+                }
+            }
+        case *table.EventMaterial:
+            alive, err := eventAlive(tx, row.EventId)
+            if err != nil {
+                return err
+            }
+            if !alive {
+                return errTrashParent
+            }
+        case *table.CertTemplate:
+            alive, err := eventAlive(tx, row.EventId)
+            if err != nil {
+                return err
+            }
+            if !alive {
+                return errTrashParent
+            }
+        }
+
+        return tx.Unscoped().Model(model).Where("id = ?", id).Update("deleted_at", nil).Error
+    })
+}
+
+// NOTE: Only a file directly under static/ (or a folder of it) is removed, a
+//       path that go outside is ignored.
+func staticFile(rel string) string {
+    clean := filepath.Clean(filepath.Join("static", rel))
+    if !strings.HasPrefix(clean, "static"+string(filepath.Separator)) {
+        return ""
+    }
+    return clean
+}
+
+func staticFileFromURL(url string) string {
+    idx := strings.LastIndex(url, "/static/")
+    if idx < 0 {
+        return ""
+    }
+    return staticFile(url[idx+len("/static/"):])
+}
+
+// NOTE: The template is static/<event>/index.html with the bg.png next to it,
+//       both are removed once nothing point to the html anymore.
+func certTemplateFiles(tx *gorm.DB, paths []string) ([]string, error) {
+    var files []string
+    for _, path := range paths {
+        var count int64
+        if err := tx.Unscoped().Model(&table.CertTemplate{}).Where("cert_template = ?", path).Count(&count).Error; err != nil {
+            return nil, err
+        }
+        html := staticFile(path)
+        if count > 0 || html == "" {
+            continue
+        }
+        files = append(files, html, filepath.Join(filepath.Dir(html), "bg.png"))
+    }
+    return files, nil
+}
+
+func removeTrashFiles(files []string) {
+    dirs := map[string]bool{}
+    for _, file := range files {
+        if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+            log.Printf("Failed to remove %s: %v", file, err)
+        }
+        if dir := filepath.Dir(file); dir != "static" {
+            dirs[dir] = true
+        }
+    }
+    // Only remove the folder when it is empty now.
+    for dir := range dirs {
+        os.Remove(dir)
+    }
+}
+
+// NOTE: A file directly under static/ is the picture of a user or an event, a
+//       folder named after an event id is its certificate template. The one no
+//       row point to anymore (even in the trash) is removed. The upload happen
+//       before the row is saved, so only the one older than orphanFileAge.
+const orphanFileAge = 24 * time.Hour
+
+func collectOrphanFiles(backend *Backend) error {
+    entries, err := os.ReadDir("static")
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    var urls, pictures, templates []string
+    var eventIDs []int
+    if err := backend.db.Unscoped().Model(&table.User{}).Where("user_picture <> ''").Pluck("user_picture", &pictures).Error; err != nil {
+        return err
+    }
+    if err := backend.db.Unscoped().Model(&table.Event{}).Where("event_img <> ''").Pluck("event_img", &urls).Error; err != nil {
+        return err
+    }
+    if err := backend.db.Unscoped().Model(&table.CertTemplate{}).Pluck("cert_template", &templates).Error; err != nil {
+        return err
+    }
+    if err := backend.db.Unscoped().Model(&table.Event{}).Pluck("id", &eventIDs).Error; err != nil {
+        return err
+    }
+
+    used := map[string]bool{}
+    for _, url := range append(urls, pictures...) {
+        if file := staticFileFromURL(url); file != "" {
+            used[file] = true
+        }
+    }
+    for _, path := range templates {
+        if html := staticFile(path); html != "" {
+            used[filepath.Dir(html)] = true
+        }
+    }
+    for _, id := range eventIDs {
+        used[filepath.Join("static", strconv.Itoa(id))] = true
+    }
+
+    cutoff := time.Now().Add(-orphanFileAge)
+    var files []string
+    for _, entry := range entries {
+        path := filepath.Join("static", entry.Name())
+        info, err := entry.Info()
+        if err != nil || info.ModTime().After(cutoff) || used[path] {
+            continue
+        }
+        if !entry.IsDir() {
+            files = append(files, path)
+            continue
+        }
+        if _, err := strconv.Atoi(entry.Name()); err != nil {
+            continue
+        }
+        inner, err := os.ReadDir(path)
+        if err != nil {
+            return err
+        }
+        for _, file := range inner {
+            if !file.IsDir() {
+                files = append(files, filepath.Join(path, file.Name()))
+            }
+        }
+    }
+
+    if len(files) > 0 {
+        log.Printf("Removing %d orphan file.", len(files))
+        removeTrashFiles(files)
+    }
+    return nil
+}
+
+func trashPurge(db *gorm.DB, kind trashType, id int) error {
+    model := trashModel(kind)
+    if model == nil {
+        return errTrashType
+    }
+
+    var files []string
+    err := db.Transaction(func(tx *gorm.DB) error {
+        res := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(model)
+        if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+            return errTrashNotFound
+        }
+        if res.Error != nil {
+            return res.Error
+        }
+
+        type purge struct {
+            model any
+            query string
+            args  []any
+        }
+        var deletes []purge
+        var certPaths []string
+
+        switch row := model.(type) {
+        case *table.User:
+            if path := userPicturePath(row); path != "" {
+                used, err := staticFileUsed(tx, path, id)
+                if err != nil {
+                    return err
+                }
+                if !used {
+                    files = append(files, path)
+                }
+            }
+            deletes = []purge{
+                {&table.EventParticipant{}, "user_id = ?", []any{id}},
+                {&table.Session{}, "user_id = ?", []any{id}},
+                {&table.ApiToken{}, "user_id = ?", []any{id}},
+                {&table.UserTOTP{}, "user_id = ?", []any{id}},
+                {&table.TOTPRecoveryCode{}, "user_id = ?", []any{id}},
+                {&table.RoleAssignment{}, "user_id = ?", []any{id}},
+                {&table.SecurityEvent{}, "user_id = ?", []any{id}},
+                {&table.OTP{}, "user_email = ?", []any{otpEmailKey(row.UserEmail)}},
+                {&table.EmailOutbox{}, "mail_to = ?", []any{row.UserEmail}},
+                {&table.LoginThrottle{}, "throttle_kind = ? AND throttle_key = ?", []any{table.ThrottleAccount, throttleAccountKey(row.UserEmail)}},
+            }
+        case *table.Event:
+            if path := staticFileFromURL(row.EventImg); path != "" {
+                files = append(files, path)
+            }
+            if err := tx.Unscoped().Model(&table.CertTemplate{}).Where("event_id = ?", id).Distinct().Pluck("cert_template", &certPaths).Error; err != nil {
+                return err
+            }
+            deletes = []purge{
+                {&table.EventParticipant{}, "event_id = ?", []any{id}},
+                {&table.EventMaterial{}, "event_id = ?", []any{id}},
+                {&table.CertTemplate{}, "event_id = ?", []any{id}},
+            }
+        case *table.CertTemplate:
+            certPaths = []string{row.CertTemplate}
+        }
+
+        deletes = append(deletes, purge{model, "id = ?", []any{id}})
+        for _, d := range deletes {
+            if err := tx.Unscoped().Where(d.query, d.args...).Delete(d.model).Error; err != nil {
+                return fmt.Errorf("purge %T: %w", d.model, err)
+            }
+        }
+
+        certFiles, err := certTemplateFiles(tx, certPaths)
+        if err != nil {
+            return err
+        }
+        files = append(files, certFiles...)
+        return nil
+    })
+    if err != nil {
+        return err
+    }
+
+    removeTrashFiles(files)
+    return nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+    "archive/zip"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+    "webrpl/table"
+
+    "github.com/gofiber/fiber/v2"
+    "gorm.io/gorm"
+)
+
+// NOTE: The user can still login and cancel until the grace period is over,
+//       after that the account is anonymized by the account-delete job.
+const (
+    accountDeleteGrace    = 14 * 24 * time.Hour
+    accountDeletedName    = "Deleted user"
+    anonymizedEmailDomain = "@deleted.invalid"
+)
+
+type exportParticipation struct {
+    EventID     int       `json:"event_id"`
+    EventName   string    `json:"event_name"`
+    EventStart  time.Time `json:"event_start"`
+    EventEnd    time.Time `json:"event_end"`
+    Role        string    `json:"role"`
+    Attended    bool      `json:"attended"`
+    Waitlisted  bool      `json:"waitlisted"`
+    Certificate string    `json:"certificate,omitempty"`
+    RegisterAt  time.Time `json:"registered_at"`
+}
+
+type exportSession struct {
+    CreatedAt time.Time  `json:"created_at"`
+    LastUsed  time.Time  `json:"last_used"`
+    Expires   time.Time  `json:"expires"`
+    Revoked   *time.Time `json:"revoked"`
+    IP        string     `json:"ip"`
+    Agent     string     `json:"agent"`
+}
+
+type exportToken struct {
+    Name      string     `json:"name"`
+    Prefix    string     `json:"prefix"`
+    Scopes    string     `json:"scopes"`
+    CreatedAt time.Time  `json:"created_at"`
+    Expires   *time.Time `json:"expires"`
+    LastUsed  *time.Time `json:"last_used"`
+    LastIP    string     `json:"last_ip"`
+    Revoked   *time.Time `json:"revoked"`
+}
+
+type exportEmail struct {
+    Subject   string     `json:"subject"`
+    Status    string     `json:"status"`
+    CreatedAt time.Time  `json:"created_at"`
+    SentAt    *time.Time `json:"sent_at"`
+}
+
+// NOTE: The picture upload is saved as static/user-<id>.<ext>, only that file
+//       belong to the user. Anything else in UserPicture (an old upload from
+//       before, a link to some other file) is not ours to touch.
+func userUploadName(user *table.User) string {
+    return fmt.Sprintf("user-%d", user.ID)
+}
+
+func isUserUpload(user *table.User, path string) bool {
+    if path == "" || filepath.Dir(path) != "static" {
+        return false
+    }
+    ext := filepath.Ext(path)
+    switch ext {
+    case "", ".png", ".gif", ".jpg", ".webp":
+    default:
+        return false
+    }
+    return strings.TrimSuffix(filepath.Base(path), ext) == userUploadName(user)
+}
+
+func userPicturePath(user *table.User) string {
+    path := staticFileFromURL(user.UserPicture)
+    if !isUserUpload(user, path) {
+        return ""
+    }
+    return path
+}
+
+// NOTE: Same idea as collectOrphanFiles, a file is only removed when no other
+//       user (even in the trash), event or certificate template point to it.
+func staticFileUsed(db *gorm.DB, file string, exceptUserID int) (bool, error) {
+    like := "%" + filepath.Base(file)
+    var urls, pictures, templates []string
+    if err := db.Unscoped().Model(&table.User{}).Where("id <> ? AND user_picture LIKE ?", exceptUserID, like).Pluck("user_picture", &pictures).Error; err != nil {
+        return false, err
+    }
+    if err := db.Unscoped().Model(&table.Event{}).Where("event_img LIKE ?", like).Pluck("event_img", &urls).Error; err != nil {
+        return false, err
+    }
+    if err := db.Unscoped().Model(&table.CertTemplate{}).Where("cert_template LIKE ?", like).Pluck("cert_template", &templates).Error; err != nil {
+        return false, err
+    }
+    for _, url := range append(urls, pictures...) {
+        if staticFileFromURL(url) == file {
+            return true, nil
+        }
+    }
+    for _, path := range templates {
+        if staticFile(path) == file {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+func writeExportJSON(zw *zip.Writer, name string, data any) error {
+    w, err := zw.Create(name)
+    if err != nil {
+        return err
+    }
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(data)
+}
+
+// NOTE: Everything we keep about one user, the secret (password, two factor,
+//       token hash) is never part of it.
+func writeUserExport(backend *Backend, user *table.User, out io.Writer) error {
+    var participants []table.EventParticipant
+    res := backend.db.Preload("Event").Where("user_id = ?", user.ID).Order("id ASC").Find(&participants)
+    if res.Error != nil {
+        return res.Error
+    }
+    participations := make([]exportParticipation, 0, len(participants))
+    for _, evPart := range participants {
+        item := exportParticipation{
+            EventID:    evPart.EventId,
+            EventName:  evPart.Event.EventName,
+            EventStart: evPart.Event.EventDStart,
+            EventEnd:   evPart.Event.EventDEnd,
+            Role:       string(evPart.EventPRole),
+            Attended:   evPart.EventPCome,
+            Waitlisted: evPart.EventPWait,
+            RegisterAt: evPart.CreatedAt,
+        }
+        if evPart.EventPCome && !evPart.EventPWait {
+            item.Certificate = fmt.Sprintf("%s/api/certificate/%s", backend.publicURL, evPart.EventPCode)
+        }
+        participations = append(participations, item)
+    }
+
+    var roles []string
+    res = backend.db.Model(&table.Role{}).
+        Joins("JOIN role_assignments ON role_assignments.role_id = roles.id AND role_assignments.deleted_at IS NULL").
+        Where("role_assignments.user_id = ?", user.ID).
+        Pluck("roles.role_name", &roles)
+    if res.Error != nil {
+        return res.Error
+    }
+
+    var sessionRows []table.Session
+    res = backend.db.Where("user_id = ?", user.ID).Order("id ASC").Find(&sessionRows)
+    if res.Error != nil {
+        return res.Error
+    }
+    sessions := make([]exportSession, 0, len(sessionRows))
+    for _, s := range sessionRows {
+        sessions = append(sessions, exportSession{
+            CreatedAt: s.CreatedAt,
+            LastUsed:  s.SessionLastUsed,
+            Expires:   s.SessionExpires,
+            Revoked:   s.SessionRevoked,
+            IP:        s.SessionIP,
+            Agent:     s.SessionAgent,
+        })
+    }
+
+    var tokenRows []table.ApiToken
+    res = backend.db.Where("user_id = ?", user.ID).Order("id ASC").Find(&tokenRows)
+    if res.Error != nil {
+        return res.Error
+    }
+    tokens := make([]exportToken, 0, len(tokenRows))
+    for _, t := range tokenRows {
+        tokens = append(tokens, exportToken{
+            Name:      t.TokenName,
+            Prefix:    t.TokenPrefix,
+            Scopes:    t.TokenScopes,
+            CreatedAt: t.CreatedAt,
+            Expires:   t.TokenExpires,
+            LastUsed:  t.TokenLastUsed,
+            LastIP:    t.TokenLastIP,
+            Revoked:   t.TokenRevoked,
+        })
+    }
+
+    var mailRows []table.EmailOutbox
+    res = backend.db.Where("mail_to = ?", user.UserEmail).Order("id ASC").Find(&mailRows)
+    if res.Error != nil {
+        return res.Error
+    }
+    emails := make([]exportEmail, 0, len(mailRows))
+    for _, m := range mailRows {
+        emails = append(emails, exportEmail{
+            Subject:   m.MailSubject,
+            Status:    string(m.Status),
+            CreatedAt: m.CreatedAt,
+            SentAt:    m.SentAt,
+        })
+    }
+
+    var securityRows []table.SecurityEvent
+    res = backend.db.Where("user_id = ?", user.ID).Order("id ASC").Find(&securityRows)
+    if res.Error != nil {
+        return res.Error
+    }
+    security := make([]fiber.Map, 0, len(securityRows))
+    for i := range securityRows {
+        security = append(security, securityEventJSON(&securityRows[i]))
+    }
+
+    totp, err := totpEnabled(backend.db, user.ID)
+    if err != nil {
+        return err
+    }
+
+    zw := zip.NewWriter(out)
+    files := []struct {
+        name string
+        data any
+    }{
+        {"user.json", userExportInfo(user, totp)},
+        {"participations.json", participations},
+        {"roles.json", roles},
+        {"sessions.json", sessions},
+        {"tokens.json", tokens},
+        {"emails.json", emails},
+        {"security.json", security},
+    }
+    for _, f := range files {
+        if err := writeExportJSON(zw, f.name, f.data); err != nil {
+            return err
+        }
+    }
+
+    if path := userPicturePath(user); path != "" {
+        picture, err := os.ReadFile(path)
+        if err == nil {
+            w, err := zw.Create("files/" + filepath.Base(path))
+            if err != nil {
+                return err
+            }
+            if _, err := w.Write(picture); err != nil {
+                return err
+            }
+        } else if !os.IsNotExist(err) {
+            return err
+        }
+    }
+
+    return zw.Close()
+}
+
+func userExportInfo(user *table.User, totp bool) map[string]any {
+    return map[string]any{
+        "id":             user.ID,
+        "name":           user.UserFullName,
+        "email":          user.UserEmail,
+        "instance":       user.UserInstance,
+        "role":           user.UserRole,
+        "picture":        user.UserPicture,
+        "created_at":     user.UserCreatedAt,
+        "updated_at":     user.UpdatedAt,
+        "single_sign_on": user.UserOidcSubject != "",
+        "two_factor":     totp,
+        "delete_at":      user.UserDeleteAt,
+        "pending_email":  user.UserPendingEmail,
+    }
+}
+
+// NOTE: Keep the attendance (the event count stay right) but nothing that
+//       point back to the person. The certificate code is made from the email
+//       so it is replaced too, the old link stop working.
+func anonymizeUser(backend *Backend, user *table.User) error {
+    oldEmail := user.UserEmail
+    picture := userPicturePath(user)
+
+    err := backend.db.Transaction(func(tx *gorm.DB) error {
+        var participants []table.EventParticipant
+        if err := tx.Where("user_id = ?", user.ID).Find(&participants).Error; err != nil {
+            return err
+        }
+        for _, evPart := range participants {
+            code, err := randomHex(16)
+            if err != nil {
+                return err
+            }
+            if err := tx.Model(&evPart).Update("eventp_code", code).Error; err != nil {
+                return err
+            }
+        }
+
+        err := tx.Model(user).Updates(map[string]any{
+            "user_email":         fmt.Sprintf("deleted-%d%s", user.ID, anonymizedEmailDomain),
+            "user_full_name":     accountDeletedName,
+            "user_instance":      "",
+            "user_picture":       "",
+            "user_password":      importNoPassword,
+            "user_oidc_subject":  "",
+            "user_totp_required": false,
+            "user_delete_at":     nil,
+            "user_pending_email": "",
+        }).Error
+        if err != nil {
+            return err
+        }
+
+        if _, err := revokeUserSessions(tx, user.ID); err != nil {
+            return err
+        }
+        now := time.Now()
+        if err := tx.Model(&table.ApiToken{}).Where("user_id = ? AND token_revoked IS NULL", user.ID).Update("token_revoked", now).Error; err != nil {
+            return err
+        }
+        deletes := []struct {
+            model any
+            query string
+            args  []any
+        }{
+            {&table.UserTOTP{}, "user_id = ?", []any{user.ID}},
+            {&table.TOTPRecoveryCode{}, "user_id = ?", []any{user.ID}},
+            {&table.RoleAssignment{}, "user_id = ?", []any{user.ID}},
+            {&table.SecurityEvent{}, "user_id = ?", []any{user.ID}},
+            {&table.OTP{}, "user_email = ?", []any{otpEmailKey(oldEmail)}},
+            {&table.EmailOutbox{}, "mail_to = ?", []any{oldEmail}},
+            {&table.LoginThrottle{}, "throttle_kind = ? AND throttle_key = ?", []any{table.ThrottleAccount, throttleAccountKey(oldEmail)}},
+        }
+        for _, d := range deletes {
+            if err := tx.Unscoped().Where(d.query, d.args...).Delete(d.model).Error; err != nil {
+                return err
+            }
+        }
+
+        return tx.Delete(user).Error
+    })
+    if err != nil {
+        return err
+    }
+
+    if picture != "" {
+        used, err := staticFileUsed(backend.db, picture, user.ID)
+        if err != nil {
+            log.Printf("Failed to check the picture of deleted user %d: %v", user.ID, err)
+        } else if !used {
+            if err := os.Remove(picture); err != nil && !os.IsNotExist(err) {
+                log.Printf("Failed to remove the picture of deleted user %d: %v", user.ID, err)
+            }
+        }
+    }
+    return nil
+}
+
+func processAccountDeletions(backend *Backend) error {
+    var users []table.User
+    res := backend.db.Where("user_delete_at IS NOT NULL AND user_delete_at <= ?", time.Now()).Find(&users)
+    if res.Error != nil {
+        return res.Error
+    }
+    var errs []error
+    for i := range users {
+        if err := anonymizeUser(backend, &users[i]); err != nil {
+            errs = append(errs, fmt.Errorf("user %d, %w", users[i].ID, err))
+            continue
+        }
+        log.Printf("User %d anonymized after the deletion request.", users[i].ID)
+    }
+    return errors.Join(errs...)
+}
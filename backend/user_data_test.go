@@ -0,0 +1,47 @@
+package main
+
+import (
+    "testing"
+    "webrpl/table"
+)
+
+func TestUserPicturePathOnlyOwnUpload(t *testing.T) {
+    user := &table.User{ID: 7}
+    cases := map[string]string{
+        "http://localhost:3000/static/user-7.png":   "static/user-7.png",
+        "http://localhost:3000/static/user-7":       "static/user-7",
+        "http://localhost:3000/static/user-8.png":   "",
+        "http://localhost:3000/static/user-70.png":  "",
+        "http://localhost:3000/static/3/index.html": "",
+        "http://localhost:3000/static/user-7.html":  "",
+        "/static/../user-7.png":                     "",
+        "":                                          "",
+    }
+    for url, want := range cases {
+        user.UserPicture = url
+        if got := userPicturePath(user); got != want {
+            t.Errorf("userPicturePath(%q) = %q, want %q", url, got, want)
+        }
+    }
+}
+
+func TestStaticFileUsed(t *testing.T) {
+    db := newTestDB(t, &table.User{}, &table.Event{}, &table.CertTemplate{})
+    users := []table.User{
+        {ID: 1, UserEmail: "a@x.com", UserPicture: "http://localhost:3000/static/user-1.png"},
+        {ID: 2, UserEmail: "b@x.com", UserPicture: "http://localhost:3000/static/user-1.png"},
+        {ID: 3, UserEmail: "c@x.com", UserPicture: "http://localhost:3000/static/user-3.png"},
+    }
+    if err := db.Create(&users).Error; err != nil {
+        t.Fatal(err)
+    }
+
+    used, err := staticFileUsed(db, "static/user-1.png", 1)
+    if err != nil || !used {
+        t.Fatalf("a picture still used by user 2 is not seen as used (%v)", err)
+    }
+    used, err = staticFileUsed(db, "static/user-3.png", 3)
+    if err != nil || used {
+        t.Fatalf("a picture only used by its owner is seen as used (%v)", err)
+    }
+}
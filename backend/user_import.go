@@ -0,0 +1,246 @@
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "time"
+    "webrpl/table"
+
+    "github.com/xuri/excelize/v2"
+    "gorm.io/gorm"
+)
+
+// NOTE: The imported user didnt have a password yet, `!` is never a valid bcrypt
+//       hash so nothing can login with it until the password is set.
+const (
+    importMaxRows    = 5000
+    importOTPTTL     = 72 * time.Hour
+    importNoPassword = "!"
+)
+
+type importRow struct {
+    Row      int      `json:"row"`
+    Email    string   `json:"email"`
+    Name     string   `json:"name"`
+    Instance string   `json:"instance"`
+    Role     string   `json:"role"`
+    Errors   []string `json:"errors,omitempty"`
+}
+
+// NOTE: The first row is the header, the column can be in any order. Only
+//       `email` and `name` is required.
+func parseImportRows(data []byte, format string) ([][]string, error) {
+    switch format {
+    case "csv":
+        reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+        reader.FieldsPerRecord = -1
+        reader.TrimLeadingSpace = true
+        var rows [][]string
+        for {
+            record, err := reader.Read()
+            if errors.Is(err, io.EOF) {
+                break
+            }
+            if err != nil {
+                return nil, err
+            }
+            rows = append(rows, record)
+        }
+        return rows, nil
+    case "xlsx":
+        xlsx, err := excelize.OpenReader(bytes.NewReader(data))
+        if err != nil {
+            return nil, err
+        }
+        defer xlsx.Close()
+        sheets := xlsx.GetSheetList()
+        if len(sheets) == 0 {
+            return nil, errors.New("the file has no sheet")
+        }
+        return xlsx.GetRows(sheets[0])
+    }
+    return nil, fmt.Errorf("unknown format %s", format)
+}
+
+func importColumns(header []string) (map[string]int, error) {
+    columns := map[string]int{}
+    for i, name := range header {
+        key := strings.ToLower(strings.TrimSpace(name))
+        switch key {
+        case "full_name", "fullname":
+            key = "name"
+        case "e-mail":
+            key = "email"
+        }
+        columns[key] = i
+    }
+    for _, required := range []string{"email", "name"} {
+        if _, ok := columns[required]; !ok {
+            return nil, fmt.Errorf("the header need the `%s` column", required)
+        }
+    }
+    return columns, nil
+}
+
+// NOTE: Check every row before anything is written, duplicate is checked inside
+//       the file and against the registered `user_email`. The role of each row
+//       have to be one the caller can give (see checkRoleGrant).
+func validateImportRows(db *gorm.DB, callerPerms []string, rows [][]string) ([]importRow, int, error) {
+    if len(rows) == 0 {
+        return nil, 0, errors.New("the file is empty")
+    }
+    columns, err := importColumns(rows[0])
+    if err != nil {
+        return nil, 0, err
+    }
+    if len(rows)-1 > importMaxRows {
+        return nil, 0, fmt.Errorf("too many rows, max is %d", importMaxRows)
+    }
+
+    cell := func(record []string, name string) string {
+        i, ok := columns[name]
+        if !ok || i >= len(record) {
+            return ""
+        }
+        return strings.TrimSpace(record[i])
+    }
+
+    var roles []string
+    if err := db.Model(&table.Role{}).Pluck("role_name", &roles).Error; err != nil {
+        return nil, 0, err
+    }
+    knownRole := map[string]bool{}
+    for _, role := range roles {
+        knownRole[role] = true
+    }
+    grantErr := map[string]error{}
+
+    result := make([]importRow, 0, len(rows)-1)
+    emails := make([]string, 0, len(rows)-1)
+    for i, record := range rows[1:] {
+        row := importRow{
+            Row:      i + 2,
+            Email:    strings.ToLower(cell(record, "email")),
+            Name:     cell(record, "name"),
+            Instance: cell(record, "instance"),
+            Role:     cell(record, "role"),
+        }
+        if row.Email == "" && row.Name == "" && row.Instance == "" && row.Role == "" {
+            continue
+        }
+        if row.Role == "" {
+            row.Role = roleParticipant
+        }
+        if row.Email == "" {
+            row.Errors = append(row.Errors, "email is empty")
+        } else if !isEmailValid(row.Email) {
+            row.Errors = append(row.Errors, "invalid email format")
+        }
+        if row.Name == "" {
+            row.Errors = append(row.Errors, "name is empty")
+        }
+        if !knownRole[row.Role] {
+            row.Errors = append(row.Errors, fmt.Sprintf("unknown role %s", row.Role))
+        } else {
+            err, checked := grantErr[row.Role]
+            if !checked {
+                err = checkRoleGrant(db, callerPerms, row.Role)
+                grantErr[row.Role] = err
+            }
+            if err != nil {
+                row.Errors = append(row.Errors, err.Error())
+            }
+        }
+        result = append(result, row)
+        emails = append(emails, row.Email)
+    }
+
+    var existing []string
+    for start := 0; start < len(emails); start += 500 {
+        end := min(start+500, len(emails))
+        var chunk []string
+        err := db.Model(&table.User{}).Where("LOWER(user_email) IN ?", emails[start:end]).Pluck("LOWER(user_email)", &chunk).Error
+        if err != nil {
+            return nil, 0, err
+        }
+        existing = append(existing, chunk...)
+    }
+    registered := map[string]bool{}
+    for _, email := range existing {
+        registered[email] = true
+    }
+
+    firstRow := map[string]int{}
+    invalid := 0
+    for i := range result {
+        row := &result[i]
+        if row.Email != "" {
+            if registered[row.Email] {
+                row.Errors = append(row.Errors, "email already registered")
+            }
+            if first, ok := firstRow[row.Email]; ok {
+                row.Errors = append(row.Errors, fmt.Sprintf("duplicate of row %d", first))
+            } else {
+                firstRow[row.Email] = row.Row
+            }
+        }
+        if len(row.Errors) > 0 {
+            invalid++
+        }
+    }
+    return result, invalid, nil
+}
+
+// NOTE: All or nothing. With `sendOTP` every new user get a set password code,
+//       the mail is queued on the same transaction.
+func importUsers(backend *Backend, rows []importRow, sendOTP bool) error {
+    return backend.db.Transaction(func(tx *gorm.DB) error {
+        for _, row := range rows {
+            legacyRole := 0
+            if row.Role == roleSuperAdmin {
+                legacyRole = 1
+            }
+            user := table.User{
+                UserFullName:  row.Name,
+                UserEmail:     row.Email,
+                UserPassword:  importNoPassword,
+                UserInstance:  row.Instance,
+                UserRole:      legacyRole,
+                UserCreatedAt: time.Now(),
+            }
+            if err := tx.Create(&user).Error; err != nil {
+                return fmt.Errorf("row %d: %w", row.Row, err)
+            }
+            if err := assignRole(tx, user.ID, row.Role); err != nil {
+                return fmt.Errorf("row %d: %w", row.Row, err)
+            }
+            if !sendOTP {
+                continue
+            }
+
+            code, err := createOTP(backend, tx, table.OTPReset, user.UserEmail, 8, importOTPTTL)
+            if err != nil {
+                return fmt.Errorf("row %d: %w", row.Row, err)
+            }
+
+            hours := int(importOTPTTL.Hours())
+            mail, err := buildEmail(backend, user.UserEmail, "Your webrpl account", "account-created", map[string]any{
+                "Name":  user.UserFullName,
+                "Email": user.UserEmail,
+                "Code":  code,
+                "Hours": hours,
+            }, fmt.Sprintf("An account has been made for you on webrpl with the email %s.\nUse this code to set your password : %s\n(Working for %d hours)", user.UserEmail, code, hours))
+            if err != nil {
+                return err
+            }
+            if err := queueEmail(backend, tx, mail); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
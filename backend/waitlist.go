@@ -0,0 +1,97 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "webrpl/table"
+
+    "gorm.io/gorm"
+)
+
+// NOTE: Only normal participant that not on the waitlist take a seat,
+//       committee is not counted.
+func countEventSeatTaken(db *gorm.DB, eventID int) (int64, error) {
+    var taken int64
+    res := db.Model(&table.EventParticipant{}).
+        Where("event_id = ? AND eventp_role = ? AND eventp_wait = ?", eventID, table.NormalU, false).
+        Count(&taken)
+    return taken, res.Error
+}
+
+// NOTE: event_max of <= 0 mean there is no limit (old event before the waitlist exist).
+func isEventFull(db *gorm.DB, event *table.Event) (bool, error) {
+    if event.EventMax <= 0 {
+        return false, nil
+    }
+    taken, err := countEventSeatTaken(db, event.ID)
+    if err != nil {
+        return false, err
+    }
+    return taken >= int64(event.EventMax), nil
+}
+
+// NOTE: Move the oldest participant on the waitlist into the free seat (if any)
+//       and email them, return the participant that got promoted.
+func promoteWaitlist(backend *Backend, eventID int) ([]table.EventParticipant, error) {
+    var event table.Event
+    var promoted []table.EventParticipant
+
+    err := backend.db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("id = ?", eventID).First(&event).Error; err != nil {
+            return err
+        }
+
+        query := tx.Preload("User").
+            Where("event_id = ? AND eventp_wait = ?", eventID, true).
+            Order("created_at ASC").Order("id ASC")
+
+        if event.EventMax > 0 {
+            taken, err := countEventSeatTaken(tx, eventID)
+            if err != nil {
+                return err
+            }
+            free := int64(event.EventMax) - taken
+            if free <= 0 {
+                return nil
+            }
+            query = query.Limit(int(free))
+        }
+
+        if err := query.Find(&promoted).Error; err != nil {
+            return err
+        }
+
+        for i := range promoted {
+            promoted[i].EventPWait = false
+            if err := tx.Model(&promoted[i]).Update("eventp_wait", false).Error; err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    for _, evPart := range promoted {
+        start := event.EventDStart.Format("02 Jan 2006 15:04")
+        mail, err := buildEmail(backend, evPart.User.UserEmail,
+            fmt.Sprintf("You got a seat on %s", event.EventName),
+            "waitlist", map[string]any{
+                "Name":  evPart.User.UserFullName,
+                "Event": event.EventName,
+                "Start": start,
+            },
+            fmt.Sprintf("Hi %s,\n\nA seat is now available and you have been moved from the waitlist to the participant list of \"%s\" (%s).\n",
+                evPart.User.UserFullName, event.EventName, start))
+        if err != nil {
+            log.Printf("Failed to build the waitlist email: %v", err)
+            continue
+        }
+        if err := queueEmail(backend, backend.db, mail); err != nil {
+            log.Printf("Failed to queue the waitlist email: %v", err)
+        }
+    }
+
+    return promoted, nil
+}
@@ -0,0 +1,42 @@
+import type { Handle } from '@sveltejs/kit';
+import { env } from '$env/dynamic/private';
+import jwt from 'jsonwebtoken';
+import { SESSION_MAX_AGE } from '$lib/server/auth';
+
+// The backend access token only live for a few minutes, so swap the refresh
+// token for a new pair before it run out. Every api route keep reading the
+// `user` cookie as before.
+function needRefresh(token: string | undefined): boolean {
+	if (!token) return true;
+	const decoded = jwt.decode(token) as jwt.JwtPayload | null;
+	if (!decoded?.exp) return true;
+	return decoded.exp * 1000 - Date.now() < 60 * 1000;
+}
+
+export const handle: Handle = async ({ event, resolve }) => {
+	const refresh = event.cookies.get('refresh');
+
+	if (refresh && needRefresh(event.cookies.get('user'))) {
+		try {
+			const res = await fetch(`${env.PRIVATE_API_URL}/api/refresh`, {
+				method: 'POST',
+				headers: { 'Content-Type': 'application/json' },
+				body: JSON.stringify({ refresh_token: refresh })
+			});
+
+			if (res.ok) {
+				const data = await res.json();
+				const options = { path: '/', httpOnly: true, secure: false, maxAge: SESSION_MAX_AGE };
+				event.cookies.set('user', data.token, options);
+				event.cookies.set('refresh', data.refresh_token, options);
+			} else if (res.status === 401) {
+				event.cookies.delete('user', { path: '/' });
+				event.cookies.delete('refresh', { path: '/' });
+			}
+		} catch (err) {
+			console.error('Failed to refresh the session:', err);
+		}
+	}
+
+	return resolve(event);
+};
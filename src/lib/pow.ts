@@ -0,0 +1,45 @@
+// The backend only send an OTP for a solved challenge, so a script cant spam the
+// email for free. The nonce is good when sha256(challenge + ":" + nonce) start
+// with `difficulty` zero bit, same as verifyOTPChallenge in the backend.
+
+export interface OtpChallenge {
+	challenge: string;
+	nonce: string;
+}
+
+function leadingZeroBits(sum: Uint8Array): number {
+	let n = 0;
+	for (const b of sum) {
+		if (b !== 0) return n + Math.clz32(b) - 24;
+		n += 8;
+	}
+	return n;
+}
+
+export async function solveChallenge(challenge: string, difficulty: number): Promise<string> {
+	const encoder = new TextEncoder();
+	const batch = 256;
+	for (let start = 0; ; start += batch) {
+		const sums = await Promise.all(
+			Array.from({ length: batch }, (_, i) =>
+				crypto.subtle.digest('SHA-256', encoder.encode(`${challenge}:${start + i}`))
+			)
+		);
+		for (let i = 0; i < batch; i++) {
+			if (leadingZeroBits(new Uint8Array(sums[i])) >= difficulty) {
+				return String(start + i);
+			}
+		}
+	}
+}
+
+// Ask a challenge from /api/otp-challenge and solve it, pass both to /api/send-otp.
+export async function getOtpChallenge(): Promise<OtpChallenge> {
+	const res = await fetch('/api/otp-challenge');
+	if (!res.ok) {
+		throw new Error(await res.text());
+	}
+	const { data } = await res.json();
+	const nonce = await solveChallenge(data.challenge, data.difficulty);
+	return { challenge: data.challenge, nonce };
+}
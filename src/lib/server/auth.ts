@@ -1,9 +1,19 @@
 import jwt from 'jsonwebtoken';
 import { PRIVATE_JWT_SECRET } from '$env/static/private';
 
+// Same as the refresh token lifetime on the backend.
+export const SESSION_MAX_AGE = 60 * 60 * 24 * 7;
+
+// Same as the pending state lifetime on the backend. The cookie keep the
+// backend login binding (wrpl_oidc) for the browser.
+export const OIDC_BACKEND_COOKIE = 'wrpl_oidc';
+export const OIDC_STATE_COOKIE = 'oidc_state';
+export const OIDC_STATE_MAX_AGE = 60 * 10;
+
 export interface UserClaims extends jwt.JwtPayload {
 	email: string;
 	admin: number;
+	sid: string;
 	exp: number;
 }
 
@@ -0,0 +1,13 @@
+import type { RequestEvent } from '@sveltejs/kit';
+
+// The backend only see this server, pass the browser and its address along so the
+// login history and the failed login limit are about the real client. The backend
+// only trust X-Forwarded-For from WRPL_TRUSTED_PROXIES.
+export function clientHeaders(event: Pick<RequestEvent, 'request' | 'getClientAddress'>): Record<string, string> {
+	const headers: Record<string, string> = {
+		'X-Forwarded-For': event.getClientAddress()
+	};
+	const agent = event.request.headers.get('user-agent');
+	if (agent) headers['User-Agent'] = agent;
+	return headers;
+}
@@ -80,6 +80,9 @@ export interface User {
 	UserInstance: string;
 	UserRole: number;
 	UserPicture: string; //IGNORE
+	UserTOTPRequired?: boolean;
+	UserDeleteAt?: string | null; // set when the user asked to delete the account
+	UserPendingEmail?: string; // the new email waiting for the OTP
 
 	EventParticipants?: EventParticipant[];
 }
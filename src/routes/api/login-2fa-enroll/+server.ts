@@ -0,0 +1,27 @@
+import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
+
+export const POST: RequestHandler = async ({ request }) => {
+  try {
+    const body = await request.json();
+
+    const res = await fetch(`${env.PRIVATE_API_URL}/api/login-2fa-enroll`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body)
+    });
+
+    const data = await res.json();
+    if (!res.ok) {
+      return new Response(data.message, { status: res.status });
+    }
+
+    return new Response(JSON.stringify(data.data), {
+      status: 200,
+      headers: { 'Content-Type': 'application/json' }
+    });
+  } catch (err) {
+    console.error('API error:', err);
+    return new Response('Internal Server Error', { status: 500 });
+  }
+};
@@ -0,0 +1,43 @@
+import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
+import { SESSION_MAX_AGE } from '$lib/server/auth';
+import { clientHeaders } from '$lib/server/forward';
+
+// Second step of the login, `code` is from the authenticator app or a recovery code.
+export const POST: RequestHandler = async ({ request, cookies, getClientAddress }) => {
+  try {
+    const body = await request.json();
+
+    const res = await fetch(`${env.PRIVATE_API_URL}/api/login-2fa`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json', ...clientHeaders({ request, getClientAddress }) },
+      body: JSON.stringify(body)
+    });
+
+    const data = await res.json();
+    if (!res.ok) {
+      return new Response(data.message, { status: 401 });
+    }
+
+    cookies.set('user', data.token, {
+      path: '/',
+      httpOnly: true,
+      secure: false,
+      maxAge: SESSION_MAX_AGE
+    });
+    cookies.set('refresh', data.refresh_token, {
+      path: '/',
+      httpOnly: true,
+      secure: false,
+      maxAge: SESSION_MAX_AGE
+    });
+
+    return new Response(JSON.stringify({ recovery_codes: data.recovery_codes ?? null }), {
+      status: 200,
+      headers: { 'Content-Type': 'application/json' }
+    });
+  } catch (err) {
+    console.error('API error:', err);
+    return new Response('Internal Server Error', { status: 500 });
+  }
+};
@@ -1,7 +1,9 @@
 import type { RequestHandler } from './$types';
 import { env } from '$env/dynamic/private';
+import { SESSION_MAX_AGE } from '$lib/server/auth';
+import { clientHeaders } from '$lib/server/forward';
 
-export const POST: RequestHandler = async ({ request, cookies }) => {
+export const POST: RequestHandler = async ({ request, cookies, getClientAddress }) => {
   try {
     const body = await request.json();
 
@@ -9,7 +11,7 @@ export const POST: RequestHandler = async ({ request, cookies }) => {
     console.log(url, body);
     const res = await fetch(url, {
       method: 'POST',
-      headers: { 'Content-Type': 'application/json' },
+      headers: { 'Content-Type': 'application/json', ...clientHeaders({ request, getClientAddress }) },
       body: JSON.stringify(body)
     });
 
@@ -21,11 +23,25 @@ export const POST: RequestHandler = async ({ request, cookies }) => {
 
     const data = await res.json();
 
+    // Two factor is on, the page ask for the code and finish on /api/login-2fa.
+    if (data.data?.mfa_required) {
+      return new Response(JSON.stringify(data.data), {
+        status: 200,
+        headers: { 'Content-Type': 'application/json' }
+      });
+    }
+
     cookies.set('user', data.token, {
       path: '/',
       httpOnly: true,
       secure: false,
-      maxAge: 60 * 60 * 24
+      maxAge: SESSION_MAX_AGE
+    });
+    cookies.set('refresh', data.refresh_token, {
+      path: '/',
+      httpOnly: true,
+      secure: false,
+      maxAge: SESSION_MAX_AGE
     });
 
     return new Response('ok', { status: 200 });
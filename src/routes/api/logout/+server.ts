@@ -1,10 +1,32 @@
 import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
 
 export const POST: RequestHandler = async ({ cookies }) => {
+  const token = cookies.get('user');
+
+  // Revoke the session on the backend too, not only forget the cookie.
+  if (token) {
+    try {
+      await fetch(`${env.PRIVATE_API_URL}/api/protected/logout`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          Authorization: `Bearer ${token}`
+        }
+      });
+    } catch (err) {
+      console.error('API error:', err);
+    }
+  }
+
   cookies.set('user', '', {
     path: '/',
     expires: new Date(0),
   });
+  cookies.set('refresh', '', {
+    path: '/',
+    expires: new Date(0),
+  });
 
   return new Response('Logged out', { status: 200 });
 };
@@ -0,0 +1,18 @@
+import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
+
+export const GET: RequestHandler = async () => {
+  try {
+    const res = await fetch(`${env.PRIVATE_API_URL}/api/otp-challenge`);
+
+    return new Response(await res.text(), {
+      status: res.status,
+      headers: {
+        'Content-Type': res.headers.get('Content-Type') || 'application/json'
+      }
+    });
+  } catch (err) {
+    console.error('API error:', err);
+    return new Response('Internal Server Error', { status: 500 });
+  }
+};
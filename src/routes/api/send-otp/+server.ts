@@ -1,7 +1,8 @@
 import type { RequestHandler } from './$types';
 import { env } from '$env/dynamic/private';
+import { clientHeaders } from '$lib/server/forward';
 
-export const POST: RequestHandler = async ({ request }) => {
+export const POST: RequestHandler = async ({ request, getClientAddress }) => {
   try {
     const body = await request.json();
     
@@ -9,20 +10,34 @@ export const POST: RequestHandler = async ({ request }) => {
       return new Response('Email is required', { status: 400 });
     }
     
-    const encodedEmail = encodeURIComponent(body.email);
-    const url = `${env.PRIVATE_API_URL}/api/gen-otp-for-register?email=${encodedEmail}`;
-    
-    console.log("Sending request to:", url);
+    // The challenge is solved by the browser, see $lib/pow.
+    const params = new URLSearchParams({
+      email: body.email,
+      purpose: body.purpose || 'register',
+      challenge: body.challenge || '',
+      nonce: body.nonce || ''
+    });
+    const url = `${env.PRIVATE_API_URL}/api/gen-otp-for-register?${params}`;
     
+    // The cooldown and daily cap is per ip, so pass the real client along.
     const res = await fetch(url, {
       method: 'GET',
-      headers: { 'Content-Type': 'application/json' },
+      headers: { 'Content-Type': 'application/json', ...clientHeaders({ request, getClientAddress }) },
     });
 
     if (!res.ok) {
       const errorText = await res.text();
       console.error("OTP generation failed with status:", res.status, errorText);
-      return new Response(`Failed to generate OTP Code: ${errorText}`, { status: res.status });
+      let message = errorText;
+      try {
+        message = JSON.parse(errorText).message;
+      } catch {
+        // Not json, keep the text.
+      }
+      const headers: Record<string, string> = {};
+      const retryAfter = res.headers.get('Retry-After');
+      if (retryAfter) headers['Retry-After'] = retryAfter;
+      return new Response(message, { status: res.status, headers });
     }
 
     return new Response('OTP sent successfully', { status: 200 });
@@ -30,4 +45,4 @@ export const POST: RequestHandler = async ({ request }) => {
     console.error('API error:', err);
     return new Response('Internal Server Error', { status: 500 });
   }
-};
\ No newline at end of file
+};
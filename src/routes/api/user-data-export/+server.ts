@@ -0,0 +1,29 @@
+import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
+
+export const GET: RequestHandler = async ({ cookies }) => {
+  try {
+    const token = cookies.get('user');
+    if (!token) {
+      return new Response('Authentication token not found', { status: 401 });
+    }
+
+    const res = await fetch(`${env.PRIVATE_API_URL}/api/protected/user-data-export`, {
+      headers: {
+        Authorization: `Bearer ${token}`
+      }
+    });
+
+    // Pass the zip through as it is, the header keep the file name.
+    return new Response(res.body, {
+      status: res.status,
+      headers: {
+        'Content-Type': res.headers.get('Content-Type') || 'application/zip',
+        'Content-Disposition': res.headers.get('Content-Disposition') || 'attachment'
+      }
+    });
+  } catch (err) {
+    console.error('API error:', err);
+    return new Response('Internal Server Error', { status: 500 });
+  }
+};
@@ -0,0 +1,32 @@
+import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
+
+export const POST: RequestHandler = async ({ request, cookies }) => {
+  try {
+    const token = cookies.get('user');
+    if (!token) {
+      return new Response('Authentication token not found', { status: 401 });
+    }
+
+    const body = await request.text();
+
+    const res = await fetch(`${env.PRIVATE_API_URL}/api/protected/user-delete-cancel`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${token}`
+      },
+      body: body || '{}'
+    });
+
+    return new Response(await res.text(), {
+      status: res.status,
+      headers: {
+        'Content-Type': res.headers.get('Content-Type') || 'application/json'
+      }
+    });
+  } catch (err) {
+    console.error('API error:', err);
+    return new Response('Internal Server Error', { status: 500 });
+  }
+};
@@ -0,0 +1,44 @@
+import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
+
+export const POST: RequestHandler = async ({ request, cookies }) => {
+  try {
+    const token = cookies.get('user');
+    if (!token) {
+      return new Response('Authentication token not found', { status: 401 });
+    }
+
+    const body = await request.text();
+
+    const res = await fetch(`${env.PRIVATE_API_URL}/api/protected/user-email-change-confirm`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: `Bearer ${token}`
+      },
+      body: body || '{}'
+    });
+
+    // Every session is revoked by the change, forget the cookie too.
+    if (res.ok) {
+      cookies.set('user', '', {
+        path: '/',
+        expires: new Date(0),
+      });
+      cookies.set('refresh', '', {
+        path: '/',
+        expires: new Date(0),
+      });
+    }
+
+    return new Response(await res.text(), {
+      status: res.status,
+      headers: {
+        'Content-Type': res.headers.get('Content-Type') || 'application/json'
+      }
+    });
+  } catch (err) {
+    console.error('API error:', err);
+    return new Response('Internal Server Error', { status: 500 });
+  }
+};
@@ -0,0 +1,57 @@
+import { redirect } from '@sveltejs/kit';
+import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
+import { OIDC_BACKEND_COOKIE, OIDC_STATE_COOKIE, SESSION_MAX_AGE } from '$lib/server/auth';
+import { clientHeaders } from '$lib/server/forward';
+
+// The identity provider come back here, the backend check the code and give the
+// same token as the password login. The backend only accept the state with the
+// binding from /oidc/login, or someone could make this browser finish their own login.
+export const GET: RequestHandler = async ({ url, cookies, request, getClientAddress }) => {
+  const binding = cookies.get(OIDC_STATE_COOKIE);
+  cookies.delete(OIDC_STATE_COOKIE, { path: '/oidc' });
+  if (!binding) {
+    return new Response('Login gagal: state tidak valid, silakan coba login lagi.', { status: 401 });
+  }
+
+  let mfaRedirect: string | null = null;
+  try {
+    const res = await fetch(`${env.PRIVATE_API_URL}/api/oidc/callback${url.search}`, {
+      headers: {
+        ...clientHeaders({ request, getClientAddress }),
+        Cookie: `${OIDC_BACKEND_COOKIE}=${binding}`
+      }
+    });
+    const data = await res.json();
+
+    if (!res.ok) {
+      return new Response('Login gagal: ' + data.message, { status: 401 });
+    }
+
+    if (data.data?.mfa_required) {
+      const query = new URLSearchParams({
+        mfa_token: data.data.mfa_token,
+        mfa_enroll: String(data.data.mfa_enroll)
+      });
+      mfaRedirect = `/login?${query}`;
+    } else {
+      cookies.set('user', data.token, {
+        path: '/',
+        httpOnly: true,
+        secure: false,
+        maxAge: SESSION_MAX_AGE
+      });
+      cookies.set('refresh', data.refresh_token, {
+        path: '/',
+        httpOnly: true,
+        secure: false,
+        maxAge: SESSION_MAX_AGE
+      });
+    }
+  } catch (err) {
+    console.error('API error:', err);
+    return new Response('Internal Server Error', { status: 500 });
+  }
+
+  throw redirect(303, mfaRedirect ?? '/dashboard');
+};
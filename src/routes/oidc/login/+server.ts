@@ -0,0 +1,41 @@
+import { redirect } from '@sveltejs/kit';
+import type { RequestHandler } from './$types';
+import { env } from '$env/dynamic/private';
+import { OIDC_BACKEND_COOKIE, OIDC_STATE_COOKIE, OIDC_STATE_MAX_AGE } from '$lib/server/auth';
+
+// The backend answer with a redirect to the identity provider, pass it to the browser.
+// The backend binding cookie is kept for this browser so the callback only accept
+// the login this browser started.
+export const GET: RequestHandler = async ({ cookies }) => {
+  let location: string | null = null;
+  let binding: string | null = null;
+  try {
+    const res = await fetch(`${env.PRIVATE_API_URL}/api/oidc/login`, { redirect: 'manual' });
+    location = res.headers.get('location');
+    if (!location) {
+      const realError = await res.json();
+      return new Response(realError.message, { status: res.status });
+    }
+    for (const cookie of res.headers.getSetCookie()) {
+      const [pair] = cookie.split(';');
+      const [name, value] = pair.split('=');
+      if (name.trim() === OIDC_BACKEND_COOKIE) binding = value.trim();
+    }
+  } catch (err) {
+    console.error('API error:', err);
+    return new Response('Internal Server Error', { status: 500 });
+  }
+
+  if (!binding) {
+    return new Response('Login gagal: state tidak ada', { status: 502 });
+  }
+  cookies.set(OIDC_STATE_COOKIE, binding, {
+    path: '/oidc',
+    httpOnly: true,
+    secure: false,
+    sameSite: 'lax',
+    maxAge: OIDC_STATE_MAX_AGE
+  });
+
+  throw redirect(302, location);
+};